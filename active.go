@@ -0,0 +1,239 @@
+package ftp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// activeAcceptTimeout bounds the wait for the server to connect to the
+// listener of an active mode data connection, unless a deadline is set.
+const activeAcceptTimeout = 30 * time.Second
+
+// DialWithActiveMode returns a DialOption that configures the ServerConn to
+// use active mode data connections: the client listens and the server
+// connects to it, for servers unreachable in passive mode.
+// The client listens on the local address of the control connection, or on
+// the IP given to DialWithDataBindIP, which must be reachable by the server.
+// The address is sent with PORT for IPv4, and with EPRT (RFC 2428) for IPv6
+// or when the server advertises EPRT. Each falls back to the other for IPv4
+// if the server rejects it.
+// The connections to the listener from another host than the one of the
+// control connection are closed, waiting for the one of the server.
+func DialWithActiveMode(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.activeMode = enabled
+	}}
+}
+
+// openActiveDataConn listens for the data connection and sends its address.
+func (c *ServerConn) openActiveDataConn() (net.Conn, error) {
+	ip := c.options.dataBindIP
+	if ip == nil {
+		local, ok := c.netConn.LocalAddr().(*net.TCPAddr)
+		if !ok {
+			return nil, errors.New("ftp: active mode requires a TCP control connection")
+		}
+		ip = local.IP
+	}
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sendPort(ip, l.Addr().(*net.TCPAddr).Port); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	a := &activeConn{listener: l, tune: c.tuneDataConn}
+	if remote, ok := c.netConn.RemoteAddr().(*net.TCPAddr); ok {
+		a.serverIP = remote.IP
+	}
+	return a, nil
+}
+
+// sendPort sends the address of the listener with PORT or EPRT.
+func (c *ServerConn) sendPort(ip net.IP, port int) error {
+	ip4 := ip.To4()
+	useEPRT := ip4 == nil || (c.HasFeature("EPRT") && !c.noEPRT) || c.noPORT
+
+	for {
+		var err error
+		if useEPRT {
+			family := 2
+			if ip4 != nil {
+				family = 1
+			}
+			_, _, err = c.cmd(StatusCommandOK, "EPRT |%d|%s|%d|", family, ip, port)
+		} else {
+			_, _, err = c.cmd(StatusCommandOK, "PORT %d,%d,%d,%d,%d,%d", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff)
+		}
+		if err == nil || ip4 == nil || !isNotImplemented(err) {
+			return err
+		}
+
+		// Try the other command, once
+		if useEPRT {
+			c.noEPRT = true
+		} else {
+			c.noPORT = true
+		}
+		if c.noEPRT && c.noPORT {
+			return err
+		}
+		useEPRT = !useEPRT
+	}
+}
+
+// activeConn is an active mode data connection. The connection of the
+// server is accepted on first use, once the transfer command is sent.
+type activeConn struct {
+	listener *net.TCPListener
+	serverIP net.IP // the IP of the control connection, nil if unknown
+	tune     func(net.Conn) error
+
+	acceptMu sync.Mutex // held during Accept
+
+	mu            sync.Mutex // protects the fields below
+	conn          net.Conn
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// accept returns the connection of the server, accepting it if needed.
+func (a *activeConn) accept() (net.Conn, error) {
+	a.acceptMu.Lock()
+	defer a.acceptMu.Unlock()
+
+	a.mu.Lock()
+	conn, err := a.conn, a.err
+	deadline := a.readDeadline
+	if deadline.IsZero() || (!a.writeDeadline.IsZero() && a.writeDeadline.Before(deadline)) {
+		deadline = a.writeDeadline
+	}
+	a.mu.Unlock()
+	if conn != nil || err != nil {
+		return conn, err
+	}
+
+	if deadline.IsZero() {
+		deadline = time.Now().Add(activeAcceptTimeout)
+	}
+	a.listener.SetDeadline(deadline)
+	conn, err = a.acceptServer()
+	a.listener.Close()
+	if err == nil {
+		err = a.tune(conn)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		if a.err == nil {
+			a.err = err
+		}
+		return nil, a.err
+	}
+	if a.err != nil {
+		// Closed during Accept
+		conn.Close()
+		return nil, a.err
+	}
+	conn.SetReadDeadline(a.readDeadline)
+	conn.SetWriteDeadline(a.writeDeadline)
+	a.conn = conn
+	return conn, nil
+}
+
+// acceptServer accepts the connection of the server, closing the ones from
+// other hosts until the deadline of the listener.
+func (a *activeConn) acceptServer() (net.Conn, error) {
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if a.serverIP == nil || (ok && remote.IP.Equal(a.serverIP)) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+func (a *activeConn) Read(p []byte) (int, error) {
+	conn, err := a.accept()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(p)
+}
+
+func (a *activeConn) Write(p []byte) (int, error) {
+	conn, err := a.accept()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(p)
+}
+
+func (a *activeConn) Close() error {
+	// Unblocks a pending Accept
+	a.listener.Close()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		a.err = net.ErrClosed
+	}
+	if a.conn != nil {
+		return a.conn.Close()
+	}
+	return nil
+}
+
+func (a *activeConn) LocalAddr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn != nil {
+		return a.conn.LocalAddr()
+	}
+	return a.listener.Addr()
+}
+
+func (a *activeConn) RemoteAddr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn != nil {
+		return a.conn.RemoteAddr()
+	}
+	return a.listener.Addr()
+}
+
+func (a *activeConn) SetDeadline(t time.Time) error {
+	a.SetReadDeadline(t)
+	return a.SetWriteDeadline(t)
+}
+
+func (a *activeConn) SetReadDeadline(t time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.readDeadline = t
+	if a.conn != nil {
+		return a.conn.SetReadDeadline(t)
+	}
+	return a.listener.SetDeadline(t)
+}
+
+func (a *activeConn) SetWriteDeadline(t time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.writeDeadline = t
+	if a.conn != nil {
+		return a.conn.SetWriteDeadline(t)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package ftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func testActiveRetr(t *testing.T, c *ServerConn) {
+	t.Helper()
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testData {
+		t.Errorf("read %q, expected %q", data, testData)
+	}
+}
+
+func TestActiveModePORT(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithActiveMode(true))
+
+	testActiveRetr(t, c)
+	if _, err := c.Stor("file", bytes.NewBufferString(testData)); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"PORT", "RETR", "PORT", "STOR"})
+}
+
+func TestActiveModeEPRT(t *testing.T) {
+	mock, c := openConn(t, "[::1]", DialWithActiveMode(true))
+	testActiveRetr(t, c)
+	closeConn(t, mock, c, []string{"EPRT", "RETR"})
+
+	// Advertised by the server
+	mock, c = openConn(t, "127.0.0.1", DialWithActiveMode(true), DialWithFeatureOverride("EPRT", true))
+	testActiveRetr(t, c)
+	closeConn(t, mock, c, []string{"EPRT", "RETR"})
+}
+
+func TestActiveModeFallback(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"PORT": {"500 Unknown command."},
+	}, DialWithActiveMode(true))
+
+	// The rejected command is not sent again
+	testActiveRetr(t, c)
+	testActiveRetr(t, c)
+
+	closeConn(t, mock, c, []string{"PORT", "EPRT", "RETR", "EPRT", "RETR"})
+}
+
+func TestActiveModeForeignConn(t *testing.T) {
+	// 127.0.0.2 is not configured on every loopback interface
+	l, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip("127.0.0.2 unavailable:", err)
+	}
+	l.Close()
+
+	mock, c := openConn(t, "127.0.0.1", DialWithActiveMode(true))
+	mock.foreignIP = net.IPv4(127, 0, 0, 2)
+
+	// The connection from another host is not taken for the data
+	testActiveRetr(t, c)
+
+	closeConn(t, mock, c, []string{"PORT", "RETR"})
+}
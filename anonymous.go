@@ -0,0 +1,89 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrAnonymousRefused is matched by the error returned by LoginAnonymous when
+// the server does not accept anonymous logins.
+var ErrAnonymousRefused = errors.New("ftp: anonymous login refused")
+
+// ErrReadOnly is returned by the methods modifying the server files, without
+// sending any command, when the session was opened with LoginAnonymous.
+var ErrReadOnly = errors.New("ftp: session is read-only")
+
+// anonymousRefusals are the texts of the 331 replies of servers refusing
+// anonymous logins before the password.
+var anonymousRefusals = []string{
+	"not allowed",
+	"not permitted",
+	"denied",
+	"disabled",
+	"refused",
+	"no anonymous",
+}
+
+// LoginAnonymous authenticates the client with the "anonymous" user and email
+// as password, following RFC 1635. A default address is used if email is
+// empty.
+//
+// An error matching ErrAnonymousRefused is returned if the server rejects
+// the login by its reply code, or refuses the anonymous user in the text of
+// its 331 reply. The text of a 230 reply is not checked: it may mention
+// other restrictions, such as "Uploads are disabled".
+// The session is then read-only: Stor, Rename, Delete, MakeDir and the other
+// methods modifying files fail with ErrReadOnly until Logout.
+// The deadline and cancellation of ctx bound the login.
+func (c *ServerConn) LoginAnonymous(ctx context.Context, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer c.withContext(ctx)()
+
+	if email == "" {
+		email = "anonymous@"
+	}
+	return c.login("anonymous", email, true)
+}
+
+// ReadOnly reports whether the session was opened with LoginAnonymous.
+func (c *ServerConn) ReadOnly() bool {
+	return c.readOnly
+}
+
+// authenticateAnonymous issues the USER anonymous and PASS FTP commands.
+func (c *ServerConn) authenticateAnonymous(email string) error {
+	code, message, err := c.cmd(-1, "USER anonymous")
+	if err != nil {
+		return err
+	}
+
+	// "331 Guest login ok, send your email address as password"
+	if code == StatusUserOK {
+		if isAnonymousRefusal(message) {
+			return fmt.Errorf("%w: %d %s", ErrAnonymousRefused, code, message)
+		}
+		code, message, err = c.cmd(-1, "PASS %s", email)
+		if err != nil {
+			return err
+		}
+	}
+
+	if code != StatusLoggedIn {
+		return fmt.Errorf("%w: %d %s", ErrAnonymousRefused, code, message)
+	}
+	return nil
+}
+
+func isAnonymousRefusal(message string) bool {
+	message = strings.ToLower(message)
+	for _, refusal := range anonymousRefusals {
+		if strings.Contains(message, refusal) {
+			return true
+		}
+	}
+	return false
+}
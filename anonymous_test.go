@@ -0,0 +1,104 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLoginAnonymous(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.cannedReplies = map[string][]string{
+		"USER": {"331 Guest login ok, send your complete e-mail address as password"},
+		"PASS": {"230 Guest login ok, access restrictions apply"},
+	}
+
+	c, err := Dial(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoginAnonymous(context.Background(), "user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if !c.ReadOnly() {
+		t.Error("expected a read-only session")
+	}
+
+	if _, err := c.Stor("file", bytes.NewBufferString(testData)); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if _, err := c.Delete("file"); err != ErrReadOnly {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.Wait()
+
+	expected := []string{"FEAT", "USER anonymous", "PASS user@example.com", "TYPE I", "QUIT"}
+	if !reflect.DeepEqual(mock.lines, expected) {
+		t.Errorf("expected %v, got %v", expected, mock.lines)
+	}
+}
+
+func TestLoginAnonymousRestricted(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.cannedReplies = map[string][]string{
+		"USER": {"331 Please specify the password."},
+		"PASS": {"230 Login successful. Uploads are disabled."},
+	}
+
+	c, err := Dial(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoginAnonymous(context.Background(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !c.ReadOnly() {
+		t.Error("expected a read-only session")
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.Wait()
+}
+
+func TestLoginAnonymousRefused(t *testing.T) {
+	for _, replies := range []map[string][]string{
+		{"USER": {"530 This FTP server does not allow anonymous logins"}},
+		{"USER": {"331 Anonymous login not allowed"}},
+		{"PASS": {"530 Login incorrect"}},
+	} {
+		mock, err := newFtpMock(t, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mock.cannedReplies = replies
+
+		c, err := Dial(mock.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.LoginAnonymous(context.Background(), "")
+		if !errors.Is(err, ErrAnonymousRefused) {
+			t.Errorf("%v: expected ErrAnonymousRefused, got %v", replies, err)
+		}
+
+		c.Quit()
+		mock.Wait()
+		mock.Close()
+	}
+}
@@ -0,0 +1,160 @@
+package ftp
+
+import (
+	"context"
+	"time"
+)
+
+// Batch is a list of independent commands sent together by ExecBatch, before
+// their replies are read, which saves a round-trip per command.
+// The zero value is an empty batch.
+type Batch struct {
+	cmds []batchCommand
+}
+
+type batchCommand struct {
+	expected int
+	format   string
+	args     []interface{}
+	write    bool // refused on read-only connections
+}
+
+// BatchReply is the reply to a command of a Batch.
+type BatchReply struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+// Time returns the time of the reply to a command added by GetTime.
+func (r BatchReply) Time() (time.Time, error) {
+	if r.Err != nil {
+		return time.Time{}, r.Err
+	}
+	return parseMDTM(r.Message)
+}
+
+// Add appends a command, formatted with fmt.Sprintf, expecting the given
+// reply code.
+func (b *Batch) Add(expected int, format string, args ...interface{}) {
+	b.cmds = append(b.cmds, batchCommand{expected: expected, format: format, args: args})
+}
+
+// Delete appends a DELE command deleting the file at path.
+func (b *Batch) Delete(path string) {
+	b.cmds = append(b.cmds, batchCommand{
+		expected: StatusRequestedFileActionOK,
+		format:   "DELE %s",
+		args:     []interface{}{path},
+		write:    true,
+	})
+}
+
+// GetTime appends a MDTM command, whose reply is parsed by BatchReply.Time.
+func (b *Batch) GetTime(path string) {
+	b.Add(StatusFile, "MDTM %s", path)
+}
+
+// Len returns the number of commands of the batch.
+func (b *Batch) Len() int {
+	return len(b.cmds)
+}
+
+// ExecBatch sends the commands of b at once, then reads their replies, in
+// order.
+// The failure of a command is reported by the Err of its reply. The returned
+// error is only set if the control connection failed, in which case it is also
+// the Err of the commands without a reply.
+// The commands are not retried on a reconnection, since some may have been
+// executed. The deadline and cancellation of ctx bound the exchange.
+func (c *ServerConn) ExecBatch(ctx context.Context, b *Batch) ([]BatchReply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer c.withContext(ctx)()
+
+	if c.checkExpired(); c.closing != nil {
+		return nil, c.closing
+	}
+
+	lines := make([]string, len(b.cmds))
+	for i, cmd := range b.cmds {
+		if cmd.write && c.readOnly {
+			return nil, ErrReadOnly
+		}
+
+		var err error
+		if lines[i], err = c.checkCommand(cmd.format, cmd.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	spans := make([]Span, len(lines))
+	for i, line := range lines {
+		spans[i] = c.traceCommand(line)
+	}
+	start := time.Now()
+	replies, err := c.exchangeBatch(b.cmds, lines)
+
+	for i, line := range lines {
+		r := &replies[i]
+		r.Err = c.finishCommand(line, spans[i], start, r.Code, r.Message, r.Err)
+	}
+	return replies, err
+}
+
+// exchangeBatch sends the command lines of a batch, then reads their replies.
+func (c *ServerConn) exchangeBatch(cmds []batchCommand, lines []string) ([]BatchReply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replies := make([]BatchReply, len(lines))
+	fail := func(i int, err error) ([]BatchReply, error) {
+		for ; i < len(replies); i++ {
+			replies[i].Err = err
+		}
+		return replies, err
+	}
+
+	if c.keepaliveErr != nil {
+		return fail(0, c.keepaliveErr)
+	}
+
+	stop := c.watchCommand()
+	if err := c.skipUnsolicited(); err != nil {
+		return fail(0, stop(err))
+	}
+	for _, line := range lines {
+		encoded, err := c.encode(line)
+		if err == nil {
+			_, err = c.conn.W.WriteString(encoded + "\r\n")
+		}
+		if err != nil {
+			return fail(0, stop(err))
+		}
+	}
+	if err := c.conn.W.Flush(); err != nil {
+		return fail(0, stop(err))
+	}
+
+	for i, cmd := range cmds {
+		code, message, err := c.readReply(cmd.expected)
+		c.lastActivity = time.Now()
+		c.lastUsed = c.lastActivity
+		if err != nil && !isReplyError(err) {
+			// The replies to the next commands can not be read
+			return fail(i, stop(err))
+		}
+		if err == nil && code == StatusNotAvailable {
+			err = newReplyError(code, message)
+		}
+
+		replies[i] = BatchReply{Code: code, Message: c.decode(message), Err: err}
+		c.setLastReply(code, replies[i].Message)
+		if code == StatusNotAvailable {
+			// The server closes the connection
+			return fail(i+1, stop(replies[i].Err))
+		}
+	}
+	return replies, stop(nil)
+}
@@ -0,0 +1,63 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExecBatch(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	var b Batch
+	b.Delete("a")
+	b.Delete("b")
+	b.GetTime("missing-file")
+	b.GetTime("file")
+
+	replies, err := c.ExecBatch(context.Background(), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != b.Len() {
+		t.Fatalf("got %d replies, expected %d", len(replies), b.Len())
+	}
+	for _, r := range replies[:2] {
+		if r.Err != nil || r.Code != StatusRequestedFileActionOK {
+			t.Errorf("unexpected reply %+v", r)
+		}
+	}
+	if !errors.Is(replies[2].Err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", replies[2].Err)
+	}
+	mtime, err := replies[3].Time()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2020, 11, 12, 13, 14, 15, 0, time.UTC); !mtime.Equal(expected) {
+		t.Errorf("time %v, expected %v", mtime, expected)
+	}
+
+	// The connection is still in sync
+	if _, err := c.CurrentDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"DELE", "DELE", "MDTM", "MDTM", "PWD"})
+}
+
+func TestExecBatchInvalid(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	var b Batch
+	b.Delete("a")
+	b.Delete("b\r\nDELE c")
+
+	// Nothing is sent if a command is invalid
+	if _, err := c.ExecBatch(context.Background(), &b); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expected ErrInvalidArgument, got %v", err)
+	}
+
+	closeConn(t, mock, c, nil)
+}
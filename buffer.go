@@ -0,0 +1,33 @@
+package ftp
+
+import "sync"
+
+// defaultCopyBufferSize is the size of the buffers of io.Copy.
+const defaultCopyBufferSize = 32 * 1024
+
+// bufferPools holds the buffers copying the data of the transfers, by size,
+// so that applications performing many small transfers do not allocate a
+// buffer for each of them.
+var bufferPools sync.Map // int -> *sync.Pool
+
+// getBuffer returns a buffer of the given size from the pool.
+// It must be handed back with putBuffer.
+func getBuffer(size int) *[]byte {
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, size)
+				return &buf
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+// putBuffer hands a buffer obtained with getBuffer back to the pool.
+func putBuffer(buf *[]byte) {
+	if pool, ok := bufferPools.Load(len(*buf)); ok {
+		pool.(*sync.Pool).Put(buf)
+	}
+}
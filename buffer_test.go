@@ -0,0 +1,17 @@
+package ftp
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	buf := getBuffer(defaultCopyBufferSize)
+	if len(*buf) != defaultCopyBufferSize {
+		t.Fatalf("buffer of %d bytes, expected %d", len(*buf), defaultCopyBufferSize)
+	}
+	putBuffer(buf)
+
+	small := getBuffer(7)
+	if len(*small) != 7 {
+		t.Fatalf("buffer of %d bytes, expected 7", len(*small))
+	}
+	putBuffer(small)
+}
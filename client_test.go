@@ -1,9 +1,12 @@
 package ftp
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"errors"
 	"io/ioutil"
-	"net/textproto"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -14,269 +17,728 @@ const (
 	testDir  = "mydir"
 )
 
-func TestConnPASV(t *testing.T) {
-	testConn(t, true)
-}
+func TestTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
 
-func TestConnEPSV(t *testing.T) {
-	testConn(t, false)
+	c, err := DialTimeout("localhost:2121", 1*time.Second)
+	if err == nil {
+		t.Fatal("expected timeout, got nil error")
+		c.Quit()
+	}
 }
 
-func testConn(t *testing.T, disableEPSV bool) {
+func TestForcedDataHost(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithDisabledEPSV(true), DialWithForcedDataHost("127.0.0.1"))
 
-	mock, c := openConn(t, "127.0.0.1", DialWithTimeout(5*time.Second), DialWithDisabledEPSV(disableEPSV))
+	// The mock listens on 127.0.0.1, the advertised address is unreachable
+	mock.pasvHost = "10,255,255,1"
 
-	err := c.Login("anonymous", "anonymous")
+	r, err := c.Retr("file")
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	err = c.NoOp()
+	buf, err := ioutil.ReadAll(r)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	r.Close()
+	if string(buf) != testData {
+		t.Errorf("read %q, expected %q", buf, testData)
 	}
 
-	err = c.ChangeDir("incoming")
+	closeConn(t, mock, c, []string{"PASV", "RETR"})
+}
+
+func TestPASVAddressCheck(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithDisabledEPSV(true), DialWithPASVAddressCheck(true))
+	mock.pasvHost = "0,0,0,0"
+
+	r, err := c.Retr("file")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	r.Close()
 
-	dir, err := c.CurrentDir()
+	closeConn(t, mock, c, []string{"PASV", "RETR"})
+}
+
+func TestPASVUnspecifiedAddress(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithDisabledEPSV(true))
+	mock.pasvHost = "0,0,0,0"
+
+	r, err := c.Retr("file")
 	if err != nil {
-		t.Error(err)
-	} else {
-		if dir != "/incoming" {
-			t.Error("Wrong dir: " + dir)
-		}
+		t.Fatal(err)
 	}
+	r.Close()
 
-	data := bytes.NewBufferString(testData)
-	_, err = c.Stor("test", data)
+	closeConn(t, mock, c, []string{"PASV", "RETR"})
+}
+
+func TestDataHostname(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	defer mock.Close()
 
-	_, err = c.List(".")
+	var dialed []string
+	_, port, _ := net.SplitHostPort(mock.Addr())
+	c, err := Dial(net.JoinHostPort("localhost", port), DialWithDataHostname(true),
+		DialWithDialFunc(func(network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			return net.Dial(network, address)
+		}))
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
 	}
 
-	_, err = c.Rename("test", "tset")
+	r, err := c.Retr("file")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	r.Close()
 
-	// Read without deadline
-	r, err := c.Retr("tset")
-	if err != nil {
-		t.Error(err)
-	} else {
-		buf, err := ioutil.ReadAll(r)
+	if len(dialed) != 2 || !strings.HasPrefix(dialed[1], "localhost:") {
+		t.Errorf("dialed %q", dialed)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR"})
+}
+
+func TestParsePASVReply(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		host string
+		port int
+	}{
+		{"Entering Passive Mode (192,168,1,2,4,1).", "192.168.1.2", 1025},
+		{"Entering Passive Mode 192,168,1,2,4,1", "192.168.1.2", 1025},
+		{"Entering Passive Mode (192,168,1,2,4,1) - enjoy", "192.168.1.2", 1025},
+		{"=192,168,1,2,4,1", "192.168.1.2", 1025},
+		{"Entering Passive Mode 0,0,0,0,200,10", "0.0.0.0", 51210},
+	} {
+		host, port, err := parsePASVReply(tc.line)
 		if err != nil {
-			t.Error(err)
-		}
-		if string(buf) != testData {
-			t.Errorf("'%s'", buf)
+			t.Errorf("%q: %v", tc.line, err)
+		} else if host != tc.host || port != tc.port {
+			t.Errorf("%q: parsed %s:%d, expected %s:%d", tc.line, host, port, tc.host, tc.port)
 		}
-		r.Close()
-		r.Close() // test we can close two times
 	}
 
-	// Read with deadline
-	r, err = c.Retr("tset")
-	if err != nil {
-		t.Error(err)
-	} else {
-		r.SetDeadline(time.Now())
-		_, err := ioutil.ReadAll(r)
-		if err == nil {
-			t.Error("deadline should have caused error")
-		} else if !strings.HasSuffix(err.Error(), "i/o timeout") {
-			t.Error(err)
+	for _, line := range []string{"Entering Passive Mode", "Entering Passive Mode (192,168,1,2,4)", "(192,168,1,256,4,1)"} {
+		if _, _, err := parsePASVReply(line); err == nil {
+			t.Errorf("%q: no error", line)
 		}
-		r.Close()
 	}
+}
 
-	// Read with offset
-	r, err = c.RetrFrom("tset", 5)
-	if err != nil {
-		t.Error(err)
-	} else {
-		buf, err := ioutil.ReadAll(r)
-		if err != nil {
-			t.Error(err)
-		}
-		expected := testData[5:]
-		if string(buf) != expected {
-			t.Errorf("read %q, expected %q", buf, expected)
+func TestIsUsableDataHost(t *testing.T) {
+	for _, test := range []struct {
+		dataHost    string
+		controlHost string
+		usable      bool
+	}{
+		{"203.0.113.5", "203.0.113.5", true},
+		{"203.0.113.6", "203.0.113.5", true},
+		{"0.0.0.0", "203.0.113.5", false},
+		{"192.168.1.10", "203.0.113.5", false},
+		{"10.0.0.1", "203.0.113.5", false},
+		{"127.0.0.1", "203.0.113.5", false},
+		{"10.0.0.1", "192.168.1.1", true},
+		{"203.0.113.5", "10.0.0.1", true},
+		{"fd00::1", "2001:db8::1", false},
+	} {
+		if usable := isUsableDataHost(test.dataHost, test.controlHost); usable != test.usable {
+			t.Errorf("isUsableDataHost(%q, %q) = %v, expected %v", test.dataHost, test.controlHost, usable, test.usable)
 		}
-		r.Close()
 	}
+}
+
+func TestDataBindIP(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithDataBindIP(net.ParseIP("127.0.0.1")))
 
-	fileSize, err := c.FileSize("magic-file")
+	r, err := c.Retr("file")
 	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR"})
+
+	// Binding to an address of another host must fail
+	mock, c = openConn(t, "127.0.0.1", DialWithDataBindIP(net.ParseIP("192.0.2.1")))
+	if _, err := c.Retr("file"); err == nil {
+		t.Error("expected error, got nil")
+	}
+	c.Quit()
+	mock.Wait()
+}
+
+func TestInvalidArgument(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	for _, path := range []string{"file\r\nDELE other", "file\nDELE other", "file\xff\xf4"} {
+		if _, err := c.Delete(path); err != ErrInvalidArgument {
+			t.Errorf("Delete(%q): expected ErrInvalidArgument, got %v", path, err)
+		}
+	}
+	if _, err := c.Delete("file"); err != nil {
 		t.Error(err)
 	}
-	if fileSize != 42 {
-		t.Errorf("file size %q, expected %q", fileSize, 42)
+
+	closeConn(t, mock, c, []string{"DELE"})
+}
+
+func TestLoginPrompt(t *testing.T) {
+	var prompts []string
+	prompt := func(code int, message string) (string, error) {
+		prompts = append(prompts, message)
+		switch code {
+		case StatusUserOK:
+			return "PASS " + strings.Fields(message)[2], nil
+		case StatusLoginNeedAccount:
+			return "ACCT acme", nil
+		}
+		return "", nil
 	}
 
-	_, err = c.FileSize("not-found")
-	if err == nil {
-		t.Fatal("expected error, got nil")
+	mock, c := openConnReplies(t, map[string][]string{
+		"USER": {"331 Challenge otp-md5 499ke1234 required"},
+		"PASS": {"332 Need account for login"},
+		"ACCT": {"230 User logged in"},
+	}, DialWithLoginPrompt(prompt))
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
 	}
+	mock.Wait()
 
-	_, err = c.Delete("tset")
-	if err != nil {
-		t.Error(err)
+	expected := []string{"FEAT", "USER anonymous", "PASS 499ke1234", "ACCT acme", "TYPE I", "QUIT"}
+	if !reflect.DeepEqual(mock.lines, expected) {
+		t.Errorf("expected %v, got %v", expected, mock.lines)
+	}
+	if len(prompts) != 2 {
+		t.Errorf("expected 2 prompts, got %v", prompts)
 	}
+}
 
-	_, err = c.MakeDir(testDir)
+func TestLoginPromptUnanswered(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	defer mock.Close()
+	mock.cannedReplies = map[string][]string{"PASS": {"332 Need account for login"}}
 
-	err = c.ChangeDir(testDir)
+	c, err := Dial(mock.Addr())
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	err = c.ChangeDirToParent()
-	if err != nil {
-		t.Error(err)
+	err = c.Login("anonymous", "anonymous")
+	if tpErr, ok := err.(*ReplyError); !ok || tpErr.Code != StatusLoginNeedAccount {
+		t.Errorf("expected a 332 error, got %v", err)
 	}
 
-	entries, err := c.NameList("/")
-	if err != nil {
-		t.Error(err)
+	c.Quit()
+	mock.Wait()
+}
+
+func TestFeatures(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	expected := map[string]string{"FEAT": "", "PASV": "", "EPSV": "", "SIZE": ""}
+	if features := c.Features(); !reflect.DeepEqual(features, expected) {
+		t.Errorf("features %v, expected %v", features, expected)
+	}
+	if !c.HasFeature("size") {
+		t.Error("expected SIZE to be supported")
 	}
-	if len(entries) != 1 || entries[0] != "/incoming" {
-		t.Errorf("Unexpected entries: %v", entries)
+	if c.HasFeature("MLST") {
+		t.Error("expected MLST not to be supported")
 	}
 
-	_, err = c.RemoveDir(testDir)
-	if err != nil {
-		t.Error(err)
+	// The returned map is a copy
+	c.Features()["MLST"] = ""
+	if c.HasFeature("MLST") {
+		t.Error("expected the features not to be modified")
 	}
 
-	err = c.Logout()
+	closeConn(t, mock, c, nil)
+}
+
+func TestFeatureOverride(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1",
+		DialWithFeatureOverride("epsv", false),
+		DialWithFeatureOverride("MLST", true),
+	)
+
+	if c.HasFeature("EPSV") {
+		t.Error("expected EPSV to be disabled")
+	}
+	if !c.HasFeature("MLST") || !c.mlstSupported {
+		t.Error("expected MLST to be enabled")
+	}
+	if !c.HasFeature("SIZE") {
+		t.Error("expected SIZE to be kept")
+	}
+
+	closeConn(t, mock, c, nil)
+}
+
+func TestDisabledMLSD(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1",
+		DialWithFeatureOverride("MLST", true),
+		DialWithDisabledMLSD(true),
+	)
+
+	entries, err := c.List("")
 	if err != nil {
-		if protoErr := err.(*textproto.Error); protoErr != nil {
-			if protoErr.Code != StatusNotImplemented {
-				t.Error(err)
-			}
-		} else {
-			t.Error(err)
-		}
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "lo" {
+		t.Errorf("unexpected entries %v", entries)
 	}
 
-	if err := c.Quit(); err != nil {
+	closeConn(t, mock, c, []string{"EPSV", "LIST"})
+}
+
+func TestDisabledUTF8(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1",
+		DialWithFeatureOverride("UTF8", true),
+		DialWithDisabledUTF8(true),
+	)
+
+	closeConn(t, mock, c, nil)
+}
+
+func TestDeleteDirRecurFailures(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"DELE": {"550 Permission denied"},
+	})
+
+	_, err := c.RemoveDirRecur("/testDir")
+	var removeErr *RemoveError
+	if !errors.As(err, &removeErr) {
+		t.Fatalf("expected a *RemoveError, got %v", err)
+	}
+	if len(removeErr.Failures) != 1 || removeErr.Failures[0].Path != "/testDir/lo" {
+		t.Errorf("unexpected failures %+v", removeErr.Failures)
+	}
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Error("expected the error to match ErrPermissionDenied")
+	}
+
+	// The directory is not removed, and the current directory is unchanged
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "DELE"})
+}
+
+func TestDeleteDirRecurLinks(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	mock.listData = "lrwxrwxrwx   1 ftp      wheel           4 Jan 29 10:29 link -> /tmp\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 file\r\n"
+
+	if _, err := c.RemoveDirRecur("/testDir"); err != nil {
 		t.Fatal(err)
 	}
 
-	// Wait for the connection to close
-	mock.Wait()
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "DELE", "DELE", "RMD"})
+}
 
-	err = c.NoOp()
-	if err == nil {
-		t.Error("Expected error")
+func TestDeleteDirRecurMaxDepth(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	// Every directory contains itself
+	mock.listData = "drwxr-xr-x   1 ftp      wheel           0 Jan 29 10:29 sub\r\n"
+
+	_, err := c.RemoveDirRecurWithOptions("/testDir", &RemoveOptions{MaxDepth: 2})
+	var removeErr *RemoveError
+	if !errors.As(err, &removeErr) || !errors.Is(err, ErrRemoveLimit) {
+		t.Fatalf("expected a *RemoveError matching ErrRemoveLimit, got %v", err)
 	}
+	if len(removeErr.Failures) != 1 || removeErr.Failures[0].Path != "/testDir/sub/sub/sub" {
+		t.Errorf("unexpected failures %+v", removeErr.Failures)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "EPSV", "LIST", "EPSV", "LIST"})
 }
 
-// TestConnect tests the legacy Connect function
-func TestConnect(t *testing.T) {
-	mock, err := newFtpMock(t, "127.0.0.1")
+func TestDeleteDirRecurMaxEntries(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 a\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 b\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 c\r\n"
+
+	_, err := c.RemoveDirRecurWithOptions("/testDir", &RemoveOptions{MaxEntries: 2})
+	if !errors.Is(err, ErrRemoveLimit) {
+		t.Fatalf("expected ErrRemoveLimit, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "DELE", "DELE"})
+}
+
+func TestListStrict(t *testing.T) {
+	listing := "total 2\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 a\r\n" +
+		"?rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 odd\r\n"
+
+	mock, c := openConn(t, "127.0.0.1")
+	mock.listData = listing
+	entries, err := c.List("/")
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected the odd line to be skipped, got %d entries, %v", len(entries), err)
+	}
+	closeConn(t, mock, c, []string{"EPSV", "LIST"})
+
+	mock, c = openConn(t, "127.0.0.1", DialWithStrictListing(true))
+	mock.listData = listing
+	_, err = c.List("/")
+	var parseErr *ListParseError
+	if !errors.As(err, &parseErr) || !errors.Is(err, ErrListParse) {
+		t.Fatalf("expected a *ListParseError, got %v", err)
+	}
+	if parseErr.Line != "?rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 odd" {
+		t.Errorf("unexpected line %q", parseErr.Line)
+	}
+	// The connection is still usable
+	if err := c.NoOp(); err != nil {
+		t.Error(err)
+	}
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "NOOP"})
+}
+
+func TestListParseErrorHandler(t *testing.T) {
+	var unparsed []string
+	handler := func(line string, err error) *Entry {
+		unparsed = append(unparsed, line)
+		if strings.HasPrefix(line, "custom ") {
+			return &Entry{Name: strings.TrimPrefix(line, "custom "), Type: EntryTypeFile}
+		}
+		return nil
+	}
+
+	mock, c := openConn(t, "127.0.0.1", DialWithListParseErrorHandler(handler))
+	mock.listData = "total 3\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 a\r\n" +
+		"custom b\r\n" +
+		"garbage\r\n"
+
+	entries, err := c.List("/")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer mock.Close()
+	if len(entries) != 2 || entries[0].Name != "a" || entries[1].Name != "b" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+	if expected := []string{"custom b", "garbage"}; !reflect.DeepEqual(unparsed, expected) {
+		t.Errorf("handler called with %q, expected %q", unparsed, expected)
+	}
 
-	c, err := Connect(mock.Addr())
+	closeConn(t, mock, c, []string{"EPSV", "LIST"})
+}
+
+func TestListFunc(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 a\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 b\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 c\r\n"
+
+	var names []string
+	err := c.ListFunc(context.Background(), "/", func(e *Entry) error {
+		names = append(names, e.Name)
+		return nil
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("listed %v, expected %v", names, expected)
+	}
 
-	if err := c.Quit(); err != nil {
-		t.Fatal(err)
+	// The sink stops the listing
+	errStop := errors.New("stop")
+	names = nil
+	err = c.ListFunc(context.Background(), "/", func(e *Entry) error {
+		names = append(names, e.Name)
+		if e.Name == "b" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || len(names) != 2 {
+		t.Errorf("expected the listing to stop after b, got %v, %v", names, err)
 	}
-	mock.Wait()
+	if err := c.NoOp(); err != nil {
+		t.Error(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "EPSV", "LIST", "ABOR", "NOOP", "NOOP"})
 }
 
-func TestTimeout(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping test in short mode.")
+func TestMaxListEntries(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithMaxListEntries(2))
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 a\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 b\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 c\r\n"
+
+	if _, err := c.List("/"); err != ErrListLimit {
+		t.Errorf("expected ErrListLimit, got %v", err)
 	}
 
-	c, err := DialTimeout("localhost:2121", 1*time.Second)
-	if err == nil {
-		t.Fatal("expected timeout, got nil error")
-		c.Quit()
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "ABOR", "NOOP"})
+}
+
+func TestMaxListLineLength(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithMaxListLineLength(64))
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 a\r\n" +
+		"-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 " + strings.Repeat("b", 64) + "\r\n"
+
+	_, err := c.List("/")
+	var tooLong *ListLineTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Max != 64 || !errors.Is(err, bufio.ErrTooLong) {
+		t.Errorf("expected a *ListLineTooLongError, got %v", err)
 	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "ABOR", "NOOP"})
 }
 
-func TestWrongLogin(t *testing.T) {
-	mock, err := newFtpMock(t, "127.0.0.1")
+func TestListMLSDDotEntries(t *testing.T) {
+	mlsd := "type=cdir;modify=20201112121415; .\r\n" +
+		"Type=pdir;modify=20201112121415; ..\r\n" +
+		"type=file;size=14;modify=20201112121415; lo\r\n"
+
+	for _, keep := range []bool{false, true} {
+		mock, c := openConn(t, "127.0.0.1", DialWithFeatureOverride("MLST", true), DialWithMLSDDotEntries(keep))
+		mock.mlsdData = mlsd
+
+		entries, err := c.List("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		expected := []string{"lo"}
+		if keep {
+			expected = []string{".", "..", "lo"}
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("keep %v: listed %v, expected %v", keep, names, expected)
+		}
+
+		closeConn(t, mock, c, []string{"EPSV", "MLSD"})
+	}
+}
+
+func TestListWithOptions(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithFeatureOverride("MLST", true))
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 .hidden\r\n"
+
+	entries, err := c.ListWithOptions(context.Background(), "/", "-la")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer mock.Close()
+	if len(entries) != 1 || entries[0].Name != ".hidden" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+	if line := mock.lines[len(mock.lines)-1]; line != "LIST -la /" {
+		t.Errorf("sent %q", line)
+	}
 
-	c, err := DialTimeout(mock.Addr(), 5*time.Second)
+	if _, err := c.ListWithOptions(context.Background(), "/", "la"); err == nil {
+		t.Error("expected an error for options without a dash")
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST"})
+}
+
+func TestNameListWildcard(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"NLST": {"450 No files found", "550 No such file or directory", "550 No such file or directory"},
+	})
+
+	for _, pattern := range []string{"*.csv", "data-202?.txt"} {
+		if entries, err := c.NameList(pattern); err != nil || len(entries) != 0 {
+			t.Errorf("NameList(%q) = %v, %v, expected no entries", pattern, entries, err)
+		}
+	}
+	// Without wildcard, a missing path is an error
+	if _, err := c.NameList("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "NLST", "EPSV", "NLST", "EPSV", "NLST"})
+}
+
+func TestChangeDirWithResult(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"CWD": {"250 Directory changed to /home/user"},
+		"PWD": {"257 \"/home/user\" is the current directory"},
+	})
+
+	change, err := c.ChangeDirWithResult(context.Background(), "~")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer c.Quit()
-
-	err = c.Login("zoo2Shia", "fei5Yix9")
-	if err == nil {
-		t.Fatal("expected error, got nil")
+	if change.Message != "Directory changed to /home/user" || change.Path != "/home/user" {
+		t.Errorf("unexpected change %+v", change)
 	}
+
+	closeConn(t, mock, c, []string{"CWD", "PWD"})
 }
 
-func TestDeleteDirRecur(t *testing.T) {
+func TestChangeDirAndRestore(t *testing.T) {
 	mock, c := openConn(t, "127.0.0.1")
 
-	_, err := c.RemoveDirRecur("testDir")
-	if err != nil {
-		t.Error(err)
+	errFn := errors.New("failed")
+	err := c.ChangeDirAndRestore(context.Background(), "sub", func() error {
+		if err := c.NoOp(); err != nil {
+			t.Error(err)
+		}
+		return errFn
+	})
+	if err != errFn {
+		t.Errorf("expected the error of fn, got %v", err)
+	}
+	if expected := []string{"PWD", "CWD sub", "NOOP", "CWD /incoming"}; !reflect.DeepEqual(mock.lines[len(mock.lines)-4:], expected) {
+		t.Errorf("sent %v, expected %v", mock.lines[len(mock.lines)-4:], expected)
 	}
 
-	if err := c.Quit(); err != nil {
-		t.Fatal(err)
+	closeConn(t, mock, c, []string{"PWD", "CWD", "NOOP", "CWD"})
+}
+
+func TestCurrentDirXPWD(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"PWD":  {"502 Command not implemented"},
+		"XPWD": {"257 /home/user is the current directory"},
+	})
+
+	dir, err := c.CurrentDir()
+	if err != nil || dir != "/home/user" {
+		t.Errorf("CurrentDir() = %q, %v", dir, err)
 	}
 
-	// Wait for the connection to close
-	mock.Wait()
+	closeConn(t, mock, c, []string{"PWD", "XPWD"})
 }
 
-// func TestFileDeleteDirRecur(t *testing.T) {
-// 	mock, c := openConn(t, "127.0.0.1")
+func TestParsePWDReply(t *testing.T) {
+	for _, test := range []struct {
+		msg  string
+		path string
+	}{
+		{`"/incoming" is the current directory`, "/incoming"},
+		{`"/with ""quotes""" created`, `/with "quotes"`},
+		{`"/with spaces"`, "/with spaces"},
+		{`/unquoted is current`, "/unquoted"},
+		{`C:/windows`, "C:/windows"},
+	} {
+		if path, err := parsePWDReply(test.msg); err != nil || path != test.path {
+			t.Errorf("parsePWDReply(%q) = %q, %v, expected %q", test.msg, path, err, test.path)
+		}
+	}
 
-// 	err := c.RemoveDirRecur("testFile")
-// 	if err == nil {
-// 		t.Fatal("expected error got nil")
-// 	}
+	for _, msg := range []string{"", `"/unterminated`} {
+		if _, err := parsePWDReply(msg); err == nil {
+			t.Errorf("parsePWDReply(%q): expected an error", msg)
+		}
+	}
+}
 
-// 	if err := c.Quit(); err != nil {
-// 		t.Fatal(err)
-// 	}
+func TestOpts(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"OPTS": {"200 MLST OPTS type;size;", "501 Invalid hash algorithm"},
+	})
 
-// 	// Wait for the connection to close
-// 	mock.Wait()
-// }
+	msg, err := c.Opts(context.Background(), "MLST", "type;size;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "MLST OPTS type;size;" {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	if _, err := c.Opts(context.Background(), "HASH", "CRC32"); ReplyCode(err) != StatusBadArguments {
+		t.Errorf("expected a 501 reply, got %v", err)
+	}
 
-func TestMissingFolderDeleteDirRecur(t *testing.T) {
+	closeConn(t, mock, c, []string{"OPTS", "OPTS"})
+}
+
+func TestLastReply(t *testing.T) {
 	mock, c := openConn(t, "127.0.0.1")
 
-	_, err := c.RemoveDirRecur("missing-dir")
-	if err == nil {
-		t.Fatal("expected error got nil")
+	if _, err := c.CurrentDir(); err != nil {
+		t.Fatal(err)
+	}
+	if code, msg := c.LastReply(); code != StatusPathCreated || msg != `"/incoming"` {
+		t.Errorf("unexpected last reply %d %q", code, msg)
 	}
 
-	if err := c.Quit(); err != nil {
+	// Failed commands are recorded
+	if _, err := c.FileSize("missing"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if code, msg := c.LastReply(); code != StatusFileUnavailable || msg != "Could not get file size." {
+		t.Errorf("unexpected last reply %d %q", code, msg)
+	}
+
+	closeConn(t, mock, c, []string{"PWD", "SIZE"})
+}
+
+func TestDialMulti(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := l.Addr().String()
+	l.Close()
+
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	c, err := DialMulti(context.Background(), []string{unreachable, mock.Addr()}, DialWithLogin("anonymous", "anonymous"))
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	// Wait for the connection to close
+	closeConn(t, mock, c, nil)
+}
+
+func TestDialMultiLoginRejected(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// The other server is not tried with the same credentials
+	_, err = DialMulti(context.Background(), []string{mock.Addr(), other.Addr().String()}, DialWithLogin("root", "secret"))
+	if !errors.Is(err, ErrNotLoggedIn) {
+		t.Fatalf("expected ErrNotLoggedIn, got %v", err)
+	}
+
 	mock.Wait()
+	if expected := []string{"FEAT", "USER", "QUIT"}; !reflect.DeepEqual(mock.commands, expected) {
+		t.Errorf("unexpected sequence of commands: %v, expected: %v", mock.commands, expected)
+	}
+	other.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Millisecond))
+	if conn, err := other.Accept(); err == nil {
+		conn.Close()
+		t.Error("unexpected connection to the other server")
+	}
 }
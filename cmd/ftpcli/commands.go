@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/snus8bit/ftp"
+)
+
+// maxMirrorDepth bounds the recursion of mirror, in case of cyclic listings.
+const maxMirrorDepth = 64
+
+// list writes the entries of dir, one per line.
+func list(c *ftp.ServerConn, dir string, w io.Writer) error {
+	entries, err := c.List(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		kind := "-"
+		switch e.Type {
+		case ftp.EntryTypeFolder:
+			kind = "d"
+		case ftp.EntryTypeLink:
+			kind = "l"
+		}
+
+		name := e.Name
+		if e.Target != "" {
+			name += " -> " + e.Target
+		}
+		fmt.Fprintf(w, "%s %12d %s %s\n", kind, e.Size, e.Time.Format("2006-01-02 15:04"), name)
+	}
+	return nil
+}
+
+// get downloads the file remote into local, which defaults to its base name,
+// or writes it to w if local is "-".
+func get(ctx context.Context, c *ftp.ServerConn, remote, local string, w io.Writer) error {
+	if local == "" {
+		local = path.Base(remote)
+	}
+	if local != "-" {
+		_, err := c.DownloadFile(ctx, remote, local, nil)
+		return err
+	}
+
+	r, err := c.Retr(remote)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		r.Close()
+		return err
+	}
+	return r.Close()
+}
+
+// put uploads the file local to remote, or into remote if it ends with a
+// slash.
+func put(ctx context.Context, c *ftp.ServerConn, local, remote string) error {
+	if strings.HasSuffix(remote, "/") {
+		remote += filepath.Base(local)
+	}
+	_, err := c.UploadFile(ctx, local, remote)
+	return err
+}
+
+// remove deletes the files.
+func remove(c *ftp.ServerConn, paths []string) error {
+	for _, p := range paths {
+		if _, err := c.Delete(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirror downloads the tree of remoteDir into localDir, skipping the files
+// having the same size and modification time, and writes the paths of the
+// downloaded files to w.
+func mirror(ctx context.Context, c *ftp.ServerConn, remoteDir, localDir string, w io.Writer) error {
+	return mirrorDir(ctx, c, remoteDir, localDir, w, 0)
+}
+
+func mirrorDir(ctx context.Context, c *ftp.ServerConn, remoteDir, localDir string, w io.Writer, depth int) error {
+	if depth > maxMirrorDepth {
+		return fmt.Errorf("%s: too many levels of directories", remoteDir)
+	}
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+
+	entries, err := c.List(remoteDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.Name == "." || e.Name == ".." {
+			continue
+		}
+		// The names come from the server: they must not escape localDir
+		if strings.ContainsRune(e.Name, '/') || strings.ContainsRune(e.Name, filepath.Separator) || !filepath.IsLocal(e.Name) {
+			return fmt.Errorf("%s: unsafe file name %q", remoteDir, e.Name)
+		}
+		remote := path.Join(remoteDir, e.Name)
+		local := filepath.Join(localDir, filepath.FromSlash(e.Name))
+
+		switch e.Type {
+		case ftp.EntryTypeFolder:
+			err = mirrorDir(ctx, c, remote, local, w, depth+1)
+		case ftp.EntryTypeFile:
+			err = mirrorFile(ctx, c, e, remote, local, w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mirrorFile(ctx context.Context, c *ftp.ServerConn, e *ftp.Entry, remote, local string, w io.Writer) error {
+	if fi, err := os.Stat(local); err == nil && fi.Size() == int64(e.Size) && fi.ModTime().Equal(e.Time) {
+		return nil
+	}
+
+	if _, err := c.DownloadFile(ctx, remote, local, &ftp.DownloadOptions{NoResume: true}); err != nil {
+		return err
+	}
+	if !e.Time.IsZero() {
+		if err := os.Chtimes(local, e.Time, e.Time); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(w, remote)
+	return nil
+}
@@ -0,0 +1,244 @@
+// Ftpcli is a command-line FTP client built on the ftp package, handy to
+// reproduce the quirks of a server.
+//
+// Usage:
+//
+//	ftpcli [flags] ls URL
+//	ftpcli [flags] get URL [local-path]
+//	ftpcli [flags] put local-path URL
+//	ftpcli [flags] rm URL [remote-path...]
+//	ftpcli [flags] mirror URL local-dir
+//
+// The URLs have the form ftp://[user[:password]@]host[:port]/path, or
+// ftps:// for implicit TLS. The user defaults to anonymous. The password of a
+// user given without one is read from the FTP_PASSWORD environment variable.
+//
+// The flags are:
+//
+//	-tls            use implicit TLS, as the ftps scheme
+//	-ca file        verify the certificate of the server with the PEM
+//	                certificates of file instead of the system roots
+//	-insecure       skip the verification of the certificate of the server
+//	-timeout d      timeout of the connections (default 30s)
+//	-debug          write the transcript of the control connection to stderr
+//	-disable-epsv   use PASV instead of EPSV
+//	-disable-mlsd   use LIST instead of MLSD
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/snus8bit/ftp"
+)
+
+const usage = `usage: ftpcli [flags] command URL [args]
+
+commands:
+  ls URL                       list a directory
+  get URL [local-path]         download a file, to stdout if local-path is -
+  put local-path URL           upload a file
+  rm URL [remote-path...]      delete files
+  mirror URL local-dir         download a directory tree
+
+flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ftpcli:", err)
+		os.Exit(1)
+	}
+}
+
+// options holds the flags of the command line.
+type options struct {
+	tls         bool
+	ca          string
+	insecure    bool
+	timeout     time.Duration
+	debug       bool
+	disableEPSV bool
+	disableMLSD bool
+}
+
+// run runs the command line args.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var opts options
+	flags := flag.NewFlagSet("ftpcli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&opts.tls, "tls", false, "use implicit TLS, as the ftps scheme")
+	flags.StringVar(&opts.ca, "ca", "", "verify the server with the PEM certificates of `file`")
+	flags.BoolVar(&opts.insecure, "insecure", false, "skip the verification of the server certificate")
+	flags.DurationVar(&opts.timeout, "timeout", 30*time.Second, "timeout of the connections")
+	flags.BoolVar(&opts.debug, "debug", false, "write the transcript of the control connection to stderr")
+	flags.BoolVar(&opts.disableEPSV, "disable-epsv", false, "use PASV instead of EPSV")
+	flags.BoolVar(&opts.disableMLSD, "disable-mlsd", false, "use LIST instead of MLSD")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	args = flags.Args()
+	if len(args) < 2 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	command, rawURL, args := args[0], args[1], args[2:]
+
+	var argsOK bool
+	switch command {
+	case "ls":
+		argsOK = len(args) == 0
+	case "get":
+		argsOK = len(args) <= 1
+	case "put":
+		// The URL is the last argument
+		argsOK = len(args) == 1
+		if argsOK {
+			rawURL, args = args[0], []string{rawURL}
+		}
+	case "rm":
+		argsOK = true
+	case "mirror":
+		argsOK = len(args) == 1
+	default:
+		return fmt.Errorf("unknown command %q", command)
+	}
+	if !argsOK {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	target, err := parseURL(rawURL)
+	if err != nil {
+		return err
+	}
+	if opts.tls {
+		target.tls = true
+	}
+
+	c, err := dial(ctx, target, &opts, stderr)
+	if err != nil {
+		return err
+	}
+	defer c.Quit()
+
+	switch command {
+	case "ls":
+		return list(c, target.path, stdout)
+	case "get":
+		local := ""
+		if len(args) == 1 {
+			local = args[0]
+		}
+		return get(ctx, c, target.path, local, stdout)
+	case "put":
+		return put(ctx, c, args[0], target.path)
+	case "rm":
+		return remove(c, append([]string{target.path}, args...))
+	default:
+		return mirror(ctx, c, target.path, args[0], stdout)
+	}
+}
+
+// target is the server and path of a URL.
+type target struct {
+	addr     string
+	user     string
+	password string
+	path     string
+	tls      bool
+}
+
+// parseURL parses an ftp:// or ftps:// URL.
+func parseURL(rawURL string) (*target, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &target{user: "anonymous", password: "anonymous", path: u.Path}
+	switch u.Scheme {
+	case "ftp":
+	case "ftps":
+		t.tls = true
+	default:
+		return nil, fmt.Errorf("unsupported URL %q, expected ftp:// or ftps://", rawURL)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in URL %q", rawURL)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "21"
+		if t.tls {
+			port = "990"
+		}
+	}
+	t.addr = net.JoinHostPort(u.Hostname(), port)
+
+	if u.User != nil {
+		t.user = u.User.Username()
+		t.password, _ = u.User.Password()
+		if _, set := u.User.Password(); !set {
+			t.password = os.Getenv("FTP_PASSWORD")
+		}
+	}
+	if t.path == "" {
+		t.path = "/"
+	}
+	return t, nil
+}
+
+// dial connects and logs in to the server of t.
+func dial(ctx context.Context, t *target, opts *options, stderr io.Writer) (*ftp.ServerConn, error) {
+	dialOptions := []ftp.DialOption{
+		ftp.DialWithContext(ctx),
+		ftp.DialWithTimeout(opts.timeout),
+		ftp.DialWithDisabledEPSV(opts.disableEPSV),
+		ftp.DialWithDisabledMLSD(opts.disableMLSD),
+		ftp.DialWithLogin(t.user, t.password),
+	}
+	if opts.debug {
+		dialOptions = append(dialOptions, ftp.DialWithDebugTranscript(stderr))
+	}
+
+	if t.tls {
+		config := &tls.Config{InsecureSkipVerify: opts.insecure}
+		if opts.ca != "" {
+			pem, err := os.ReadFile(opts.ca)
+			if err != nil {
+				return nil, err
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", opts.ca)
+			}
+		}
+		dialOptions = append(dialOptions, ftp.DialWithTLS(config))
+	}
+
+	return ftp.Dial(t.addr, dialOptions...)
+}
@@ -0,0 +1,265 @@
+package ftp
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// Compression configures MODE Z, the deflate compression of the data
+// connections of the Retr and Stor transfers, see DialWithCompression.
+type Compression struct {
+	// Level is the zlib compression level, from 1 to 9, sent to the server
+	// with OPTS MODE Z LEVEL and used to compress the uploads. The defaults
+	// apply if 0.
+	Level int
+
+	// MinSize is the size under which the files are transferred
+	// uncompressed. It applies when the size is known: the size of an
+	// *os.File or of a reader with a Len method for Stor, and the size given
+	// to TransferWithExpectedSize for Retr.
+	MinSize int64
+
+	// SkipExtensions are the extensions, such as ".zip", of the files
+	// transferred uncompressed. DefaultSkipExtensions apply if nil.
+	SkipExtensions []string
+
+	// Policy decides whether the other files are compressed. head holds the
+	// first bytes of the file for Stor, read ahead from its reader, and is
+	// nil for Retr, whose data is only received once the mode is set.
+	// DefaultCompressionPolicy applies if nil.
+	Policy func(name string, head []byte) bool
+}
+
+// DefaultSkipExtensions are the extensions of the usual compressed formats,
+// which compressing again wastes CPU.
+var DefaultSkipExtensions = []string{
+	".7z", ".bz2", ".gz", ".jpeg", ".jpg", ".mov", ".mp3", ".mp4", ".png",
+	".rar", ".tgz", ".xz", ".zip", ".zst",
+}
+
+// sniffLen is the number of bytes read ahead for Compression.Policy.
+const sniffLen = 512
+
+// compressedSignatures are the magic numbers of the usual compressed formats.
+var compressedSignatures = []struct {
+	offset int
+	magic  string
+}{
+	{0, "\x1f\x8b"},           // gzip
+	{0, "PK\x03\x04"},         // zip, and the office documents
+	{0, "BZh"},                // bzip2
+	{0, "\xfd7zXZ\x00"},       // xz
+	{0, "\x28\xb5\x2f\xfd"},   // zstd
+	{0, "7z\xbc\xaf\x27\x1c"}, // 7z
+	{0, "Rar!\x1a\x07"},       // rar
+	{0, "\xff\xd8\xff"},       // jpeg
+	{0, "\x89PNG\r\n\x1a\n"},  // png
+	{0, "GIF8"},               // gif
+	{0, "ID3"},                // mp3
+	{4, "ftyp"},               // mp4, mov
+	{8, "WEBP"},               // webp
+}
+
+// DefaultCompressionPolicy compresses the files unless their first bytes are
+// the signature of a compressed format, such as zip, gzip, jpeg or mp4. Text
+// files, such as CSV files and logs, are thus compressed.
+func DefaultCompressionPolicy(name string, head []byte) bool {
+	for _, sig := range compressedSignatures {
+		if end := sig.offset + len(sig.magic); len(head) >= end && string(head[sig.offset:end]) == sig.magic {
+			return false
+		}
+	}
+	return true
+}
+
+// DialWithCompression returns a DialOption that compresses the data of the
+// Retr and Stor transfers with MODE Z, when the server lists it in its reply
+// to FEAT. The listings are not compressed.
+func DialWithCompression(comp Compression) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.compression = &comp
+	}}
+}
+
+// compress reports whether the transfer of the file at name, of size bytes
+// or -1 if unknown, is compressed. The first bytes of an upload are read from
+// r for the policy: the returned reader replaces r. r is nil for a download.
+func (c *ServerConn) compress(name string, size int64, r io.Reader) (bool, io.Reader, error) {
+	comp := c.options.compression
+	if comp == nil || !c.modeZSupported() {
+		return false, r, nil
+	}
+	if size >= 0 && size < comp.MinSize {
+		return false, r, nil
+	}
+
+	skip := comp.SkipExtensions
+	if skip == nil {
+		skip = DefaultSkipExtensions
+	}
+	ext := path.Ext(name)
+	for _, s := range skip {
+		if strings.EqualFold(ext, s) {
+			return false, r, nil
+		}
+	}
+
+	var head []byte
+	if r != nil {
+		var err error
+		if head, r, err = readHead(r); err != nil {
+			return false, r, err
+		}
+	}
+
+	policy := comp.Policy
+	if policy == nil {
+		policy = DefaultCompressionPolicy
+	}
+	return policy(name, head), r, nil
+}
+
+// readHead returns the first bytes of r and a reader of all its data: r
+// itself, rewound, if it is an io.Seeker.
+func readHead(r io.Reader) ([]byte, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	head = head[:n]
+	if err != nil {
+		return nil, r, err
+	}
+
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(int64(-n), io.SeekCurrent); err == nil {
+			return head, r, nil
+		}
+	}
+	return head, io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// modeZSupported reports whether the server supports MODE Z.
+func (c *ServerConn) modeZSupported() bool {
+	desc, ok := c.features["MODE"]
+	return ok && (desc == "" || strings.Contains(strings.ToUpper(desc), "Z"))
+}
+
+// setModeZ switches the transfer mode to MODE Z or back to MODE S, if not
+// already set. The compression level is set once per connection; servers
+// rejecting it use their default level.
+func (c *ServerConn) setModeZ(enabled bool) error {
+	if enabled == c.modeZ {
+		return nil
+	}
+
+	if !enabled {
+		if _, _, err := c.rawCmd(StatusCommandOK, "MODE S"); err != nil {
+			return err
+		}
+		c.modeZ = false
+		return nil
+	}
+
+	if level := c.options.compression.Level; level != 0 && !c.modeZLevelSet {
+		if _, _, err := c.rawCmd(StatusCommandOK, "OPTS MODE Z LEVEL %d", level); err != nil && !isReplyError(err) {
+			return err
+		}
+		c.modeZLevelSet = true
+	}
+	if _, _, err := c.rawCmd(StatusCommandOK, "MODE Z"); err != nil {
+		return err
+	}
+	c.modeZ = true
+	return nil
+}
+
+// readerSize returns the number of bytes left in r, or -1 if unknown.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return fi.Size() - offset
+	}
+	return -1
+}
+
+// compressedConn is a data connection in MODE Z, whose data is inflated when
+// read and deflated when written.
+type compressedConn struct {
+	net.Conn
+	level  int
+	upload bool
+
+	r io.ReadCloser
+	w *zlib.Writer
+}
+
+// newCompressedConn returns conn in MODE Z, compressing at level if not 0.
+// The compressed stream of an upload is sent even if nothing is written.
+func newCompressedConn(conn net.Conn, level int, upload bool) *compressedConn {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+	return &compressedConn{Conn: conn, level: level, upload: upload}
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	if c.r == nil {
+		r, err := zlib.NewReader(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		c.r = r
+	}
+	return c.r.Read(p)
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	if err := c.startWrite(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// startWrite creates the compressor.
+func (c *compressedConn) startWrite() error {
+	if c.w != nil {
+		return nil
+	}
+	w, err := zlib.NewWriterLevel(c.Conn, c.level)
+	if err != nil {
+		return err
+	}
+	c.w = w
+	return nil
+}
+
+// Close ends the compressed stream of an upload, and closes the connection.
+func (c *compressedConn) Close() error {
+	var err error
+	if c.upload {
+		if err = c.startWrite(); err == nil {
+			err = c.w.Close()
+		}
+	}
+	if cerr := c.Conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
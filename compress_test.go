@@ -0,0 +1,175 @@
+package ftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+const featModeZ = "211-Features:\r\n EPSV\r\n MODE Z\r\n SIZE\r\n211 End"
+
+func TestCompression(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{"FEAT": {featModeZ}},
+		DialWithCompression(Compression{Level: 6}))
+
+	data := bytes.Repeat([]byte("compressible "), 100)
+	if _, err := c.Stor("file.txt", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mock.stored, data) {
+		t.Errorf("stored %q", mock.stored)
+	}
+
+	r, err := c.Retr("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testData {
+		t.Errorf("retrieved %q, expected %q", buf, testData)
+	}
+
+	// The listings are not compressed
+	if _, err := c.List("."); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"OPTS", "MODE", "EPSV", "STOR", "EPSV", "RETR", "MODE", "EPSV", "LIST"})
+
+	var modes []string
+	for _, line := range mock.lines {
+		if line[:4] == "OPTS" || line[:4] == "MODE" {
+			modes = append(modes, line)
+		}
+	}
+	expected := []string{"OPTS MODE Z LEVEL 6", "MODE Z", "MODE S"}
+	if !reflect.DeepEqual(modes, expected) {
+		t.Errorf("sent %q, expected %q", modes, expected)
+	}
+}
+
+func TestCompressionUnknownSize(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{"FEAT": {featModeZ}},
+		DialWithCompression(Compression{MinSize: 100}))
+
+	// The size of the file is unknown: it may be above the threshold
+	r, err := c.Retr("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testData {
+		t.Errorf("retrieved %q, expected %q", buf, testData)
+	}
+
+	closeConn(t, mock, c, []string{"MODE", "EPSV", "RETR"})
+}
+
+func TestCompressionThresholds(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{"FEAT": {featModeZ}},
+		DialWithCompression(Compression{MinSize: 100}))
+
+	// Too small
+	if _, err := c.Stor("file.txt", bytes.NewReader([]byte(testData))); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Retr("file.txt", TransferWithExpectedSize(int64(len(testData))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Already compressed
+	if _, err := c.Stor("file.ZIP", bytes.NewReader(make([]byte, 1000))); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "EPSV", "RETR", "EPSV", "STOR"})
+}
+
+func TestCompressionUnsupported(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithCompression(Compression{}))
+
+	if _, err := c.Stor("file.txt", bytes.NewReader(make([]byte, 1000))); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR"})
+}
+
+func TestDefaultCompressionPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		head     string
+		compress bool
+	}{
+		{"", true},
+		{"date,amount\n2024-01-01,12\n", true},
+		{"\x1f\x8b\x08\x00", false},
+		{"PK\x03\x04\x14\x00", false},
+		{"\xff\xd8\xff\xe0", false},
+		{"\x00\x00\x00\x18ftypmp42", false},
+	} {
+		if compress := DefaultCompressionPolicy("file", []byte(tc.head)); compress != tc.compress {
+			t.Errorf("%q: compress %v, expected %v", tc.head, compress, tc.compress)
+		}
+	}
+}
+
+func TestCompressionPolicy(t *testing.T) {
+	var heads []string
+	mock, c := openConnReplies(t, map[string][]string{"FEAT": {featModeZ}},
+		DialWithCompression(Compression{Policy: func(name string, head []byte) bool {
+			heads = append(heads, string(head))
+			return DefaultCompressionPolicy(name, head)
+		}}))
+
+	// Sniffed as gzip despite its name
+	gzipped := "\x1f\x8b\x08\x00 not really"
+	if _, err := c.Stor("export.dat", bytes.NewReader([]byte(gzipped))); err != nil {
+		t.Fatal(err)
+	}
+	if string(mock.stored) != gzipped {
+		t.Errorf("stored %q", mock.stored)
+	}
+
+	// The data read ahead of a reader which can not seek is sent
+	csv := "date,amount\n2024-01-01,12\n"
+	if _, err := c.Stor("export.csv", ioutil.NopCloser(bytes.NewBufferString(csv))); err != nil {
+		t.Fatal(err)
+	}
+	if string(mock.stored) != csv {
+		t.Errorf("stored %q", mock.stored)
+	}
+
+	r, err := c.Retr("export.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{gzipped, csv, ""}
+	if !reflect.DeepEqual(heads, expected) {
+		t.Errorf("sniffed %q, expected %q", heads, expected)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "MODE", "EPSV", "STOR", "EPSV", "RETR"})
+}
@@ -1,6 +1,9 @@
 package ftp
 
 import (
+	"bytes"
+	"compress/zlib"
+	"crypto/tls"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -13,21 +16,65 @@ import (
 	"testing"
 )
 
+// ftpMock is a scripted FTP server for the internal tests, which need exact
+// replies and commands, and can not import ftptest. The tests of the common
+// sessions run against ftptest, see integration_test.go.
 type ftpMock struct {
 	address  string
 	listener *net.TCPListener
 	proto    *textproto.Conn
 	commands []string // list of received commands
+	lines    []string // list of received command lines, with arguments
 	rest     int
+	stored   []byte // data received by the last STOR
 	dataConn *mockDataConn
+	// dropCommand makes the mock close the control connection, without
+	// replying, the next time this command is received
+	dropCommand string
+	// ignoreCommand makes the mock never reply to this command
+	ignoreCommand string
+	// cannedReplies are sent, in order, instead of the normal reply to a
+	// command
+	cannedReplies map[string][]string
+	// listData and mlsdData replace the default listings of LIST and MLSD
+	listData string
+	mlsdData string
+	// retrData replaces the content of the files sent by RETR
+	retrData string
+	// stallRetr makes RETR send no data until the client closes the data
+	// connection
+	stallRetr bool
+	// stallStor makes STOR read no data, until the transfer is aborted by
+	// ABOR
+	stallStor bool
+	stalled   bool // a stalled STOR waits for ABOR
+	// modeZ is set by MODE Z: the data of RETR and STOR is compressed
+	modeZ bool
+	// foreignIP, if not nil, is the local address of a connection made to the
+	// listener of an active mode transfer before the one of the mock
+	foreignIP net.IP
+	// pasvHost is the address advertised in PASV replies, 127,0,0,1 if empty
+	pasvHost string
+	// tlsConfig enables implicit TLS on the control and data connections
+	tlsConfig *tls.Config
+	// dataResumed records, for each TLS data connection, whether the TLS
+	// session was resumed
+	dataResumed []bool
 	sync.WaitGroup
 }
 
 // newFtpMock returns a mock implementation of a FTP server
-// For simplication, a mock instance only accepts a signle connection and terminates afer
+// For simplication, a mock instance serves a single connection at a time and
+// stops accepting new ones once closed
 func newFtpMock(t *testing.T, address string) (*ftpMock, error) {
+	return newFtpMockTLS(t, address, nil)
+}
+
+// newFtpMockTLS returns a mock FTP server using implicit TLS if tlsConfig is
+// not nil
+func newFtpMockTLS(t *testing.T, address string, tlsConfig *tls.Config) (*ftpMock, error) {
 	var err error
-	mock := &ftpMock{address: address}
+	mock := &ftpMock{address: address, tlsConfig: tlsConfig}
 
 	l, err := net.Listen("tcp", address+":0")
 	if err != nil {
@@ -46,20 +93,29 @@ func newFtpMock(t *testing.T, address string) (*ftpMock, error) {
 }
 
 func (mock *ftpMock) listen(t *testing.T) {
-	// Listen for an incoming connection.
-	conn, err := mock.listener.Accept()
-	if err != nil {
-		t.Errorf("can not accept: %s", err)
-		return
-	}
+	for first := true; ; first = false {
+		// Listen for an incoming connection.
+		conn, err := mock.listener.Accept()
+		if err != nil {
+			if first {
+				t.Errorf("can not accept: %s", err)
+			}
+			return
+		}
 
-	// Do not accept incoming connections anymore
-	mock.listener.Close()
+		mock.serve(conn)
+	}
+}
 
+func (mock *ftpMock) serve(conn net.Conn) {
 	mock.Add(1)
 	defer mock.Done()
 	defer conn.Close()
 
+	if mock.tlsConfig != nil {
+		conn = tls.Server(conn, mock.tlsConfig)
+	}
+
 	mock.proto = textproto.NewConn(conn)
 	mock.proto.Writer.PrintfLine("220 FTP Server ready.")
 
@@ -70,6 +126,23 @@ func (mock *ftpMock) listen(t *testing.T) {
 
 		// Append to list of received commands
 		mock.commands = append(mock.commands, cmdParts[0])
+		mock.lines = append(mock.lines, fullCommand)
+
+		if cmdParts[0] == mock.dropCommand {
+			mock.dropCommand = ""
+			mock.closeDataConn()
+			return
+		}
+
+		if cmdParts[0] == mock.ignoreCommand {
+			continue
+		}
+
+		if replies := mock.cannedReplies[cmdParts[0]]; len(replies) > 0 {
+			mock.cannedReplies[cmdParts[0]] = replies[1:]
+			mock.proto.Writer.PrintfLine("%s", replies[0])
+			continue
+		}
 
 		// At least one command must have a multiline response
 		switch cmdParts[0] {
@@ -85,8 +158,13 @@ func (mock *ftpMock) listen(t *testing.T) {
 			mock.proto.Writer.PrintfLine("230-Hey,\r\nWelcome to my FTP\r\n230 Access granted")
 		case "TYPE":
 			mock.proto.Writer.PrintfLine("200 Type set ok")
+		case "MODE":
+			mock.modeZ = cmdParts[1] == "Z"
+			mock.proto.Writer.PrintfLine("200 Mode set to %s", cmdParts[1])
+		case "OPTS":
+			mock.proto.Writer.PrintfLine("200 %s", strings.Join(cmdParts[1:], " "))
 		case "CWD":
-			if cmdParts[1] == "missing-dir" {
+			if strings.HasSuffix(cmdParts[1], "missing-dir") {
 				mock.proto.Writer.PrintfLine("550 %s: No such file or directory", cmdParts[1])
 			} else {
 				mock.proto.Writer.PrintfLine("250 Directory successfully changed.")
@@ -96,7 +174,7 @@ func (mock *ftpMock) listen(t *testing.T) {
 		case "MKD":
 			mock.proto.Writer.PrintfLine("257 Directory successfully created.")
 		case "RMD":
-			if cmdParts[1] == "missing-dir" {
+			if strings.HasSuffix(cmdParts[1], "missing-dir") {
 				mock.proto.Writer.PrintfLine("550 No such file or directory")
 			} else {
 				mock.proto.Writer.PrintfLine("250 Directory successfully removed.")
@@ -121,7 +199,12 @@ func (mock *ftpMock) listen(t *testing.T) {
 			p1 := int(p / 256)
 			p2 := p % 256
 
-			mock.proto.Writer.PrintfLine("227 Entering Passive Mode (127,0,0,1,%d,%d).", p1, p2)
+			host := mock.pasvHost
+			if host == "" {
+				host = "127,0,0,1"
+			}
+
+			mock.proto.Writer.PrintfLine("227 Entering Passive Mode (%s,%d,%d).", host, p1, p2)
 		case "EPSV":
 			p, err := mock.listenDataConn()
 			if err != nil {
@@ -129,12 +212,24 @@ func (mock *ftpMock) listen(t *testing.T) {
 				break
 			}
 			mock.proto.Writer.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", p)
-		case "STOR":
+		case "PORT", "EPRT":
+			addr, err := parseActiveAddr(cmdParts[0], cmdParts[1])
+			if err != nil {
+				mock.proto.Writer.PrintfLine("501 %s.", err)
+				break
+			}
+			mock.connectDataConn(addr)
+			mock.proto.Writer.PrintfLine("200 %s command successful", cmdParts[0])
+		case "STOR", "APPE":
 			if mock.dataConn == nil {
 				mock.proto.Writer.PrintfLine("425 Unable to build data connection: Connection refused")
 				break
 			}
 			mock.proto.Writer.PrintfLine("150 please send")
+			if mock.stallStor {
+				mock.stalled = true
+				break
+			}
 			mock.recvDataConn()
 		case "LIST":
 			if mock.dataConn == nil {
@@ -142,9 +237,25 @@ func (mock *ftpMock) listen(t *testing.T) {
 				break
 			}
 
+			listData := mock.listData
+			if listData == "" {
+				listData = "-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 lo"
+			}
+
 			mock.dataConn.Wait()
 			mock.proto.Writer.PrintfLine("150 Opening ASCII mode data connection for file list")
-			mock.dataConn.conn.Write([]byte("-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 lo"))
+			mock.dataConn.conn.Write([]byte(listData))
+			mock.proto.Writer.PrintfLine("226 Transfer complete")
+			mock.closeDataConn()
+		case "MLSD":
+			if mock.dataConn == nil {
+				mock.proto.Writer.PrintfLine("425 Unable to build data connection: Connection refused")
+				break
+			}
+
+			mock.dataConn.Wait()
+			mock.proto.Writer.PrintfLine("150 Opening ASCII mode data connection for MLSD")
+			mock.dataConn.conn.Write([]byte(mock.mlsdData))
 			mock.proto.Writer.PrintfLine("226 Transfer complete")
 			mock.closeDataConn()
 		case "NLST":
@@ -166,10 +277,38 @@ func (mock *ftpMock) listen(t *testing.T) {
 
 			mock.dataConn.Wait()
 			mock.proto.Writer.PrintfLine("150 Opening ASCII mode data connection for file list")
-			mock.dataConn.conn.Write([]byte(testData[mock.rest:]))
+			if mock.stallRetr {
+				io.Copy(ioutil.Discard, mock.dataConn.conn)
+				mock.proto.Writer.PrintfLine("426 Connection closed; transfer aborted")
+				mock.closeDataConn()
+				break
+			}
+			data := testData
+			if mock.retrData != "" {
+				data = mock.retrData
+			}
+			if mock.modeZ {
+				w := zlib.NewWriter(mock.dataConn.conn)
+				w.Write([]byte(data[mock.rest:]))
+				w.Close()
+			} else {
+				mock.dataConn.conn.Write([]byte(data[mock.rest:]))
+			}
 			mock.rest = 0
 			mock.proto.Writer.PrintfLine("226 Transfer complete")
 			mock.closeDataConn()
+		case "MDTM":
+			if cmdParts[1] == "missing-file" {
+				mock.proto.Writer.PrintfLine("550 No such file")
+			} else {
+				mock.proto.Writer.PrintfLine("213 20201112131415")
+			}
+		case "MFMT":
+			if cmdParts[2] == "missing-file" {
+				mock.proto.Writer.PrintfLine("550 No such file")
+			} else {
+				mock.proto.Writer.PrintfLine("213 Modify=%s; %s", cmdParts[1], cmdParts[2])
+			}
 		case "RNFR":
 			mock.proto.Writer.PrintfLine("350 File or directory exists, ready for destination name")
 		case "RNTO":
@@ -186,6 +325,19 @@ func (mock *ftpMock) listen(t *testing.T) {
 			}
 			mock.rest = rest
 			mock.proto.Writer.PrintfLine("350 Restarting at %s. Send STORE or RETRIEVE to initiate transfer", cmdParts[1])
+		case "PBSZ":
+			mock.proto.Writer.PrintfLine("200 PBSZ=0")
+		case "PROT":
+			mock.proto.Writer.PrintfLine("200 Protection level set to %s", cmdParts[1])
+		case "ABOR":
+			if mock.stalled {
+				mock.stalled = false
+				mock.closeDataConn()
+				mock.proto.Writer.PrintfLine("426 Connection closed; transfer aborted")
+				mock.proto.Writer.PrintfLine("226 ABOR command successful")
+				break
+			}
+			mock.proto.Writer.PrintfLine("225 No transfer to ABOR")
 		case "NOOP":
 			mock.proto.Writer.PrintfLine("200 NOOP ok.")
 		case "REIN":
@@ -201,6 +353,14 @@ func (mock *ftpMock) listen(t *testing.T) {
 
 func (mock *ftpMock) closeDataConn() (err error) {
 	if mock.dataConn != nil {
+		mock.dataConn.mu.Lock()
+		if tlsConn, ok := mock.dataConn.conn.(*tls.Conn); ok {
+			if state := tlsConn.ConnectionState(); state.HandshakeComplete {
+				mock.dataResumed = append(mock.dataResumed, state.DidResume)
+			}
+		}
+		mock.dataConn.mu.Unlock()
+
 		err = mock.dataConn.Close()
 		mock.dataConn = nil
 	}
@@ -210,6 +370,7 @@ func (mock *ftpMock) closeDataConn() (err error) {
 type mockDataConn struct {
 	listener *net.TCPListener
 	conn     net.Conn
+	mu       sync.Mutex // protects conn until the WaitGroup is done
 	// WaitGroup is done when conn is accepted and stored
 	sync.WaitGroup
 }
@@ -218,6 +379,8 @@ func (d *mockDataConn) Close() (err error) {
 	if d.listener != nil {
 		err = d.listener.Close()
 	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.conn != nil {
 		err = d.conn.Close()
 	}
@@ -260,7 +423,13 @@ func (mock *ftpMock) listenDataConn() (int64, error) {
 			return
 		}
 
+		if mock.tlsConfig != nil {
+			conn = tls.Server(conn, mock.tlsConfig)
+		}
+
+		dataConn.mu.Lock()
 		dataConn.conn = conn
+		dataConn.mu.Unlock()
 		dataConn.Done()
 	}()
 
@@ -268,9 +437,71 @@ func (mock *ftpMock) listenDataConn() (int64, error) {
 	return p, nil
 }
 
+// parseActiveAddr returns the address of the argument of PORT or EPRT.
+func parseActiveAddr(cmd, arg string) (string, error) {
+	if cmd == "PORT" {
+		fields := strings.Split(arg, ",")
+		if len(fields) != 6 {
+			return "", errors.New("invalid PORT argument")
+		}
+		p1, _ := strconv.Atoi(fields[4])
+		p2, _ := strconv.Atoi(fields[5])
+		return net.JoinHostPort(strings.Join(fields[:4], "."), strconv.Itoa(p1<<8|p2)), nil
+	}
+
+	fields := strings.Split(arg, "|")
+	if len(fields) != 5 {
+		return "", errors.New("invalid EPRT argument")
+	}
+	return net.JoinHostPort(fields[2], fields[3]), nil
+}
+
+// connectDataConn connects to the client for an active mode transfer.
+func (mock *ftpMock) connectDataConn(addr string) {
+	mock.closeDataConn()
+
+	dataConn := &mockDataConn{}
+	dataConn.Add(1)
+	foreignIP := mock.foreignIP
+	go func() {
+		defer dataConn.Done()
+		if foreignIP != nil {
+			dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: foreignIP}}
+			if foreign, err := dialer.Dial("tcp", addr); err == nil {
+				defer foreign.Close()
+				foreign.Write([]byte("foreign data"))
+			}
+		}
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+
+		if mock.tlsConfig != nil {
+			conn = tls.Server(conn, mock.tlsConfig)
+		}
+
+		dataConn.mu.Lock()
+		dataConn.conn = conn
+		dataConn.mu.Unlock()
+	}()
+
+	mock.dataConn = dataConn
+}
+
 func (mock *ftpMock) recvDataConn() {
 	mock.dataConn.Wait()
-	io.Copy(ioutil.Discard, mock.dataConn.conn)
+	mock.stored, _ = ioutil.ReadAll(mock.dataConn.conn)
+	if mock.modeZ {
+		r, err := zlib.NewReader(bytes.NewReader(mock.stored))
+		if err == nil {
+			mock.stored, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			mock.stored = nil
+		}
+	}
 	mock.proto.Writer.PrintfLine("226 Transfer Complete")
 	mock.closeDataConn()
 }
@@ -306,6 +537,30 @@ func openConn(t *testing.T, addr string, options ...DialOption) (*ftpMock, *Serv
 
 }
 
+// Helper to return a client connected to a mock server which sends the
+// canned replies before the normal ones, starting with the FEAT reply
+func openConnReplies(t *testing.T, replies map[string][]string, options ...DialOption) (*ftpMock, *ServerConn) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	mock.cannedReplies = replies
+
+	c, err := Dial(mock.Addr(), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Login("anonymous", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mock, c
+}
+
 // Helper to close a client connected to a mock server
 func closeConn(t *testing.T, mock *ftpMock, c *ServerConn, commands []string) {
 	expected := []string{"FEAT", "USER", "PASS", "TYPE"}
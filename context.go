@@ -0,0 +1,132 @@
+package ftp
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// withContext makes ctx bound the next commands on the control connection,
+// and the parent of the next spans, until restore is called.
+// A nil ctx keeps the current one.
+func (c *ServerConn) withContext(ctx context.Context) (restore func()) {
+	parent := c.ctx
+	if ctx != nil {
+		c.ctx = ctx
+	}
+	restoreTrace := c.withTraceContext(ctx)
+	return func() {
+		restoreTrace()
+		c.ctx = parent
+	}
+}
+
+// watchCommand applies the context of the current operation and the control
+// deadline, if any, to the control connection for the duration of a command.
+// The returned function must be called once the reply is read, with the error
+// of the exchange: it returns the error of the context if the context
+// interrupted the exchange.
+func (c *ServerConn) watchCommand() (stop func(err error) error) {
+	ctx := c.ctx
+	deadline := c.controlDeadline()
+	if c.netConn == nil || (ctx == nil && deadline.IsZero()) {
+		return func(err error) error { return err }
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	watched, cancel := ctx, context.CancelFunc(func() {})
+	if !deadline.IsZero() {
+		watched, cancel = context.WithDeadline(ctx, deadline)
+	}
+	stopWatch := watchContext(watched, c.netConn)
+	return func(err error) error {
+		stopWatch()
+		cancel()
+		// The control deadline is reported as a timeout of the connection
+		return contextError(ctx, err)
+	}
+}
+
+// SetControlDeadline sets the deadline of the commands on the control
+// connection: a command whose reply is not read by t fails with a timeout
+// error, as for a net.Conn. A zero value clears the deadline.
+// The deadline of the context of a command still applies if earlier. The data
+// connections are not affected, see Response.SetDeadline.
+func (c *ServerConn) SetControlDeadline(t time.Time) {
+	c.deadline = t
+}
+
+// SetReadTimeout sets the maximum time to wait for each reply read on the
+// control connection, after which the command fails with a timeout error.
+// A zero value disables the timeout. See SetControlDeadline.
+func (c *ServerConn) SetReadTimeout(d time.Duration) {
+	c.readTimeout = d
+}
+
+// controlDeadline returns the deadline of a command starting now, set by
+// SetControlDeadline and SetReadTimeout, or the zero time.
+func (c *ServerConn) controlDeadline() time.Time {
+	deadline := c.deadline
+	if c.readTimeout > 0 {
+		if t := time.Now().Add(c.readTimeout); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline
+}
+
+// contextError returns the error of ctx if it caused err, and err otherwise.
+// The deadline of the connection may expire before the one of ctx reports it.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if deadline, ok := ctx.Deadline(); ok && os.IsTimeout(err) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// watchContext applies the deadline of ctx to conn and interrupts pending I/O
+// on conn when ctx is canceled, without starting a goroutine.
+// The returned function must be called once the I/O is complete: it clears
+// the deadline of conn, and a cancellation of ctx after it returned does not
+// affect conn anymore.
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		conn.SetDeadline(deadline)
+	}
+
+	if ctx.Done() == nil {
+		if hasDeadline {
+			return func() { conn.SetDeadline(time.Time{}) }
+		}
+		return func() {}
+	}
+
+	var mu sync.Mutex
+	stopped := false
+	stopAfter := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			conn.SetDeadline(time.Unix(1, 0))
+		}
+	})
+
+	return func() {
+		stopAfter()
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+		conn.SetDeadline(time.Time{})
+	}
+}
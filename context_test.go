@@ -0,0 +1,211 @@
+package ftp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// newSilentServer returns the address of a server which greets the client
+// and replies to FEAT, then never replies again
+func newSilentServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("220 Ready\r\n"))
+		r := bufio.NewReader(conn)
+		if _, err := r.ReadString('\n'); err != nil {
+			return
+		}
+		conn.Write([]byte("211 No features\r\n"))
+
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestCommandContextDeadline(t *testing.T) {
+	c, err := Dial(newSilentServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = c.LoginAnonymous(ctx, "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the login was not interrupted, took %s", elapsed)
+	}
+}
+
+func TestCommandContextCanceled(t *testing.T) {
+	c, err := Dial(newSilentServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if err := c.LoginAnonymous(ctx, ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWatchContextStopped(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := watchContext(ctx, client)
+	stop()
+
+	// A cancellation after stop must not affect the connection
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	go server.Write([]byte("x"))
+	if _, err := client.Read(make([]byte, 1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuitContextDeadline(t *testing.T) {
+	c, err := Dial(newSilentServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := c.QuitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestQuitContextKeepalive(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.ignoreCommand = "NOOP"
+
+	c, err := Dial(mock.Addr(), DialWithKeepalive(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the keepalive wait for the reply to its NOOP
+	time.Sleep(1600 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.QuitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("QuitContext waited for the keepalive, took %s", elapsed)
+	}
+}
+
+func TestQuitContext(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.QuitContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mock.Wait()
+
+	if n := len(mock.commands); n == 0 || mock.commands[n-1] != "QUIT" {
+		t.Errorf("expected QUIT, got %v", mock.commands)
+	}
+}
+
+func TestLogoutContextDeadline(t *testing.T) {
+	c, err := Dial(newSilentServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := c.LogoutContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestSetReadTimeout(t *testing.T) {
+	c, err := Dial(newSilentServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	c.SetReadTimeout(50 * time.Millisecond)
+	err = c.NoOp()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestSetControlDeadline(t *testing.T) {
+	c, err := Dial(newSilentServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	c.SetControlDeadline(time.Now().Add(50 * time.Millisecond))
+
+	// The earlier deadline applies
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := c.LoginAnonymous(ctx, ""); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.SetControlDeadline(time.Now().Add(time.Hour))
+	if err := c.LoginAnonymous(ctx, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
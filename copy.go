@@ -0,0 +1,60 @@
+package ftp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// Copy copies the file at from to the path to on the server.
+//
+// The copy is made by the server with SITE CPFR and SITE CPTO when supported,
+// as by the mod_copy module of ProFTPD, unless SiteCommands did not list
+// CPFR. Otherwise, the file is retrieved into a local temporary file, then
+// stored to the destination.
+// The deadline and cancellation of ctx bound the commands and transfers.
+func (c *ServerConn) Copy(ctx context.Context, from, to string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer c.withContext(ctx)()
+
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	if c.siteCommands != nil && !c.siteCommands["CPFR"] {
+		c.noSiteCopy = true
+	}
+
+	if !c.noSiteCopy {
+		_, _, err := c.cmd(StatusRequestFilePending, "SITE CPFR %s", from)
+		if err == nil {
+			_, _, err = c.cmd(StatusRequestedFileActionOK, "SITE CPTO %s", to)
+			return err
+		}
+
+		if !isNotImplemented(err) {
+			return err
+		}
+		c.noSiteCopy = true
+	}
+
+	return c.copyThrough(ctx, from, to)
+}
+
+// copyThrough copies a file by retrieving it into a temporary file.
+func (c *ServerConn) copyThrough(ctx context.Context, from, to string) error {
+	dir, err := os.MkdirTemp("", "ftp-copy-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "file")
+	if _, err := c.DownloadFile(ctx, from, tmpPath, &DownloadOptions{NoResume: true, NoSync: true}); err != nil {
+		return err
+	}
+	_, err = c.UploadFile(ctx, tmpPath, to)
+	return err
+}
@@ -0,0 +1,39 @@
+package ftp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCopySite(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"SITE": {"350 Source exists", "250 Copy successful"},
+	})
+
+	if err := c.Copy(context.Background(), "file", "copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"SITE", "SITE"})
+}
+
+func TestCopyThrough(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	if err := c.Copy(context.Background(), "file", "copy"); err != nil {
+		t.Fatal(err)
+	}
+	if string(mock.stored) != testData {
+		t.Errorf("stored %q, expected %q", mock.stored, testData)
+	}
+
+	// SITE CPFR is not tried again once rejected
+	if err := c.Copy(context.Background(), "file", "copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{
+		"SITE", "SIZE", "EPSV", "RETR", "EPSV", "STOR",
+		"SIZE", "EPSV", "RETR", "EPSV", "STOR",
+	})
+}
@@ -1,6 +1,49 @@
 package ftp
 
-import "io"
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DebugRedaction selects the command arguments hidden in the output given to
+// DialWithDebugOutput.
+type DebugRedaction int
+
+// The redaction modes of the debug output
+const (
+	// RedactPasswords hides the arguments of PASS and ACCT. It is the default.
+	RedactPasswords DebugRedaction = iota
+	// RedactCredentials also hides the user name given to USER.
+	RedactCredentials
+	// RedactNothing writes the commands as sent, for lab use only.
+	RedactNothing
+)
+
+// DialWithDebugRedaction returns a DialOption that configures the arguments
+// hidden in the output given to DialWithDebugOutput.
+func DialWithDebugRedaction(redaction DebugRedaction) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.debugRedaction = redaction
+	}}
+}
+
+// DialWithDebugTranscript returns a DialOption that configures the ServerConn
+// to write to w a transcript of the control connection: each line is
+// prefixed with a timestamp and with ">" if it was sent by the client or "<"
+// if it was received from the server.
+// Passwords are hidden, see DialWithDebugRedaction.
+func DialWithDebugTranscript(w io.Writer) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.debugOutput = w
+		do.debugTranscript = true
+	}}
+}
+
+// transcriptTimeFormat is the format of the timestamps of the transcript.
+const transcriptTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
 
 type debugWrapper struct {
 	conn io.ReadWriteCloser
@@ -8,10 +51,20 @@ type debugWrapper struct {
 	io.Writer
 }
 
-func newDebugWrapper(conn io.ReadWriteCloser, w io.Writer) io.ReadWriteCloser {
+func newDebugWrapper(conn io.ReadWriteCloser, w io.Writer, redaction DebugRedaction, transcript bool) io.ReadWriteCloser {
+	commands, replies := w, w
+	if transcript {
+		mu := new(sync.Mutex)
+		commands = &transcriptWriter{w: w, mu: mu, direction: ">"}
+		replies = &transcriptWriter{w: w, mu: mu, direction: "<"}
+	}
+	if redaction != RedactNothing {
+		commands = &redactWriter{w: commands, redaction: redaction}
+	}
+
 	return &debugWrapper{
-		Reader: io.TeeReader(conn, w),
-		Writer: io.MultiWriter(w, conn),
+		Reader: io.TeeReader(conn, replies),
+		Writer: io.MultiWriter(commands, conn),
 		conn:   conn,
 	}
 }
@@ -19,3 +72,66 @@ func newDebugWrapper(conn io.ReadWriteCloser, w io.Writer) io.ReadWriteCloser {
 func (w *debugWrapper) Close() error {
 	return w.conn.Close()
 }
+
+// redactWriter hides the secret arguments of the command lines written to it.
+type redactWriter struct {
+	w         io.Writer
+	redaction DebugRedaction
+	buf       []byte // incomplete line
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+
+	for {
+		i := bytes.IndexByte(r.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		line := r.redactLine(string(r.buf[:i+1]))
+		r.buf = append(r.buf[:0], r.buf[i+1:]...)
+		if _, err := io.WriteString(r.w, line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// transcriptWriter writes the lines written to it with a timestamp and the
+// direction of the exchange.
+type transcriptWriter struct {
+	w         io.Writer
+	mu        *sync.Mutex // shared by both directions
+	direction string
+	buf       []byte // incomplete line
+}
+
+func (t *transcriptWriter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		line := strings.TrimRight(string(t.buf[:i]), "\r")
+		t.buf = append(t.buf[:0], t.buf[i+1:]...)
+
+		t.mu.Lock()
+		_, err := io.WriteString(t.w, time.Now().Format(transcriptTimeFormat)+" "+t.direction+" "+line+"\n")
+		t.mu.Unlock()
+		if err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// redactLine hides the argument of line if it is a secret.
+func (r *redactWriter) redactLine(line string) string {
+	verb := strings.ToUpper(commandVerb(strings.TrimRight(line, "\r\n")))
+	if verb == "PASS" || verb == "ACCT" || (verb == "USER" && r.redaction == RedactCredentials) {
+		return line[:len(verb)] + " ****\r\n"
+	}
+	return line
+}
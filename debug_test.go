@@ -0,0 +1,84 @@
+package ftp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugOutputRedaction(t *testing.T) {
+	for _, test := range []struct {
+		redaction DebugRedaction
+		expected  []string
+		hidden    []string
+	}{
+		{RedactPasswords, []string{"USER anonymous\r\n", "PASS ****\r\n"}, []string{"PASS anonymous"}},
+		{RedactCredentials, []string{"USER ****\r\n", "PASS ****\r\n"}, []string{"USER anonymous", "PASS anonymous"}},
+		{RedactNothing, []string{"USER anonymous\r\n", "PASS anonymous\r\n"}, nil},
+	} {
+		buf := new(bytes.Buffer)
+		mock, c := openConn(t, "127.0.0.1", DialWithDebugOutput(buf), DialWithDebugRedaction(test.redaction))
+		closeConn(t, mock, c, nil)
+
+		output := buf.String()
+		for _, expected := range test.expected {
+			if !strings.Contains(output, expected) {
+				t.Errorf("redaction %d: expected %q in the output, got:\n%s", test.redaction, expected, output)
+			}
+		}
+		for _, hidden := range test.hidden {
+			if strings.Contains(output, hidden) {
+				t.Errorf("redaction %d: expected no %q in the output, got:\n%s", test.redaction, hidden, output)
+			}
+		}
+		if !strings.Contains(output, "230 Access granted") {
+			t.Errorf("redaction %d: expected the replies in the output, got:\n%s", test.redaction, output)
+		}
+	}
+}
+
+func TestDebugTranscript(t *testing.T) {
+	buf := new(bytes.Buffer)
+	mock, c := openConn(t, "127.0.0.1", DialWithDebugTranscript(buf))
+	closeConn(t, mock, c, nil)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expected := []string{
+		"< 220 FTP Server ready.",
+		"> FEAT",
+		"> USER anonymous",
+		"< 331 Please send your password",
+		"> PASS ****",
+		"< 230-Hey,",
+		"< Welcome to my FTP",
+		"< 230 Access granted",
+		"> TYPE I",
+		"> QUIT",
+	}
+
+	var got []string
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			t.Fatalf("invalid transcript line %q", line)
+		}
+		if _, err := time.Parse(transcriptTimeFormat, fields[0]); err != nil {
+			t.Errorf("invalid timestamp in %q: %v", line, err)
+		}
+		got = append(got, fields[1])
+	}
+
+	for _, line := range expected {
+		found := false
+		for _, gotLine := range got {
+			if gotLine == line {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected %q in the transcript, got:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedCompression is matched by the errors reporting a file
+// compressed in a format without decoder, such as zstd, see
+// TransferWithDecompression.
+var ErrUnsupportedCompression = errors.New("ftp: unsupported compression format")
+
+// errDecompressOffset is returned by RetrFrom when asked to decompress a file
+// from the middle of its compressed data.
+var errDecompressOffset = errors.New("ftp: can not decompress a transfer from an offset")
+
+// Decompressor decodes a compression format, see TransferWithDecompression.
+type Decompressor struct {
+	// Name is the name of the format, such as "gzip".
+	Name string
+
+	// Magic is the signature starting the data in the format.
+	Magic []byte
+
+	// Extensions are the extensions of the files in the format, such as
+	// ".gz", for the data without signature.
+	Extensions []string
+
+	// NewReader returns a reader of the decompressed data of r.
+	// The format is not supported if nil.
+	NewReader func(r io.Reader) (io.Reader, error)
+}
+
+// DefaultDecompressors decode gzip and bzip2. zstd is detected, but needs a
+// decoder given to TransferWithDecompression, such as the one of
+// github.com/klauspost/compress/zstd.
+var DefaultDecompressors = []Decompressor{
+	{
+		Name:       "gzip",
+		Magic:      []byte("\x1f\x8b"),
+		Extensions: []string{".gz", ".tgz"},
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		Name:       "bzip2",
+		Magic:      []byte("BZh"),
+		Extensions: []string{".bz2", ".tbz2"},
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r), nil
+		},
+	},
+	{
+		Name:       "zstd",
+		Magic:      []byte("\x28\xb5\x2f\xfd"),
+		Extensions: []string{".zst"},
+	},
+}
+
+// TransferWithDecompression returns a TransferOption that makes Retr return
+// the decompressed data of a file compressed in one of the formats of
+// DefaultDecompressors, or of decompressors, which take precedence. The
+// format is detected by the signature starting the data, or else by the
+// extension of the file. The data of the other files is returned as is.
+// RetrFrom fails if the offset is not 0.
+func TransferWithDecompression(decompressors ...Decompressor) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.decompressors = append(append(to.decompressors, decompressors...), DefaultDecompressors...)
+	}}
+}
+
+// decompressedResponse is the Responser of a Retr transfer with
+// TransferWithDecompression.
+type decompressedResponse struct {
+	resp          *Response
+	raw           *bufio.Reader
+	decompressors []Decompressor
+
+	r   io.Reader // data returned by Read, nil until the format is detected
+	err error     // error of the detection
+}
+
+// newDecompressedResponse returns resp, decompressed by the first of
+// decompressors matching its data.
+func newDecompressedResponse(resp *Response, decompressors []Decompressor) *decompressedResponse {
+	return &decompressedResponse{
+		resp:          resp,
+		raw:           bufio.NewReader(resp),
+		decompressors: decompressors,
+	}
+}
+
+// detect selects the decompressor of the data.
+func (d *decompressedResponse) detect() error {
+	var magicLen int
+	for _, dec := range d.decompressors {
+		magicLen = max(magicLen, len(dec.Magic))
+	}
+	head, err := d.raw.Peek(magicLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	dec := d.match(func(dec Decompressor) bool {
+		return len(dec.Magic) > 0 && bytes.HasPrefix(head, dec.Magic)
+	})
+	if dec == nil {
+		ext := path.Ext(d.resp.path)
+		dec = d.match(func(dec Decompressor) bool {
+			for _, e := range dec.Extensions {
+				if strings.EqualFold(ext, e) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	switch {
+	case dec == nil:
+		d.r = d.raw
+	case dec.NewReader == nil:
+		return fmt.Errorf("%w: %s", ErrUnsupportedCompression, dec.Name)
+	default:
+		if d.r, err = dec.NewReader(d.raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match returns the first decompressor matching f, or nil.
+func (d *decompressedResponse) match(f func(dec Decompressor) bool) *Decompressor {
+	for i := range d.decompressors {
+		if f(d.decompressors[i]) {
+			return &d.decompressors[i]
+		}
+	}
+	return nil
+}
+
+func (d *decompressedResponse) Read(p []byte) (int, error) {
+	if d.r == nil {
+		if d.err == nil {
+			d.err = d.detect()
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+	}
+
+	n, err := d.r.Read(p)
+	if err == io.EOF && d.r != io.Reader(d.raw) {
+		// The transfer is only complete, and verifiable by Close, once the
+		// data following the compressed stream, usually none, is read
+		if _, rawErr := io.Copy(io.Discard, d.raw); rawErr != nil {
+			return n, rawErr
+		}
+	}
+	return n, err
+}
+
+func (d *decompressedResponse) Close() error {
+	if closer, ok := d.r.(io.Closer); ok {
+		closer.Close()
+	}
+	return d.resp.Close()
+}
+
+// Abort aborts the transfer, as Response.Abort.
+func (d *decompressedResponse) Abort() error {
+	return d.resp.Abort()
+}
+
+func (d *decompressedResponse) SetDeadline(t time.Time) error {
+	return d.resp.SetDeadline(t)
+}
@@ -0,0 +1,103 @@
+package ftp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// bzip2Data is "hello bzip2\n" compressed by bzip2
+const bzip2Data = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\xab\x6b\xa1\xf1\x00\x00\x02\xd9\x80\x00\x10\x40\x00\x10\x00\x12\x64\xc0\x10\x20\x00\x31\x00\xd3\x4d\x04\x00\x1e\xa3\xef\x4e\x51\xa2\x07\x8b\xb9\x22\x9c\x28\x48\x55\xb5\xd0\xf8\x80"
+
+func gzipData(t *testing.T, data string) string {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDecompression(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		path     string
+		data     string
+		expected string
+	}{
+		{"gzip", "export", gzipData(t, testData), testData},
+		{"bzip2", "export.bz2", bzip2Data, "hello bzip2\n"},
+		{"plain", "export.csv", testData, testData},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, c := openConn(t, "127.0.0.1")
+			mock.retrData = tc.data
+
+			r, err := c.Retr(tc.path, TransferWithDecompression())
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != tc.expected {
+				t.Errorf("read %q, expected %q", buf, tc.expected)
+			}
+
+			closeConn(t, mock, c, []string{"EPSV", "RETR"})
+		})
+	}
+}
+
+func TestDecompressionZstd(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	mock.retrData = "\x28\xb5\x2f\xfd fake frame"
+
+	r, err := c.Retr("export.zst", TransferWithDecompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrUnsupportedCompression) {
+		t.Errorf("expected ErrUnsupportedCompression, got %v", err)
+	}
+	r.(*decompressedResponse).Abort()
+
+	// A decoder given for the format is used
+	zstd := Decompressor{
+		Name:  "zstd",
+		Magic: []byte("\x28\xb5\x2f\xfd"),
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			buf, err := ioutil.ReadAll(r)
+			return strings.NewReader(strings.ToUpper(string(buf[4:]))), err
+		},
+	}
+	r, err = c.Retr("export.zst", TransferWithDecompression(zstd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != " FAKE FRAME" {
+		t.Errorf("read %q", buf)
+	}
+
+	if _, err := c.RetrFrom("export.zst", 10, TransferWithDecompression()); err != errDecompressOffset {
+		t.Errorf("expected errDecompressOffset, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "ABOR", "NOOP", "EPSV", "RETR"})
+}
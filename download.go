@@ -0,0 +1,151 @@
+package ftp
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// DownloadOptions configures DownloadFile.
+// The zero value resumes partial downloads, syncs the file to disk and does
+// not preserve the modification time.
+type DownloadOptions struct {
+	// TempSuffix is appended to the local path while the download is in
+	// progress. Defaults to ".part".
+	TempSuffix string
+
+	// NoResume restarts the download from scratch instead of continuing an
+	// existing temporary file.
+	NoResume bool
+
+	// PreserveTime sets the modification time of the local file to the one
+	// of the remote file, as reported by MDTM.
+	PreserveTime bool
+
+	// NoSync skips the fsync of the file before it is renamed.
+	NoSync bool
+}
+
+// DownloadFile retrieves remotePath into localPath.
+//
+// The content is first written to a temporary file next to localPath, which
+// is renamed once the transfer is complete. If the temporary file already
+// exists, the download is resumed from its current length using REST. When
+// the server reports the size of the file, a temporary file of that size is
+// taken as complete, and a longer one is downloaded again from scratch.
+// A *ShortTransferError is returned if the server closes the transfer before
+// the size reported by SIZE.
+// The deadline and cancellation of ctx bound the transfer.
+func (c *ServerConn) DownloadFile(ctx context.Context, remotePath, localPath string, opts *DownloadOptions) (*TransferStats, error) {
+	defer c.withContext(ctx)()
+
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+
+	tmpPath := localPath + opts.TempSuffix
+	if opts.TempSuffix == "" {
+		tmpPath = localPath + ".part"
+	}
+
+	var mtime time.Time
+	if opts.PreserveTime {
+		var err error
+		if mtime, err = c.GetTime(remotePath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var offset uint64
+	if fi, err := os.Stat(tmpPath); err == nil && !opts.NoResume {
+		offset = uint64(fi.Size())
+	}
+
+	// The size, when known, detects the transfers closed early by the server,
+	// and the temporary files already complete or longer than the remote file
+	size := int64(-1)
+	if c.HasFeature("SIZE") {
+		if s, err := c.FileSize(remotePath); err == nil {
+			size = s
+		}
+	}
+	if size >= 0 && offset > uint64(size) {
+		offset = 0
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset > 0 {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(tmpPath, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := &TransferStats{Offset: offset, Resumed: offset > 0}
+	if size < 0 || offset < uint64(size) {
+		start := time.Now()
+		stats.Bytes, err = c.download(ctx, f, remotePath, offset, size)
+		stats.Elapsed = time.Since(start)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	if !opts.NoSync {
+		if err := f.Sync(); err != nil {
+			return stats, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return stats, err
+	}
+
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return stats, err
+	}
+
+	if opts.PreserveTime {
+		if err := os.Chtimes(localPath, mtime, mtime); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// download appends the content of remotePath from offset to f. size is the
+// size of the remote file, or -1 if unknown.
+func (c *ServerConn) download(ctx context.Context, f *os.File, remotePath string, offset uint64, size int64) (int64, error) {
+	to := &transferOptions{}
+	if size >= 0 {
+		to.expectedSize = size
+	}
+	r, err := c.retrFrom(remotePath, offset, to)
+	if err != nil {
+		return 0, err
+	}
+
+	stop := watchContext(ctx, r.conn)
+	n, err := c.copyData(f, r)
+	stop()
+	if err == nil {
+		return n, r.Close()
+	}
+
+	// On cancellation or a local failure, the transfer is aborted to keep
+	// the connection usable, out of the canceled context
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		defer c.withContext(context.Background())()
+	}
+	r.Abort()
+	return n, err
+}
@@ -0,0 +1,262 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock, c := openConn(t, "127.0.0.1")
+
+	localPath := filepath.Join(dir, "file")
+	stats, err := c.DownloadFile(context.Background(), "file", localPath, &DownloadOptions{PreserveTime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes != int64(len(testData)) || stats.Offset != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	buf, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testData {
+		t.Errorf("read %q, expected %q", buf, testData)
+	}
+
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2020, 11, 12, 13, 14, 15, 0, time.UTC); !fi.ModTime().Equal(expected) {
+		t.Errorf("mtime %v, expected %v", fi.ModTime(), expected)
+	}
+
+	if _, err := os.Stat(localPath + ".part"); !os.IsNotExist(err) {
+		t.Error("temporary file was not renamed")
+	}
+
+	closeConn(t, mock, c, []string{"MDTM", "SIZE", "EPSV", "RETR"})
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	localPath := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(localPath+".tmp", []byte(testData[:5]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock, c := openConn(t, "127.0.0.1")
+
+	stats, err := c.DownloadFile(context.Background(), "file", localPath, &DownloadOptions{TempSuffix: ".tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes != int64(len(testData)-5) || stats.Offset != 5 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	buf, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testData {
+		t.Errorf("read %q, expected %q", buf, testData)
+	}
+
+	closeConn(t, mock, c, []string{"SIZE", "REST", "EPSV", "REST", "RETR"})
+}
+
+func TestDownloadFileCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock, c := openConn(t, "127.0.0.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = c.DownloadFile(ctx, "file", filepath.Join(dir, "file"), nil)
+	if err != context.Canceled {
+		t.Fatal("expected context.Canceled, got:", err)
+	}
+
+	closeConn(t, mock, c, nil)
+}
+
+func TestDownloadFileShort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock, c := openConn(t, "127.0.0.1")
+
+	// SIZE reports 42 bytes, the server sends less
+	localPath := filepath.Join(dir, "file")
+	_, err = c.DownloadFile(context.Background(), "magic-file", localPath, nil)
+	var shortErr *ShortTransferError
+	if !errors.As(err, &shortErr) {
+		t.Fatalf("expected a *ShortTransferError, got %v", err)
+	}
+	if shortErr.Expected != 42 || shortErr.Received != int64(len(testData)) {
+		t.Errorf("unexpected error %+v", shortErr)
+	}
+	if !errors.Is(err, ErrShortTransfer) {
+		t.Error("expected the error to match ErrShortTransfer")
+	}
+
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Error("the truncated file was renamed")
+	}
+
+	closeConn(t, mock, c, []string{"SIZE", "EPSV", "RETR", "ABOR", "NOOP"})
+}
+
+func TestDownloadFileCanceledStalled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.stallRetr = true
+
+	c, err := Dial(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The context has no deadline: the cancellation interrupts the read
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.DownloadFile(ctx, "file", filepath.Join(dir, "file"), nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatal("expected context.Canceled, got:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the canceled download did not return")
+	}
+
+	closeConn(t, mock, c, []string{"SIZE", "EPSV", "RETR", "ABOR", "NOOP"})
+}
+
+func TestDownloadFileComplete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// SIZE reports 42 bytes, as many as the temporary file
+	localPath := filepath.Join(dir, "file")
+	data := strings.Repeat("x", 42)
+	if err := ioutil.WriteFile(localPath+".part", []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock, c := openConn(t, "127.0.0.1")
+
+	stats, err := c.DownloadFile(context.Background(), "magic-file", localPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes != 0 || stats.Offset != 42 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	buf, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != data {
+		t.Errorf("read %q, expected %q", buf, data)
+	}
+
+	closeConn(t, mock, c, []string{"SIZE"})
+}
+
+func TestDownloadFileTooLong(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// SIZE reports 42 bytes, less than the temporary file
+	localPath := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(localPath+".part", make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	data := strings.Repeat("y", 42)
+	mock.retrData = data
+
+	c, err := Dial(mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.DownloadFile(context.Background(), "magic-file", localPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes != 42 || stats.Offset != 0 || stats.Resumed {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	buf, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != data {
+		t.Errorf("read %q, expected %q", buf, data)
+	}
+
+	closeConn(t, mock, c, []string{"SIZE", "EPSV", "RETR"})
+}
@@ -0,0 +1,88 @@
+package ftp
+
+import "strings"
+
+// Encoding converts file names between UTF-8 and the character set of a
+// server which does not support UTF8, such as CP1251, ISO-8859-1 or Shift-JIS.
+type Encoding interface {
+	// Encode converts s from UTF-8 to the character set of the server.
+	Encode(s string) (string, error)
+	// Decode converts s from the character set of the server to UTF-8.
+	Decode(s string) (string, error)
+}
+
+// NewEncoding returns an Encoding calling the given functions.
+// It adapts the encodings of golang.org/x/text, for instance:
+//
+//	ftp.NewEncoding(charmap.Windows1251.NewEncoder().String, charmap.Windows1251.NewDecoder().String)
+func NewEncoding(encode, decode func(s string) (string, error)) Encoding {
+	return encodingFuncs{encode: encode, decode: decode}
+}
+
+type encodingFuncs struct {
+	encode func(s string) (string, error)
+	decode func(s string) (string, error)
+}
+
+func (e encodingFuncs) Encode(s string) (string, error) {
+	return e.encode(s)
+}
+
+func (e encodingFuncs) Decode(s string) (string, error) {
+	return e.decode(s)
+}
+
+// DialWithEncoding returns a DialOption that configures the ServerConn to
+// transcode the commands, the replies and the listings with enc, so that
+// non-ASCII file names round-trip correctly.
+// enc is not used if the server advertises UTF8, unless DialWithDisabledUTF8
+// is set.
+func DialWithEncoding(enc Encoding) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.encoding = enc
+	}}
+}
+
+// encoding returns the Encoding of the server, or nil if the names are UTF-8.
+func (c *ServerConn) encoding() Encoding {
+	enc := c.options.encoding
+	if enc == nil {
+		return nil
+	}
+	if _, ok := c.features["UTF8"]; ok && !c.options.disableUTF8 {
+		return nil
+	}
+	return enc
+}
+
+// encode converts a command line to the character set of the server.
+// The telnet IAC bytes of the encoded line, such as 'я' in CP1251 or 'ÿ' in
+// ISO-8859-1, are doubled as required by RFC 854, and a CR or LF produced by
+// the encoding is rejected.
+func (c *ServerConn) encode(line string) (string, error) {
+	enc := c.encoding()
+	if enc == nil {
+		return line, nil
+	}
+	encoded, err := enc.Encode(line)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(encoded, "\r\n") {
+		return "", ErrInvalidArgument
+	}
+	return strings.ReplaceAll(encoded, string([]byte{telnetIAC}), string([]byte{telnetIAC, telnetIAC})), nil
+}
+
+// decode converts a reply or a listing line from the character set of the
+// server. Lines which can not be decoded are returned unchanged.
+func (c *ServerConn) decode(line string) string {
+	enc := c.encoding()
+	if enc == nil {
+		return line
+	}
+	if decoded, err := enc.Decode(line); err == nil {
+		return decoded
+	}
+	return line
+}
@@ -0,0 +1,122 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"unicode/utf8"
+)
+
+// latin1 is ISO-8859-1
+var latin1 = NewEncoding(
+	func(s string) (string, error) {
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			if r > 0xff {
+				return "", errors.New("not representable in ISO-8859-1")
+			}
+			b = append(b, byte(r))
+		}
+		return string(b), nil
+	},
+	func(s string) (string, error) {
+		b := make([]byte, 0, len(s))
+		for i := 0; i < len(s); i++ {
+			b = utf8.AppendRune(b, rune(s[i]))
+		}
+		return string(b), nil
+	},
+)
+
+func TestEncoding(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"PWD": {"257 \"/caf\xe9\""},
+	}, DialWithEncoding(latin1))
+
+	if err := c.ChangeDir("café"); err != nil {
+		t.Fatal(err)
+	}
+	if line := mock.lines[len(mock.lines)-1]; line != "CWD caf\xe9" {
+		t.Errorf("unexpected command %q", line)
+	}
+
+	dir, err := c.CurrentDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/café" {
+		t.Errorf("unexpected directory %q", dir)
+	}
+
+	if err := c.ChangeDir("日本"); err == nil {
+		t.Error("expected an encoding error")
+	}
+
+	closeConn(t, mock, c, []string{"CWD", "PWD"})
+}
+
+// cp1251 is the lowercase Cyrillic letters and ASCII of Windows-1251, in
+// which 'я' is the telnet IAC byte 0xff
+var cp1251 = NewEncoding(
+	func(s string) (string, error) {
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			switch {
+			case r < 0x80:
+				b = append(b, byte(r))
+			case 'а' <= r && r <= 'я':
+				b = append(b, byte(r-'а'+0xe0))
+			default:
+				return "", errors.New("not representable in Windows-1251")
+			}
+		}
+		return string(b), nil
+	},
+	func(s string) (string, error) {
+		b := make([]byte, 0, len(s))
+		for i := 0; i < len(s); i++ {
+			if s[i] >= 0xe0 {
+				b = utf8.AppendRune(b, rune(s[i])-0xe0+'а')
+			} else {
+				b = append(b, s[i])
+			}
+		}
+		return string(b), nil
+	},
+)
+
+func TestEncodingIAC(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithEncoding(cp1251))
+
+	if err := c.ChangeDir("моя"); err != nil {
+		t.Fatal(err)
+	}
+	if line := mock.lines[len(mock.lines)-1]; line != "CWD \xec\xee\xff\xff" {
+		t.Errorf("unexpected command %q", line)
+	}
+
+	var b Batch
+	b.Delete("я")
+	if _, err := c.ExecBatch(context.Background(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if line := mock.lines[len(mock.lines)-1]; line != "DELE \xff\xff" {
+		t.Errorf("unexpected command %q", line)
+	}
+
+	closeConn(t, mock, c, []string{"CWD", "DELE"})
+}
+
+func TestEncodingUTF8Server(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithEncoding(latin1))
+
+	if c.encoding() == nil {
+		t.Error("expected the encoding to be used")
+	}
+	c.features["UTF8"] = ""
+	if c.encoding() != nil {
+		t.Error("expected no encoding on a UTF8 server")
+	}
+
+	closeConn(t, mock, c, nil)
+}
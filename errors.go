@@ -0,0 +1,128 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// Errors matched by the protocol errors of the common reply codes, with
+// errors.Is.
+var (
+	// ErrNotFound is matched by 550 replies, such as for a missing file.
+	ErrNotFound = errors.New("ftp: file not found")
+	// ErrPermissionDenied is matched by 532 and 553 replies, and by 550
+	// replies denying the access to a file.
+	ErrPermissionDenied = errors.New("ftp: permission denied")
+	// ErrNotLoggedIn is matched by 530 replies.
+	ErrNotLoggedIn = errors.New("ftp: not logged in")
+	// ErrInsufficientStorage is matched by 452 and 552 replies.
+	ErrInsufficientStorage = errors.New("ftp: insufficient storage")
+	// ErrServiceClosing is matched by 421 replies, after which the server
+	// closes the control connection.
+	ErrServiceClosing = errors.New("ftp: service closing control connection")
+)
+
+// ReplyError is the protocol error of an unexpected reply.
+// errors.Is matches it with the sentinel error of its reply code, such as
+// ErrNotFound for a 550 reply, and errors.As with the *textproto.Error of the
+// reply.
+type ReplyError textproto.Error
+
+// newReplyError returns the error reporting an unexpected reply.
+func newReplyError(code int, message string) error {
+	return &ReplyError{Code: code, Msg: message}
+}
+
+func (e *ReplyError) Error() string {
+	return (*textproto.Error)(e).Error()
+}
+
+// Unwrap returns the reply as a *textproto.Error.
+func (e *ReplyError) Unwrap() error {
+	return (*textproto.Error)(e)
+}
+
+// Is makes errors.Is(err, target) report true for the sentinel error of the
+// reply code.
+func (e *ReplyError) Is(target error) bool {
+	return target != nil && e.sentinel() == target
+}
+
+// sentinel returns the sentinel error matching the reply, or nil.
+func (e *ReplyError) sentinel() error {
+	switch e.Code {
+	case StatusNotAvailable:
+		return ErrServiceClosing
+	case StatusNotLoggedIn:
+		return ErrNotLoggedIn
+	case StatusFileUnavailable:
+		msg := strings.ToLower(e.Msg)
+		if strings.Contains(msg, "permission") || strings.Contains(msg, "denied") {
+			return ErrPermissionDenied
+		}
+		return ErrNotFound
+	case StatusStorNeedAccount, StatusBadFileName:
+		return ErrPermissionDenied
+	case Status452, StatusExceededStorage:
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// ReplyCode returns the code of the reply reported by err, or 0 if err is not
+// a protocol error.
+func ReplyCode(err error) int {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+	return 0
+}
+
+// IsTemporary reports whether err is a transient negative reply (4xx): the
+// same command may succeed later.
+func IsTemporary(err error) bool {
+	code := ReplyCode(err)
+	return code >= 400 && code < 500
+}
+
+// IsPermanent reports whether err is a permanent negative reply (5xx): the
+// same command is expected to fail again.
+func IsPermanent(err error) bool {
+	code := ReplyCode(err)
+	return code >= 500 && code < 600
+}
+
+// RemoveError reports the paths which RemoveDirRecur could not delete.
+// errors.Is and errors.As match the errors of all the failures.
+type RemoveError struct {
+	Failures []RemoveFailure
+}
+
+// RemoveFailure is a path which could not be deleted, or listed.
+type RemoveFailure struct {
+	Path string
+	Err  error
+}
+
+func (e *RemoveError) Error() string {
+	if len(e.Failures) == 1 {
+		return fmt.Sprintf("ftp: can not remove %s: %v", e.Failures[0].Path, e.Failures[0].Err)
+	}
+	return fmt.Sprintf("ftp: can not remove %s and %d other paths: %v", e.Failures[0].Path, len(e.Failures)-1, e.Failures[0].Err)
+}
+
+// Unwrap returns the errors of all the failures.
+func (e *RemoveError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+func (e *RemoveError) add(path string, err error) {
+	e.Failures = append(e.Failures, RemoveFailure{Path: path, Err: err})
+}
@@ -0,0 +1,137 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"testing"
+)
+
+func TestReplySentinelErrors(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"DELE": {"550 No such file or directory"},
+		"RMD":  {"550 Permission denied"},
+		"MKD":  {"553 Bad directory name"},
+		"STOR": {"452 Insufficient storage space"},
+		"RNFR": {"350 Ready for RNTO"},
+		"RNTO": {"421 Timeout"},
+	})
+
+	_, err := c.Delete("missing")
+	checkReplyError(t, err, ErrNotFound, StatusFileUnavailable)
+
+	_, err = c.RemoveDir("forbidden")
+	checkReplyError(t, err, ErrPermissionDenied, StatusFileUnavailable)
+
+	_, err = c.MakeDir("bad\tname")
+	checkReplyError(t, err, ErrPermissionDenied, StatusBadFileName)
+
+	_, err = c.Stor("file", bytes.NewBufferString(testData))
+	checkReplyError(t, err, ErrInsufficientStorage, Status452)
+
+	_, err = c.GetTime("missing-file")
+	checkReplyError(t, err, ErrNotFound, StatusFileUnavailable)
+
+	closeConn(t, mock, c, []string{"DELE", "RMD", "MKD", "EPSV", "STOR", "MDTM"})
+}
+
+func checkReplyError(t *testing.T, err, sentinel error, code int) {
+	t.Helper()
+
+	if !errors.Is(err, sentinel) {
+		t.Errorf("expected %v, got %v", sentinel, err)
+	}
+
+	// The reply is returned as is
+	if protoErr, ok := err.(*ReplyError); !ok || protoErr.Code != code {
+		t.Errorf("expected a %d reply, got %v", code, err)
+	}
+}
+
+func TestReplyErrorIs(t *testing.T) {
+	err := newReplyError(StatusNotLoggedIn, "Please login with USER and PASS")
+	if !errors.Is(err, ErrNotLoggedIn) || errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected match of %v", err)
+	}
+
+	// The replies are matched in the chain, as the wrapped sentinel errors
+	if err := fmt.Errorf("stat: %w", newReplyError(StatusFileUnavailable, "No such file")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := fmt.Errorf("%w: file", ErrNotFound); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if errors.Is(newReplyError(StatusNotImplemented, "Not implemented"), nil) {
+		t.Error("expected no match of nil")
+	}
+}
+
+func TestReplyErrorAs(t *testing.T) {
+	err := fmt.Errorf("delete: %w", newReplyError(StatusFileUnavailable, "No such file"))
+
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != StatusFileUnavailable || protoErr.Msg != "No such file" {
+		t.Errorf("expected the 550 reply, got %v", err)
+	}
+	// The message is the one of the reply as a *textproto.Error
+	if expected := "delete: " + protoErr.Error(); err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestReplyClassification(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		code      int
+		temporary bool
+		permanent bool
+	}{
+		{newReplyError(StatusNotAvailable, "Timeout"), StatusNotAvailable, true, false},
+		{newReplyError(Status452, "Disk full"), Status452, true, false},
+		{newReplyError(StatusFileUnavailable, "No such file"), StatusFileUnavailable, false, true},
+		{fmt.Errorf("delete: %w", newReplyError(StatusBadArguments, "Syntax error")), StatusBadArguments, false, true},
+		{io.EOF, 0, false, false},
+		{nil, 0, false, false},
+	} {
+		if code := ReplyCode(test.err); code != test.code {
+			t.Errorf("ReplyCode(%v) = %d, expected %d", test.err, code, test.code)
+		}
+		if temporary := IsTemporary(test.err); temporary != test.temporary {
+			t.Errorf("IsTemporary(%v) = %v, expected %v", test.err, temporary, test.temporary)
+		}
+		if permanent := IsPermanent(test.err); permanent != test.permanent {
+			t.Errorf("IsPermanent(%v) = %v, expected %v", test.err, permanent, test.permanent)
+		}
+	}
+}
+
+func TestServiceClosing(t *testing.T) {
+	var handled []error
+	handler := func(c *ServerConn, err error) {
+		handled = append(handled, err)
+	}
+
+	mock, c := openConnReplies(t, map[string][]string{
+		"NOOP": {"421 Timeout, closing control connection"},
+	}, DialWithServiceClosingHandler(handler))
+
+	err := c.NoOp()
+	if !errors.Is(err, ErrServiceClosing) {
+		t.Errorf("expected ErrServiceClosing, got %v", err)
+	}
+	if len(handled) != 1 || handled[0] != err {
+		t.Errorf("expected the handler to be called once with %v, got %v", err, handled)
+	}
+
+	// The connection is dead: nothing is sent anymore
+	if _, err := c.Delete("file"); !errors.Is(err, ErrServiceClosing) {
+		t.Errorf("expected ErrServiceClosing, got %v", err)
+	}
+	if len(handled) != 1 {
+		t.Errorf("expected the handler to be called once, got %v", handled)
+	}
+
+	closeConn(t, mock, c, []string{"NOOP"})
+}
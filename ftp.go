@@ -1,6 +1,9 @@
 // Package ftp implements a FTP client as described in RFC 959.
 //
-// A textproto.Error is returned for errors at the protocol level.
+// A *ReplyError, unwrapping to a *textproto.Error, is returned for errors at
+// the protocol level. For the common reply codes, errors.Is matches it with
+// ErrNotFound, ErrPermissionDenied, ErrNotLoggedIn, ErrInsufficientStorage or
+// ErrServiceClosing.
 package ftp
 
 import (
@@ -8,14 +11,25 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/textproto"
+	"os"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// ErrInvalidArgument is returned when a command argument, such as a path,
+// contains a CR, LF or telnet IAC character which could inject another
+// command. Nothing is sent to the server in that case.
+var ErrInvalidArgument = errors.New("ftp: invalid character in command argument")
+
 // EntryType describes the different types of an Entry.
 type EntryType int
 
@@ -30,14 +44,64 @@ const (
 // A single connection only supports one in-flight data connection.
 // It is not safe to be called concurrently.
 type ServerConn struct {
-	options *dialOptions
-	conn    *textproto.Conn
-	host    string
+	options   *dialOptions
+	conn      *textproto.Conn
+	netConn   net.Conn // underlying the control connection
+	host      string
+	addr      string      // address the control connection was dialed to
+	tlsConfig *tls.Config // shared by the control and data connections
 
 	// Server capabilities discovered at runtime
 	features      map[string]string
 	skipEPSV      bool
 	mlstSupported bool
+	noSiteCopy    bool            // SITE CPFR was rejected, see Copy
+	siteCommands  map[string]bool // see SiteCommands, nil until discovered
+	restChecked   bool            // restSupported is known, see checkResume
+	restSupported bool
+	modeZ         bool // MODE Z is set, see DialWithCompression
+	modeZLevelSet bool // OPTS MODE Z LEVEL was sent
+	noPORT        bool // PORT was rejected, see sendPort
+	noEPRT        bool
+
+	// Session state, restored after an automatic reconnect
+	user     string
+	password string
+	loggedIn bool
+	readOnly bool // set by LoginAnonymous
+	cwd      string
+	closed   bool
+	inRetry  bool
+	sent     int   // number of command lines written, see withRetry
+	closing  error // the error after which the connection is dead, such as a 421 reply
+
+	deadline    time.Time     // see SetControlDeadline
+	readTimeout time.Duration // see SetReadTimeout
+
+	connectedAt time.Time // see DialWithMaxSessionAge
+	lastUsed    time.Time // see DialWithMaxIdleTime
+
+	transferStart   time.Time
+	transferCommand string
+	transferSize    int64 // announced by the 150 reply, -1 if unknown
+	transferSpan    Span
+	traceCtx        context.Context // parent of the spans, see withTraceContext
+	ctx             context.Context // bounds the commands, see withContext
+
+	// mu serializes the exchanges on the control connection with the
+	// keepalive goroutine.
+	mu            sync.Mutex
+	lastActivity  time.Time
+	lastCode      int    // see LastReply
+	lastMessage   string // see LastReply
+	transferring  bool
+	stopKeepalive chan struct{}
+	keepaliveErr  error // failure of the keepalive, see keepaliveTick
+
+	stats sessionStats // see Stats
+
+	transferLimit *limiter // see PoolWithMaxConcurrentTransfers
+	transferSlot  bool     // a slot of transferLimit is held
 }
 
 // DialOption represents an option to start a new connection with Dial
@@ -47,15 +111,66 @@ type DialOption struct {
 
 // dialOptions contains all the options set by DialOption.setup
 type dialOptions struct {
-	context     context.Context
-	dialer      net.Dialer
-	tlsConfig   *tls.Config
-	conn        net.Conn
-	disableEPSV bool
-	location    *time.Location
-	debugOutput io.Writer
-	dialFunc    func(network, address string) (net.Conn, error)
-	dcTimeout   time.Duration
+	context        context.Context
+	dialer         net.Dialer
+	tlsConfig      *tls.Config
+	conn           net.Conn
+	disableEPSV    bool
+	disableMLSD    bool
+	disableUTF8    bool
+	strictListing  bool
+	listErrHandler ListParseErrorHandler
+	maxListEntries int
+	maxListLine    int
+	keepDotEntries bool
+	activeMode     bool
+	location       *time.Location
+	debugOutput    io.Writer
+	dialFunc       func(network, address string) (net.Conn, error)
+	dcTimeout      time.Duration
+	closeTimeout   time.Duration
+	copyBufferSize int
+
+	autoReconnect bool
+	keepalive     time.Duration
+	maxIdleTime   time.Duration
+	maxSessionAge time.Duration
+	retryPolicy   *RetryPolicy
+
+	checkIntegrity    bool
+	proxy             *ProxyConfig
+	forcedDataHost    string
+	checkPASVAddress  bool
+	dataHostname      bool
+	dataBindIP        net.IP
+	dataTCPOptions    *TCPOptions
+	controlTCPOptions *TCPOptions
+	rateLimiters      []*RateLimiter
+	appendResume      bool
+	compression       *Compression
+
+	disableTLSServerName bool
+	featureOverrides     map[string]bool
+	encoding             Encoding
+	strictTLS            bool
+	certificatePins      [][]byte
+	skipCAValidation     bool
+	loginPrompt          LoginPrompt
+	resolver             Resolver
+	loginUser            string // see DialWithLogin
+	loginPassword        string
+	login                bool
+	logger               *slog.Logger
+	logLevels            *LogLevels
+	commandHooks         []CommandHook
+	tracer               Tracer
+	metrics              MetricsCollector
+	debugRedaction       DebugRedaction
+	debugTranscript      bool
+
+	serviceClosingHandler func(c *ServerConn, err error)
+	unsolicitedHandler    func(code int, message string)
+	maxReplySize          int
 }
 
 // Entry describes a file and is returned by List().
@@ -65,6 +180,7 @@ type Entry struct {
 	Type   EntryType
 	Size   uint64
 	Time   time.Time
+	Mode   os.FileMode // permission bits, 0 if not listed
 }
 
 // Response represents a data-connection
@@ -72,6 +188,19 @@ type Response struct {
 	conn   net.Conn
 	c      *ServerConn
 	closed bool
+	n      int64 // bytes read
+
+	path   string
+	offset uint64
+	check  *integrityCheck
+	hashes io.Writer
+	eof    bool
+	stats  *TransferStats // filled on Close
+	start  time.Time
+
+	to       *transferOptions
+	deadline time.Time // set by TransferWithMaxDuration
+	expected int64     // size of the file, -1 if unknown
 }
 
 // Responser interface on a data-connection
@@ -93,14 +222,93 @@ func Dial(addr string, options ...DialOption) (*ServerConn, error) {
 		do.location = time.UTC
 	}
 
-	tconn := do.conn
+	if do.resolver != nil {
+		return dialResolved(addr, do)
+	}
+	return dial(addr, do)
+}
+
+// dial connects to addr with the options do.
+func dial(addr string, do *dialOptions) (*ServerConn, error) {
+	c := &ServerConn{
+		options: do,
+		addr:    addr,
+	}
+
+	if do.tlsConfig != nil {
+		c.tlsConfig = newSessionTLSConfig(do.tlsConfig, addr)
+		if c.tlsConfig.ServerName == "" && !do.disableTLSServerName {
+			c.tlsConfig.ServerName = hostname(addr)
+		}
+		if len(do.certificatePins) > 0 {
+			pinTLSConfig(c.tlsConfig, do, addr)
+		}
+	} else if do.strictTLS {
+		return nil, &TLSRequiredError{Reason: "no TLS config"}
+	}
+
+	restoreCtx := c.withContext(do.context)
+	ctx, span := c.startSpan("ftp dial", slog.String("ftp.addr", addr))
+	restore := c.withTraceContext(ctx)
+	err := c.connect(do.conn)
+	restore()
+	restoreCtx()
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if do.login {
+		restoreCtx := c.withContext(do.context)
+		err := c.Login(do.loginUser, do.loginPassword)
+		restoreCtx()
+		if err != nil {
+			c.Quit()
+			return nil, err
+		}
+	}
+
+	if do.keepalive > 0 {
+		c.stopKeepalive = make(chan struct{})
+		go c.keepalive(do.keepalive, c.stopKeepalive)
+	}
+
+	return c, nil
+}
+
+// DialMulti dials the addresses in order, until a connection succeeds, and
+// returns it. Each address is dialed with the options, including
+// DialWithLogin to return an authenticated connection.
+// The next addresses are not tried once the credentials are rejected, to
+// avoid locking the account out. Is then matches the returned error with
+// ErrNotLoggedIn, otherwise it joins the errors of all the addresses.
+// The deadline and cancellation of ctx bound the whole operation.
+func DialMulti(ctx context.Context, addrs []string, options ...DialOption) (*ServerConn, error) {
+	endpoints := make([]Endpoint, len(addrs))
+	for i, addr := range addrs {
+		endpoints[i] = Endpoint{Addr: addr}
+	}
+	resolver := func(context.Context, string) ([]Endpoint, error) {
+		return endpoints, nil
+	}
+
+	options = append(options[:len(options):len(options)], DialWithContext(ctx), DialWithResolver(resolver))
+	return Dial(strings.Join(addrs, ","), options...)
+}
+
+// connect establishes the control connection, reads the server greeting and
+// discovers the server features.
+// If tconn is nil, a new connection is dialed to c.addr.
+func (c *ServerConn) connect(tconn net.Conn) error {
+	do := c.options
+
 	if tconn == nil {
 		var err error
 
 		if do.dialFunc != nil {
-			tconn, err = do.dialFunc("tcp", addr)
-		} else if do.tlsConfig != nil {
-			tconn, err = tls.DialWithDialer(&do.dialer, "tcp", addr, do.tlsConfig)
+			tconn, err = do.dialFunc("tcp", c.addr)
+		} else if c.tlsConfig != nil {
+			tconn, err = tls.DialWithDialer(&do.dialer, "tcp", c.addr, c.tlsConfig)
 		} else {
 			ctx := do.context
 
@@ -108,47 +316,122 @@ func Dial(addr string, options ...DialOption) (*ServerConn, error) {
 				ctx = context.Background()
 			}
 
-			tconn, err = do.dialer.DialContext(ctx, "tcp", addr)
+			tconn, err = do.dialer.DialContext(ctx, "tcp", c.addr)
 		}
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
+	if err := do.controlTCPOptions.apply(tconn); err != nil {
+		tconn.Close()
+		return err
+	}
+
+	if _, ok := tconn.(*tls.Conn); do.strictTLS && !ok {
+		tconn.Close()
+		return &TLSRequiredError{Reason: "control connection is not encrypted"}
+	}
+
 	// Use the resolved IP address in case addr contains a domain name
 	// If we use the domain name, we might not resolve to the same IP.
 	remoteAddr := tconn.RemoteAddr().(*net.TCPAddr)
 
 	var sourceConn io.ReadWriteCloser = tconn
 	if do.debugOutput != nil {
-		sourceConn = newDebugWrapper(tconn, do.debugOutput)
+		sourceConn = newDebugWrapper(tconn, do.debugOutput, do.debugRedaction, do.debugTranscript)
 	}
 
-	c := &ServerConn{
-		options:  do,
-		features: make(map[string]string),
-		conn:     textproto.NewConn(sourceConn),
-		host:     remoteAddr.IP.String(),
+	c.mu.Lock()
+	c.conn = textproto.NewConn(sourceConn)
+	c.netConn = tconn
+	c.transferring = false
+	restartKeepalive := c.keepaliveErr != nil
+	c.keepaliveErr = nil
+	c.mu.Unlock()
+
+	if restartKeepalive && c.stopKeepalive != nil {
+		go c.keepalive(do.keepalive, c.stopKeepalive)
 	}
 
-	_, _, err := c.conn.ReadResponse(StatusReady)
+	c.host = remoteAddr.IP.String()
+	c.skipEPSV = false
+	c.modeZ, c.modeZLevelSet = false, false
+	c.closing = nil
+	c.connectedAt = time.Now()
+	c.lastUsed = c.connectedAt
+
+	_, _, err := c.readResponse(StatusReady)
+	if err == nil {
+		err = c.discoverFeatures()
+	}
 	if err != nil {
-		c.Quit()
-		return nil, err
+		c.conn.Cmd("QUIT")
+		c.conn.Close()
+		return err
 	}
 
-	err = c.feat()
-	if err != nil {
-		c.Quit()
-		return nil, err
+	return nil
+}
+
+// discoverFeatures (re)initializes the server capabilities from FEAT.
+func (c *ServerConn) discoverFeatures() error {
+	c.features = make(map[string]string)
+	c.mlstSupported = false
+	c.restChecked = false
+
+	if err := c.feat(); err != nil {
+		return err
+	}
+
+	for name, enabled := range c.options.featureOverrides {
+		if !enabled {
+			delete(c.features, name)
+		} else if _, ok := c.features[name]; !ok {
+			c.features[name] = ""
+		}
 	}
 
-	if _, mlstSupported := c.features["MLST"]; mlstSupported {
+	if _, mlstSupported := c.features["MLST"]; mlstSupported && !c.options.disableMLSD {
 		c.mlstSupported = true
 	}
 
-	return c, nil
+	return nil
+}
+
+// Features returns the features advertised by the server in its reply to FEAT,
+// mapped to their parameters, such as "STREAM" for "REST".
+// The names are upper case. The map is a copy.
+func (c *ServerConn) Features() map[string]string {
+	features := make(map[string]string, len(c.features))
+	for name, value := range c.features {
+		features[name] = value
+	}
+	return features
+}
+
+// HasFeature reports whether the server advertised the feature name in its
+// reply to FEAT, regardless of case.
+func (c *ServerConn) HasFeature(name string) bool {
+	_, ok := c.features[strings.ToUpper(name)]
+	return ok
+}
+
+// DialWithFeatureOverride returns a DialOption that configures the ServerConn to
+// consider the feature name as supported or not, regardless of the reply of
+// the server to FEAT. It works around servers advertising features they do not
+// implement correctly, for instance by disabling MLST, or which support
+// features they do not advertise, such as UTF8.
+// A feature enabled this way has no parameters, unless the server advertised
+// it.
+func DialWithFeatureOverride(name string, enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		if do.featureOverrides == nil {
+			do.featureOverrides = make(map[string]bool)
+		}
+		do.featureOverrides[strings.ToUpper(name)] = enabled
+	}}
 }
 
 // DialWithTimeout returns a DialOption that configures the ServerConn with specified timeout
@@ -180,6 +463,100 @@ func DialWithDisabledEPSV(disabled bool) DialOption {
 	}}
 }
 
+// DialWithDisabledMLSD returns a DialOption that configures the ServerConn to
+// list directories with LIST, even if the server advertises MLST, for servers
+// whose MLSD output is broken.
+func DialWithDisabledMLSD(disabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.disableMLSD = disabled
+	}}
+}
+
+// DialWithStrictListing returns a DialOption that configures the ServerConn
+// to fail the listings containing a line which can not be parsed, with a
+// *ListParseError, instead of skipping the line. Empty lines and the
+// "total" lines of ls are still ignored.
+func DialWithStrictListing(strict bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.strictListing = strict
+	}}
+}
+
+// ErrListLimit is returned by the listings exceeding the maximum number of
+// entries set with DialWithMaxListEntries.
+var ErrListLimit = errors.New("ftp: too many entries in listing")
+
+// DialWithMaxListEntries returns a DialOption that configures the ServerConn
+// to abort the listings of more than max entries with ErrListLimit, as a
+// safety cap against huge directories. Zero, the default, means no limit.
+func DialWithMaxListEntries(max int) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.maxListEntries = max
+	}}
+}
+
+// DialWithMLSDDotEntries returns a DialOption that configures the ServerConn
+// to keep the entries of type cdir and pdir, usually named "." and "..", in
+// the MLSD listings. They are filtered out by default.
+func DialWithMLSDDotEntries(keep bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.keepDotEntries = keep
+	}}
+}
+
+// DialWithMaxListLineLength returns a DialOption that configures the
+// maximum length of the lines of the listings, 64 KiB by default. Longer
+// lines, such as MLSD lines with many facts, fail the listing with a
+// *ListLineTooLongError.
+func DialWithMaxListLineLength(max int) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.maxListLine = max
+	}}
+}
+
+// ListLineTooLongError reports a listing line longer than the maximum set
+// with DialWithMaxListLineLength.
+// errors.Is(err, bufio.ErrTooLong) reports true for a *ListLineTooLongError.
+type ListLineTooLongError struct {
+	Max int
+}
+
+func (e *ListLineTooLongError) Error() string {
+	return fmt.Sprintf("ftp: listing line longer than %d bytes", e.Max)
+}
+
+// Unwrap returns bufio.ErrTooLong.
+func (e *ListLineTooLongError) Unwrap() error {
+	return bufio.ErrTooLong
+}
+
+// ListParseErrorHandler is called with each listing line which can not be
+// parsed, and the error of the parser. The entry it returns, if not nil, is
+// added to the listing, so that applications can parse the formats unknown
+// to the package.
+type ListParseErrorHandler func(line string, err error) *Entry
+
+// DialWithListParseErrorHandler returns a DialOption that configures the
+// ServerConn to call handler with the listing lines which can not be parsed,
+// for instance to log or count them. Empty lines and the "total" lines of ls
+// are ignored.
+// In strict listing mode, the lines for which handler returns nil still fail
+// the listing.
+func DialWithListParseErrorHandler(handler ListParseErrorHandler) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.listErrHandler = handler
+	}}
+}
+
+// DialWithDisabledUTF8 returns a DialOption that configures the ServerConn not
+// to send "OPTS UTF8 ON" after the login, even if the server advertises UTF8.
+// Some legacy servers drop the connection on unknown OPTS.
+func DialWithDisabledUTF8(disabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.disableUTF8 = disabled
+	}}
+}
+
 // DialWithLocation returns a DialOption that configures the ServerConn with specified time.Location
 // The location is used to parse the dates sent by the server which are in server's timezone
 func DialWithLocation(location *time.Location) DialOption {
@@ -207,8 +584,64 @@ func DialWithTLS(tlsConfig *tls.Config) DialOption {
 	}}
 }
 
+// DialWithDisabledTLSServerName returns a DialOption that configures the
+// ServerConn to leave the ServerName of the TLS config empty.
+//
+// By default, when the TLS config given to DialWithTLS has no ServerName, it
+// is set to the host name of the address given to Dial, so that the
+// certificate is verified against it and SNI is sent on both the control and
+// the data connections, which are dialed to IP addresses.
+func DialWithDisabledTLSServerName(disabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.disableTLSServerName = disabled
+	}}
+}
+
+// LoginPrompt returns the command line sent in response to an intermediate
+// reply received during Login, such as a one-time password challenge.
+// An empty line selects the default response, which is PASS with the
+// password given to Login after the 331 reply to USER, and failing otherwise.
+type LoginPrompt func(code int, message string) (line string, err error)
+
+// DialWithLoginPrompt returns a DialOption that configures the ServerConn to
+// call prompt with each 3xx reply received during Login, for servers asking
+// for additional steps such as OTP challenges or ACCT.
+// For instance, a prompt returning "PASS " followed by a token answers a
+// "331 give me token" reply.
+func DialWithLoginPrompt(prompt LoginPrompt) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.loginPrompt = prompt
+	}}
+}
+
+// DialWithLogin returns a DialOption that configures Dial to log in with
+// user and password, so that the returned ServerConn is authenticated.
+// The connection is closed if the login fails.
+func DialWithLogin(user, password string) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.loginUser = user
+		do.loginPassword = password
+		do.login = true
+	}}
+}
+
+// DialWithServiceClosingHandler returns a DialOption that configures the
+// ServerConn to call handler as soon as the server replies 421, meaning that
+// it is closing the control connection, for instance on idle timeout or
+// shutdown. errors.Is matches err with ErrServiceClosing.
+// The connection is then dead: the next commands fail with err without being
+// sent, unless DialWithAutoReconnect is enabled.
+func DialWithServiceClosingHandler(handler func(c *ServerConn, err error)) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.serviceClosingHandler = handler
+	}}
+}
+
 // DialWithDebugOutput returns a DialOption that configures the ServerConn to write to the Writer
-// everything it reads from the server
+// everything it reads from the server.
+// Passwords are hidden, see DialWithDebugRedaction.
+// See DialWithDebugTranscript for a readable transcript, and DialWithLogger
+// for structured logging.
 func DialWithDebugOutput(w io.Writer) DialOption {
 	return DialOption{func(do *dialOptions) {
 		do.debugOutput = w
@@ -237,6 +670,98 @@ func DialWithDataConnectionTimeout(timeout time.Duration) DialOption {
 	}}
 }
 
+// DialWithCloseTimeout returns a DialOption that bounds how long Response.Close
+// and Response.Abort wait for the server to acknowledge the end of a transfer.
+// Once the timeout expires, the control connection is marked broken: the next
+// commands fail with ErrConnectionBroken, unless the connection is
+// automatically reconnected.
+func DialWithCloseTimeout(timeout time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.closeTimeout = timeout
+	}}
+}
+
+// DialWithAppendResume returns a DialOption that configures StorFrom to upload
+// from a non-zero offset with APPE instead of REST and STOR, for servers
+// rejecting REST before STOR such as many vsftpd setups.
+// The size of the remote file is checked against the offset first, if the
+// server supports SIZE.
+func DialWithAppendResume(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.appendResume = enabled
+	}}
+}
+
+// DialWithForcedDataHost returns a DialOption that configures the ServerConn to
+// open data connections to the given host, ignoring the address advertised in
+// PASV replies. This is useful for servers behind NAT that advertise a private
+// address, the host being for instance the address of a public load balancer.
+func DialWithForcedDataHost(host string) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.forcedDataHost = host
+	}}
+}
+
+// DialWithPASVAddressCheck returns a DialOption that configures the ServerConn
+// to ignore the address advertised in a PASV reply, and use the host of the
+// control connection instead, when the advertised address is unspecified
+// (0.0.0.0) or is a private/unroutable address while the control connection
+// goes to a routable one, as commonly seen with servers behind NAT.
+func DialWithPASVAddressCheck(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.checkPASVAddress = enabled
+	}}
+}
+
+// DialWithDataHostname returns a DialOption that configures the ServerConn to
+// open data connections to the host name given to Dial, instead of the IP
+// address the control connection was resolved to, so that DNS based load
+// balancing and split-horizon DNS also apply to them. It applies to EPSV, and
+// to PASV replies whose address is unspecified or ignored, see
+// DialWithPASVAddressCheck.
+func DialWithDataHostname(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.dataHostname = enabled
+	}}
+}
+
+// DialWithDataBindIP returns a DialOption that configures the ServerConn to
+// bind data connections to the given local IP address, independently of the
+// LocalAddr of the dialer used for the control connection.
+// This is needed on multi-homed hosts when the server requires the data and
+// control connections to come from the same address.
+// It has no effect on connections established by DialWithDialFunc.
+func DialWithDataBindIP(ip net.IP) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.dataBindIP = ip
+	}}
+}
+
+// DialWithAutoReconnect returns a DialOption that configures the ServerConn to
+// transparently reconnect when the control connection drops (421 reply, EOF,
+// network error or timeout).
+// The session is restored by logging in again with the last credentials and
+// changing back to the last working directory, then the failed command is
+// retried once if its effect does not change when repeated, such as CWD, SIZE
+// or opening a RETR transfer, or if it was not sent yet. Rename sends RNFR and
+// RNTO again. The other commands, such as DELE or STOR, fail with the error of
+// the lost connection. Data already transferred is not replayed.
+func DialWithAutoReconnect(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.autoReconnect = enabled
+	}}
+}
+
+// DialWithKeepalive returns a DialOption that configures the ServerConn to send
+// a NOOP command on the control connection whenever it has been idle for the
+// given interval. No NOOP is sent while a data transfer is in progress.
+// This prevents servers and NAT devices from dropping idle sessions.
+func DialWithKeepalive(interval time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.keepalive = interval
+	}}
+}
+
 // Connect is an alias to Dial, for backward compatibility
 func Connect(addr string) (*ServerConn, error) {
 	return Dial(addr)
@@ -255,21 +780,32 @@ func DialTimeout(addr string, timeout time.Duration) (*ServerConn, error) {
 // "anonymous"/"anonymous" is a common user/password scheme for FTP servers
 // that allows anonymous read-only accounts.
 func (c *ServerConn) Login(user, password string) error {
-	code, message, err := c.cmd(-1, "USER %s", user)
+	return c.login(user, password, false)
+}
+
+// login authenticates the client, anonymously if anonymous is true, and
+// initializes the session.
+func (c *ServerConn) login(user, password string, anonymous bool) (err error) {
+	ctx, span := c.startSpan("ftp login", slog.String("ftp.user", user))
+	defer c.withTraceContext(ctx)()
+	defer func() { endSpan(span, err) }()
+
+	switch {
+	case c.options.proxy != nil:
+		err = c.proxyLogin(user, password)
+	case anonymous:
+		err = c.authenticateAnonymous(password)
+	default:
+		err = c.authenticate(user, password)
+	}
 	if err != nil {
 		return err
 	}
 
-	switch code {
-	case StatusLoggedIn:
-	case StatusUserOK:
-		_, _, err = c.cmd(StatusLoggedIn, "PASS %s", password)
-		if err != nil {
-			return err
-		}
-	default:
-		return errors.New(message)
-	}
+	c.user = user
+	c.password = password
+	c.loggedIn = true
+	c.readOnly = anonymous
 
 	// Switch to binary mode
 	if _, _, err = c.cmd(StatusCommandOK, "TYPE I"); err != nil {
@@ -281,18 +817,63 @@ func (c *ServerConn) Login(user, password string) error {
 
 	// If using implicit TLS, make data connections also use TLS
 	if c.options.tlsConfig != nil {
-		c.cmd(StatusCommandOK, "PBSZ 0")
-		c.cmd(StatusCommandOK, "PROT P")
+		if perr := c.protectDataConns(); perr != nil {
+			return perr
+		}
 	}
 
 	return err
 }
 
+// authenticate issues the USER and PASS FTP commands, and the responses
+// to the other intermediate replies given by the LoginPrompt.
+func (c *ServerConn) authenticate(user, password string) error {
+	code, message, err := c.cmd(-1, "USER %s", user)
+	if err != nil {
+		return err
+	}
+	if code != StatusLoggedIn && code/100 != 3 {
+		return newReplyError(code, message)
+	}
+
+	for sentPass := false; code/100 == 3; {
+		var line string
+		if c.options.loginPrompt != nil {
+			line, err = c.options.loginPrompt(code, message)
+			if err != nil {
+				return err
+			}
+		}
+		if line == "" {
+			if code != StatusUserOK || sentPass {
+				return newReplyError(code, message)
+			}
+			line = "PASS " + password
+			sentPass = true
+		}
+
+		code, message, err = c.cmd(-1, "%s", line)
+		if err != nil {
+			return err
+		}
+	}
+
+	if code != StatusLoggedIn {
+		return newReplyError(code, message)
+	}
+	return nil
+}
+
 // feat issues a FEAT FTP command to list the additional commands supported by
 // the remote FTP server.
 // FEAT is described in RFC 2389
 func (c *ServerConn) feat() error {
 	code, message, err := c.cmd(-1, "FEAT")
+	var tooLong *ReplyTooLongError
+	if errors.As(err, &tooLong) {
+		// Use the features of the truncated reply
+		err = nil
+	}
 	if err != nil {
 		return err
 	}
@@ -312,7 +893,7 @@ func (c *ServerConn) feat() error {
 		line = strings.TrimSpace(line)
 		featureElements := strings.SplitN(line, " ", 2)
 
-		command := featureElements[0]
+		command := strings.ToUpper(featureElements[0])
 
 		var commandDesc string
 		if len(featureElements) == 2 {
@@ -325,9 +906,28 @@ func (c *ServerConn) feat() error {
 	return nil
 }
 
+// Opts issues an OPTS command setting the options of command, such as
+// Opts(ctx, "HASH", "SHA-256") or Opts(ctx, "MLST", "type;size;modify;").
+// The value is omitted if it is empty.
+// It returns the message of the reply, which must be 200.
+// The deadline and cancellation of ctx bound the command.
+func (c *ServerConn) Opts(ctx context.Context, command, value string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	defer c.withContext(ctx)()
+
+	line := "OPTS " + command
+	if value != "" {
+		line += " " + value
+	}
+	_, message, err := c.cmd(StatusCommandOK, "%s", line)
+	return message, err
+}
+
 // setUTF8 issues an "OPTS UTF8 ON" command.
 func (c *ServerConn) setUTF8() error {
-	if _, ok := c.features["UTF8"]; !ok {
+	if _, ok := c.features["UTF8"]; !ok || c.options.disableUTF8 {
 		return nil
 	}
 
@@ -379,49 +979,64 @@ func (c *ServerConn) pasv() (host string, port int, err error) {
 		return
 	}
 
-	// PASV response format : 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2).
-	start := strings.Index(line, "(")
-	end := strings.LastIndex(line, ")")
-	if start == -1 || end == -1 {
-		err = errors.New("invalid PASV response format")
-		return
+	host, port, err = parsePASVReply(line)
+	if err == nil && net.ParseIP(host).IsUnspecified() {
+		// The server omitted its address, as some behind NAT do
+		host = c.dataHost()
 	}
+	return
+}
 
-	// We have to split the response string
-	pasvData := strings.Split(line[start+1:end], ",")
-
-	if len(pasvData) < 6 {
-		err = errors.New("invalid PASV response format")
-		return
-	}
+// pasvAddrRegexp matches the h1,h2,h3,h4,p1,p2 address of a PASV reply
+var pasvAddrRegexp = regexp.MustCompile(`\b(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3}),(\d{1,3})\b`)
 
-	// Let's compute the port number
-	portPart1, err1 := strconv.Atoi(pasvData[4])
-	if err1 != nil {
-		err = err1
-		return
+// parsePASVReply parses the message of a PASV reply. The usual format is
+// "Entering Passive Mode (h1,h2,h3,h4,p1,p2).", but some servers omit the
+// parentheses or add text around the address.
+func parsePASVReply(line string) (host string, port int, err error) {
+	m := pasvAddrRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, errors.New("invalid PASV response format")
 	}
 
-	portPart2, err2 := strconv.Atoi(pasvData[5])
-	if err2 != nil {
-		err = err2
-		return
+	var fields [6]int
+	for i := range fields {
+		fields[i], _ = strconv.Atoi(m[i+1])
+		if fields[i] > 255 {
+			return "", 0, errors.New("invalid PASV response format")
+		}
 	}
 
-	// Recompose port
-	port = portPart1*256 + portPart2
-
-	// Make the IP address to connect to
-	host = strings.Join(pasvData[0:4], ".")
-	return
+	host = fmt.Sprintf("%d.%d.%d.%d", fields[0], fields[1], fields[2], fields[3])
+	port = fields[4]*256 + fields[5]
+	return host, port, nil
 }
 
 // getDataConnPort returns a host, port for a new data connection
 // it uses the best available method to do so
 func (c *ServerConn) getDataConnPort() (string, int, error) {
+	host, port, err := c.passivePort()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if c.options.checkPASVAddress && !isUsableDataHost(host, c.host) {
+		host = c.dataHost()
+	}
+
+	if c.options.forcedDataHost != "" {
+		host = c.options.forcedDataHost
+	}
+
+	return host, port, nil
+}
+
+// passivePort returns the host, port advertised by the server with EPSV or
+// PASV
+func (c *ServerConn) passivePort() (string, int, error) {
 	if !c.options.disableEPSV && !c.skipEPSV {
 		if port, err := c.epsv(); err == nil {
-			return c.host, port, nil
+			return c.dataHost(), port, nil
 		}
 
 		// if there is an error, skip EPSV for the next attempts
@@ -431,75 +1046,613 @@ func (c *ServerConn) getDataConnPort() (string, int, error) {
 	return c.pasv()
 }
 
-// openDataConn creates a new FTP data connection.
-func (c *ServerConn) openDataConn() (net.Conn, error) {
-	host, port, err := c.getDataConnPort()
-	if err != nil {
-		return nil, err
+// dataHost returns the host of the control connection, to which the data
+// connections are opened unless the server advertises another address.
+func (c *ServerConn) dataHost() string {
+	if c.options.dataHostname {
+		return hostname(c.addr)
 	}
+	return c.host
+}
 
-	addr := net.JoinHostPort(host, strconv.Itoa(port))
-	if c.options.dialFunc != nil {
-		return c.options.dialFunc("tcp", addr)
+// isUsableDataHost reports whether the data host advertised by the server can
+// be used, given the host of the control connection.
+func isUsableDataHost(dataHost, controlHost string) bool {
+	dataIP := net.ParseIP(dataHost)
+	if dataIP == nil || dataIP.Equal(net.ParseIP(controlHost)) {
+		return true
 	}
-
-	if c.options.tlsConfig != nil {
-		conn, err := c.options.dialer.Dial("tcp", addr)
-		if err != nil {
-			return nil, err
-		}
-		return tls.Client(conn, c.options.tlsConfig), err
+	if dataIP.IsUnspecified() {
+		return false
 	}
 
-	return c.options.dialer.Dial("tcp", addr)
+	controlIP := net.ParseIP(controlHost)
+	return controlIP == nil || isRoutableIP(dataIP) || !isRoutableIP(controlIP)
 }
 
-// cmd is a helper function to execute a command and check for the expected FTP
-// return code
-func (c *ServerConn) cmd(expected int, format string, args ...interface{}) (int, string, error) {
-	_, err := c.conn.Cmd(format, args...)
+// Address blocks not routable on the Internet, in addition to the loopback
+// and link-local ones
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
 	if err != nil {
-		return 0, "", err
+		panic(err)
 	}
+	return ipNet
+}
 
-	return c.conn.ReadResponse(expected)
+// isRoutableIP reports whether ip is a public address.
+func isRoutableIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, ipNet := range privateNetworks {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
 }
 
-// cmdDataConnFrom executes a command which require a FTP data connection.
-// Issues a REST FTP command to specify the number of bytes to skip for the transfer.
-func (c *ServerConn) cmdDataConnFrom(offset uint64, format string, args ...interface{}) (net.Conn, error) {
-	conn, err := c.openDataConn()
+// openDataConn creates a new FTP data connection.
+func (c *ServerConn) openDataConn() (net.Conn, error) {
+	if c.options.activeMode {
+		conn, err := c.openActiveDataConn()
+		if err == nil && c.tlsConfig != nil {
+			conn = tls.Client(conn, c.tlsConfig)
+		}
+		return conn, err
+	}
+
+	host, port, err := c.getDataConnPort()
 	if err != nil {
 		return nil, err
 	}
 
-	if offset != 0 {
-		_, _, err := c.cmd(StatusRequestFilePending, "REST %d", offset)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if c.options.dialFunc != nil {
+		conn, err := c.options.dialFunc("tcp", addr)
 		if err != nil {
-			conn.Close()
 			return nil, err
 		}
+		if err := c.tuneDataConn(conn); err != nil {
+			return nil, err
+		}
+		return conn, nil
 	}
 
-	_, err = c.conn.Cmd(format, args...)
-	if err != nil {
-		conn.Close()
-		return nil, err
+	dialer := c.options.dialer
+	if c.options.dataBindIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: c.options.dataBindIP}
 	}
 
-	code, msg, err := c.conn.ReadResponse(-1)
+	conn, err := dialer.Dial("tcp", addr)
 	if err != nil {
-		conn.Close()
 		return nil, err
 	}
-	if code != StatusAlreadyOpen && code != StatusAboutToSend {
-		conn.Close()
-		return nil, &textproto.Error{Code: code, Msg: msg}
+	if err := c.tuneDataConn(conn); err != nil {
+		return nil, err
+	}
+
+	if c.tlsConfig != nil {
+		return tls.Client(conn, c.tlsConfig), nil
+	}
+	return conn, nil
+}
+
+// tuneDataConn applies the options set by DialWithDataTCPOptions to a data
+// connection, which is closed on failure.
+func (c *ServerConn) tuneDataConn(conn net.Conn) error {
+	if err := c.options.dataTCPOptions.apply(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// cmd is a helper function to execute a command and check for the expected FTP
+// return code
+func (c *ServerConn) cmd(expected int, format string, args ...interface{}) (code int, message string, err error) {
+	err = c.withRetry(replayable(format), func() error {
+		code, message, err = c.rawCmd(expected, format, args...)
+		return err
+	})
+	return code, message, err
+}
+
+// rawCmd sends a command and reads its reply.
+// A 421 reply is always reported as an error since the server is closing the
+// control connection.
+func (c *ServerConn) rawCmd(expected int, format string, args ...interface{}) (int, string, error) {
+	c.checkExpired()
+	if c.closing != nil {
+		return 0, "", c.closing
+	}
+
+	line, err := c.checkCommand(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+
+	span := c.traceCommand(line)
+	start := time.Now()
+	code, message, err := c.exchange(expected, line)
+	err = c.finishCommand(line, span, start, code, message, err)
+	return code, message, err
+}
+
+// checkCommand prepares a command line to be sent.
+func (c *ServerConn) checkCommand(format string, args ...interface{}) (string, error) {
+	line, err := c.beforeCommand(fmt.Sprintf(format, args...))
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(line, "\r\n") || strings.IndexByte(line, telnetIAC) >= 0 {
+		return "", ErrInvalidArgument
+	}
+	return line, nil
+}
+
+// finishCommand reports the reply to a command line sent at start to the
+// logs, metrics, hooks and traces.
+func (c *ServerConn) finishCommand(line string, span Span, start time.Time, code int, message string, err error) error {
+	c.logCommand(line, code, err)
+	if m := c.options.metrics; m != nil {
+		m.ObserveCommand(commandVerb(line), code)
+	}
+	c.checkServiceClosing(err)
+	err = c.afterReply(line, code, message, time.Since(start), err)
+	endSpan(span, err, slog.Int("ftp.reply_code", code))
+	return err
+}
+
+// exchange sends a command line and reads its reply.
+func (c *ServerConn) exchange(expected int, line string) (int, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keepaliveErr != nil {
+		return 0, "", c.keepaliveErr
+	}
+
+	encoded, err := c.encode(line)
+	if err != nil {
+		return 0, "", err
+	}
+
+	stop := c.watchCommand()
+	if err := c.skipUnsolicited(); err != nil {
+		return 0, "", stop(err)
+	}
+	c.sent++
+	if _, err = c.conn.Cmd("%s", encoded); err != nil {
+		return 0, "", stop(err)
+	}
+
+	code, message, err := c.readReply(expected)
+	message = c.decode(message)
+	err = stop(err)
+	c.lastActivity = time.Now()
+	c.lastUsed = c.lastActivity
+	c.setLastReply(code, message)
+	if err == nil && code == StatusNotAvailable {
+		err = newReplyError(code, message)
+	}
+	return code, message, err
+}
+
+// LastReply returns the code and the message of the last reply read from the
+// server, or 0 if none was read yet. The replies to the NOOP commands sent by
+// the keepalive and by Response.Abort are not recorded.
+func (c *ServerConn) LastReply() (code int, message string) {
+	return c.lastCode, c.lastMessage
+}
+
+// setLastReply records a reply for LastReply, if one was read.
+func (c *ServerConn) setLastReply(code int, message string) {
+	if code != 0 {
+		c.lastCode, c.lastMessage = code, message
+	}
+}
+
+// ErrConnectionBroken is returned by the commands of a ServerConn whose control
+// connection is in an unknown state, see DialWithCloseTimeout.
+var ErrConnectionBroken = errors.New("ftp: control connection broken")
+
+// errTransferAborted is the cause reported to the logs, traces and metrics
+// for transfers aborted by Response.Abort.
+var errTransferAborted = errors.New("ftp: transfer aborted")
+
+// telnetIAC is the telnet "interpret as command" byte, which servers may
+// strip or interpret from the control connection.
+const telnetIAC = 0xff
+
+// readResponse reads a reply which is not directly preceded by a command,
+// such as the greeting or the end of a data transfer.
+func (c *ServerConn) readResponse(expected int) (int, string, error) {
+	c.mu.Lock()
+	stop := c.watchCommand()
+	code, message, err := c.readReply(expected)
+	message = c.decode(message)
+	err = stop(err)
+	c.lastActivity = time.Now()
+	c.lastUsed = c.lastActivity
+	c.setLastReply(code, message)
+	c.mu.Unlock()
+
+	c.checkServiceClosing(err)
+	return code, message, err
+}
+
+// checkServiceClosing marks the connection dead if err is a 421 reply, and
+// calls the handler given to DialWithServiceClosingHandler.
+// The next commands fail with err, unless the connection is automatically
+// reconnected.
+func (c *ServerConn) checkServiceClosing(err error) {
+	if c.closing != nil || !errors.Is(err, ErrServiceClosing) {
+		return
+	}
+
+	c.closing = err
+	if handler := c.options.serviceClosingHandler; handler != nil {
+		handler(c, err)
+	}
+}
+
+// endTransfer reads the reply closing a data transfer of n bytes.
+func (c *ServerConn) endTransfer(n int64) (int, string, error) {
+	code, message, err := c.readResponse(StatusClosingDataConnection)
+	c.setTransferring(false)
+	c.finishTransfer(n, code, err)
+	return code, message, err
+}
+
+// boundTransferEnd bounds the wait for the end of a data transfer with the
+// timeout set by DialWithCloseTimeout.
+// The returned function must be called with the error of the wait: if the
+// timeout expired, the control connection is marked broken since the reply
+// may still arrive later.
+func (c *ServerConn) boundTransferEnd() (done func(err error) error) {
+	d := c.options.closeTimeout
+	if d <= 0 {
+		return func(err error) error { return err }
+	}
+
+	parent := c.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, d)
+	c.ctx = ctx
+
+	return func(err error) error {
+		c.ctx = parent
+		cancel()
+		if err == nil || parent.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if c.closing == nil {
+			c.closing = ErrConnectionBroken
+		}
+		return fmt.Errorf("%w: %w", ErrConnectionBroken, err)
+	}
+}
+
+// abortTransfer aborts a data transfer which failed before its end, after n
+// bytes: the data connection is closed and ABOR is sent, so that the control
+// connection remains usable.
+func (c *ServerConn) abortTransfer(conn net.Conn, n int64, cause error) error {
+	conn.Close()
+	err := c.abor()
+	c.setTransferring(false)
+	c.finishTransfer(n, 0, cause)
+	return err
+}
+
+// abor issues an ABOR FTP command and reads all the replies of the aborted
+// transfer.
+// The server may have replied to the transfer already, and replies to ABOR
+// with one or two replies depending on the state of the transfer: a NOOP is
+// sent after ABOR, so that its reply marks the end of the replies to skip.
+func (c *ServerConn) abor() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stop := c.watchCommand()
+	if _, err := c.conn.Cmd("ABOR"); err != nil {
+		return stop(err)
+	}
+	if _, err := c.conn.Cmd("NOOP"); err != nil {
+		return stop(err)
+	}
+
+	for {
+		code, message, err := c.readReply(-1)
+		c.lastActivity = time.Now()
+		if err != nil {
+			return stop(err)
+		}
+
+		switch code {
+		case StatusCommandOK:
+			return stop(nil)
+		case StatusNotAvailable:
+			return stop(newReplyError(code, message))
+		}
+	}
+}
+
+// startTransfer records the start of a data transfer for the logs, traces
+// and metrics.
+func (c *ServerConn) startTransfer(line string, offset uint64) {
+	c.transferStart = time.Now()
+	c.transferCommand = line
+	c.logTransferStart(line, offset)
+	_, c.transferSpan = c.startSpan("ftp transfer",
+		slog.String("ftp.command", line),
+		slog.Uint64("ftp.offset", offset))
+}
+
+// finishTransfer records the end of a data transfer of n bytes, with the
+// code of the final reply, for the logs, traces and metrics.
+func (c *ServerConn) finishTransfer(n int64, code int, err error) {
+	elapsed := time.Since(c.transferStart)
+	c.logTransferEnd(n, code, elapsed, err)
+	endSpan(c.transferSpan, err, slog.Int64("ftp.bytes", n), slog.Int("ftp.reply_code", code))
+	c.transferSpan = nil
+	direction := transferDirection(c.transferCommand)
+	c.stats.add(direction, n, err)
+	if m := c.options.metrics; m != nil {
+		m.ObserveTransfer(direction, n, elapsed, err)
+	}
+}
+
+// setTransferring marks whether a data transfer is in progress.
+func (c *ServerConn) setTransferring(transferring bool) {
+	c.mu.Lock()
+	c.transferring = transferring
+	c.mu.Unlock()
+
+	if !transferring {
+		c.releaseTransferSlot()
+	}
+}
+
+// acquireTransferSlot waits for a slot of the transfer limit of the Pool of
+// the connection, if any.
+func (c *ServerConn) acquireTransferSlot() error {
+	if c.transferLimit == nil || c.transferSlot {
+		return nil
+	}
+
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := c.transferLimit.acquire(ctx); err != nil {
+		return err
+	}
+	c.transferSlot = true
+	return nil
+}
+
+// releaseTransferSlot frees the slot taken by acquireTransferSlot, if any.
+func (c *ServerConn) releaseTransferSlot() {
+	if c.transferSlot {
+		c.transferSlot = false
+		c.transferLimit.release()
+	}
+}
+
+// reconnect dials a new control connection and restores the session:
+// credentials, transfer type, UTF-8 and TLS settings, and working directory.
+func (c *ServerConn) reconnect() error {
+	err := c.restoreSession()
+	if m := c.options.metrics; m != nil {
+		m.ObserveReconnect(err)
+	}
+	return err
+}
+
+// restoreSession performs the reconnection.
+func (c *ServerConn) restoreSession() error {
+	c.conn.Close()
+
+	c.log(c.logLevels().Reconnect, "ftp reconnecting", "addr", c.addr)
+	if err := c.connect(nil); err != nil {
+		c.log(c.logLevels().Reconnect, "ftp reconnect failed", "addr", c.addr, "error", err)
+		return err
 	}
 
+	if !c.loggedIn {
+		return nil
+	}
+
+	if err := c.login(c.user, c.password, c.readOnly); err != nil {
+		return err
+	}
+
+	if c.cwd != "" {
+		if _, _, err := c.rawCmd(StatusRequestedFileActionOK, "CWD %s", c.cwd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isConnectionError reports whether err means the control connection is no
+// longer usable. The network errors of the data connections do not count.
+func (c *ServerConn) isConnectionError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && !isBrokenConnError(err) {
+		return c.onControlConn(opErr)
+	}
+	return isNetworkError(err)
+}
+
+// onControlConn reports whether opErr occurred on the control connection.
+func (c *ServerConn) onControlConn(opErr *net.OpError) bool {
+	if c.netConn == nil || opErr.Addr == nil {
+		return false
+	}
+	if opErr.Source != nil && opErr.Source.String() != c.netConn.LocalAddr().String() {
+		return false
+	}
+	return opErr.Addr.String() == c.netConn.RemoteAddr().String()
+}
+
+// isNetworkError reports whether err is a network failure, of the control
+// connection or of a data connection, or a 421 reply.
+func isNetworkError(err error) bool {
+	if isBrokenConnError(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code == StatusNotAvailable
+	}
+
+	return false
+}
+
+// isBrokenConnError reports whether err matches one of the errors of a closed
+// or broken connection.
+func isBrokenConnError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrConnectionBroken)
+}
+
+// cmdDataConnFrom executes a command which require a FTP data connection.
+// Issues a REST FTP command to specify the number of bytes to skip for the transfer.
+func (c *ServerConn) cmdDataConnFrom(offset uint64, format string, args ...interface{}) (net.Conn, error) {
+	return c.cmdDataConnMode(offset, false, format, args...)
+}
+
+// cmdDataConnMode is cmdDataConnFrom, in MODE Z if compress is true. The
+// returned connection still carries the compressed data.
+func (c *ServerConn) cmdDataConnMode(offset uint64, compress bool, format string, args ...interface{}) (conn net.Conn, err error) {
+	err = c.withRetry(replayable(format), func() error {
+		conn, err = c.rawCmdDataConnFrom(offset, compress, format, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return limitRate(conn, c.options.rateLimiters...), nil
+}
+
+// rawCmdDataConnFrom opens the data connection and issues the command,
+// without reconnecting on failure.
+func (c *ServerConn) rawCmdDataConnFrom(offset uint64, compress bool, format string, args ...interface{}) (net.Conn, error) {
+	if err := c.acquireTransferSlot(); err != nil {
+		return nil, err
+	}
+	conn, err := c.startDataCmd(offset, compress, format, args...)
+	if err != nil {
+		c.releaseTransferSlot()
+	}
+	return conn, err
+}
+
+// startDataCmd opens the data connection and issues the command.
+func (c *ServerConn) startDataCmd(offset uint64, compress bool, format string, args ...interface{}) (net.Conn, error) {
+	if err := c.setModeZ(compress); err != nil {
+		return nil, err
+	}
+
+	if offset != 0 {
+		if err := c.checkResume(); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := c.openDataConn()
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != 0 {
+		_, _, err := c.cmd(StatusRequestFilePending, "REST %d", offset)
+		if err != nil {
+			conn.Close()
+			if isNotImplemented(err) {
+				c.restChecked, c.restSupported = true, false
+				return nil, fmt.Errorf("%w: %w", ErrResumeNotSupported, err)
+			}
+			return nil, err
+		}
+	}
+
+	code, msg, err := c.rawCmd(-1, format, args...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if code != StatusAlreadyOpen && code != StatusAboutToSend {
+		conn.Close()
+		return nil, newReplyError(code, msg)
+	}
+
+	c.setTransferring(true)
+	c.transferSize = parseTransferSize(msg)
+	c.startTransfer(fmt.Sprintf(format, args...), offset)
 	return conn, nil
 }
 
+// ErrResumeNotSupported is returned by the transfers from a non-zero offset
+// when the server does not support REST.
+var ErrResumeNotSupported = errors.New("ftp: server does not support resuming transfers (REST)")
+
+// checkResume returns ErrResumeNotSupported if the server does not support
+// REST STREAM. Servers which do not advertise REST in their reply to FEAT
+// are probed once with "REST 0".
+func (c *ServerConn) checkResume() error {
+	if !c.restChecked {
+		if c.HasFeature("REST") {
+			c.restSupported = true
+		} else {
+			code, msg, err := c.cmd(-1, "REST 0")
+			if err != nil {
+				return err
+			}
+			if code != StatusRequestFilePending && !isNotImplemented(newReplyError(code, msg)) {
+				return newReplyError(code, msg)
+			}
+			c.restSupported = code == StatusRequestFilePending
+		}
+		c.restChecked = true
+	}
+
+	if !c.restSupported {
+		return ErrResumeNotSupported
+	}
+	return nil
+}
+
+// isNotImplemented reports whether err is a reply rejecting a command as
+// unknown or not implemented.
+func isNotImplemented(err error) bool {
+	switch ReplyCode(err) {
+	case StatusBadCommand, StatusBadArguments, StatusNotImplemented, StatusNotImplementedParameter:
+		return true
+	}
+	return false
+}
+
 // resetDcTimeout restart timeout for a connection
 func (c *ServerConn) resetDcTimeout(conn net.Conn) {
 	if c.options.dcTimeout > 0 {
@@ -508,68 +1661,193 @@ func (c *ServerConn) resetDcTimeout(conn net.Conn) {
 }
 
 // NameList issues an NLST FTP command.
+// The path may be a wildcard pattern such as "*.csv", expanded by the server.
+// As many servers reply 450 or 550 to patterns matching no file, these
+// replies result in an empty list when path contains a wildcard character.
 func (c *ServerConn) NameList(path string) (entries []string, err error) {
 	conn, err := c.cmdDataConnFrom(0, "NLST %s", path)
 	if err != nil {
+		if code := ReplyCode(err); hasWildcard(path) && (code == StatusFileActionIgnored || code == StatusFileUnavailable) {
+			return nil, nil
+		}
 		return
 	}
 
 	r := &Response{conn: conn, c: c}
 	defer r.Close()
 
-	scanner := bufio.NewScanner(r)
+	scanner := c.newListScanner(r)
 	c.resetDcTimeout(conn)
 	for scanner.Scan() {
-		entries = append(entries, scanner.Text())
+		if max := c.options.maxListEntries; max > 0 && len(entries) == max {
+			r.Abort()
+			return nil, ErrListLimit
+		}
+		entries = append(entries, c.decode(scanner.Text()))
 		c.resetDcTimeout(conn)
 	}
 	if err = scanner.Err(); err != nil {
-		return entries, err
+		return entries, c.listScanError(r, err)
 	}
 	return
 }
 
+// hasWildcard reports whether path contains a character of the glob patterns
+// expanded by the servers.
+func hasWildcard(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// newListScanner returns a scanner of the lines of a listing, limited to the
+// maximum line length.
+func (c *ServerConn) newListScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	if max := c.options.maxListLine; max > 0 {
+		initial := 4096
+		if max < initial {
+			initial = max
+		}
+		scanner.Buffer(make([]byte, 0, initial), max)
+	}
+	return scanner
+}
+
+// listScanError returns the error of the scanner of the listing r, aborting
+// the transfer if a line is too long.
+func (c *ServerConn) listScanError(r *Response, err error) error {
+	if err != bufio.ErrTooLong {
+		return err
+	}
+	r.Abort()
+	max := c.options.maxListLine
+	if max <= 0 {
+		max = bufio.MaxScanTokenSize
+	}
+	return &ListLineTooLongError{Max: max}
+}
+
 // List issues a LIST FTP command.
 func (c *ServerConn) List(path string) (entries []*Entry, err error) {
-	var cmd string
-	var parser parseFunc
+	cmd, parser := c.listCommand()
+	return c.list(cmd, parser, path, c.options.location)
+}
+
+// ListFunc lists path as List does, calling fn with each entry as it is
+// received instead of returning them all, so that huge directories are
+// listed in bounded memory.
+// If fn returns an error, the transfer is aborted and ListFunc returns it.
+// The deadline and cancellation of ctx bound the listing.
+func (c *ServerConn) ListFunc(ctx context.Context, path string, fn func(*Entry) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer c.withContext(ctx)()
+
+	cmd, parser := c.listCommand()
+	return c.listFunc(cmd, parser, path, c.options.location, fn)
+}
+
+// ListWithOptions issues a LIST FTP command with the ls options flags, such
+// as "-la" to include the dotfiles hidden by several servers. The options
+// are only honored by some servers, and always use LIST rather than MLSD.
+// The header lines of recursive listings made with "-R" are skipped, so the
+// entries of the subdirectories are returned without their directory.
+// The deadline and cancellation of ctx bound the listing.
+func (c *ServerConn) ListWithOptions(ctx context.Context, path, flags string) ([]*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if flags != "" && !strings.HasPrefix(flags, "-") {
+		return nil, fmt.Errorf("ftp: invalid LIST options %q", flags)
+	}
+	defer c.withContext(ctx)()
 
+	cmd := "LIST"
+	if flags != "" {
+		cmd += " " + flags
+	}
+	return c.list(cmd, parseListLine, path, c.options.location)
+}
+
+// listCommand returns the listing command supported by the server, and its
+// parser.
+func (c *ServerConn) listCommand() (string, parseFunc) {
 	if c.mlstSupported {
-		cmd = "MLSD"
-		parser = parseRFC3659ListLine
-	} else {
-		cmd = "LIST"
-		parser = parseListLine
+		return "MLSD", parseRFC3659ListLine
 	}
+	return "LIST", parseListLine
+}
 
+// list issues a listing command and parses its lines, with the times in loc.
+func (c *ServerConn) list(cmd string, parser parseFunc, path string, loc *time.Location) (entries []*Entry, err error) {
+	err = c.listFunc(cmd, parser, path, loc, func(entry *Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// listFunc issues a listing command and calls fn with each parsed entry.
+func (c *ServerConn) listFunc(cmd string, parser parseFunc, path string, loc *time.Location, fn func(*Entry) error) error {
 	conn, err := c.cmdDataConnFrom(0, "%s %s", cmd, path)
 	if err != nil {
-		return
+		return err
 	}
 
 	r := &Response{conn: conn, c: c}
 	defer r.Close()
 
-	scanner := bufio.NewScanner(r)
+	scanner := c.newListScanner(r)
 	c.resetDcTimeout(conn)
 	now := time.Now()
+	var parseErr error
+	n := 0
 	for scanner.Scan() {
-		entry, err := parser(scanner.Text(), now, c.options.location)
-		if err == nil {
-			entries = append(entries, entry)
+		line := c.decode(scanner.Text())
+		entry, err := parser(line, now, loc)
+		if err != nil && c.options.listErrHandler != nil && !isListHeader(line) {
+			if entry = c.options.listErrHandler(line, err); entry != nil {
+				err = nil
+			}
+		}
+
+		if err == nil && cmd == "MLSD" && !c.options.keepDotEntries && isMLSDDotEntry(line) {
+			c.resetDcTimeout(conn)
+			continue
+		}
+
+		if err == nil && parseErr == nil {
+			if n++; c.options.maxListEntries > 0 && n > c.options.maxListEntries {
+				err = ErrListLimit
+			} else {
+				err = fn(entry)
+			}
+			if err != nil {
+				r.Abort()
+				return err
+			}
+		} else if err != nil && c.options.strictListing && parseErr == nil && !isListHeader(line) {
+			// Read the rest of the listing to end the transfer cleanly
+			parseErr = &ListParseError{Line: line, Err: err}
 		}
 		c.resetDcTimeout(conn)
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return c.listScanError(r, err)
 	}
-	return
+	return parseErr
 }
 
 // ChangeDir issues a CWD FTP command, which changes the current directory to
 // the specified path.
 func (c *ServerConn) ChangeDir(path string) error {
 	_, _, err := c.cmd(StatusRequestedFileActionOK, "CWD %s", path)
+	if err == nil {
+		c.trackCurrentDir()
+	}
 	return err
 }
 
@@ -578,25 +1856,128 @@ func (c *ServerConn) ChangeDir(path string) error {
 // with a path set to "..".
 func (c *ServerConn) ChangeDirToParent() error {
 	_, _, err := c.cmd(StatusRequestedFileActionOK, "CDUP")
+	if err == nil {
+		c.trackCurrentDir()
+	}
+	return err
+}
+
+// DirChange is the result of ChangeDirWithResult.
+type DirChange struct {
+	// Message is the message of the reply to CWD.
+	Message string
+	// Path is the current directory after the change, as reported by PWD,
+	// which may differ from the requested path on servers aliasing the
+	// directories.
+	Path string
+}
+
+// ChangeDirWithResult changes the current directory to path as ChangeDir
+// does, and returns the message of the server and the resulting directory.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) ChangeDirWithResult(ctx context.Context, path string) (*DirChange, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer c.withContext(ctx)()
+
+	_, msg, err := c.cmd(StatusRequestedFileActionOK, "CWD %s", path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := c.CurrentDir()
+	if err != nil {
+		return nil, err
+	}
+	if c.options.autoReconnect {
+		c.cwd = dir
+	}
+	return &DirChange{Message: msg, Path: dir}, nil
+}
+
+// ChangeDirAndRestore changes the current directory to path, calls fn, and
+// changes back to the previous directory, even if fn fails.
+// The error of fn is returned first, then the error of the restoration.
+// The deadline and cancellation of ctx bound the commands, but not fn.
+func (c *ServerConn) ChangeDirAndRestore(ctx context.Context, path string, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	restore := c.withContext(ctx)
+
+	cwd, err := c.CurrentDir()
+	if err == nil {
+		err = c.ChangeDir(path)
+	}
+	restore()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+
+	defer c.withContext(ctx)()
+	if restoreErr := c.ChangeDir(cwd); err == nil {
+		err = restoreErr
+	}
 	return err
 }
 
+// trackCurrentDir records the working directory so that it can be restored
+// after an automatic reconnect.
+func (c *ServerConn) trackCurrentDir() {
+	if !c.options.autoReconnect {
+		return
+	}
+	if dir, err := c.CurrentDir(); err == nil {
+		c.cwd = dir
+	}
+}
+
 // CurrentDir issues a PWD FTP command, which Returns the path of the current
 // directory.
+// XPWD is sent instead to the servers which do not implement PWD. The path
+// is read between double quotes as defined by RFC 959, or is the first word
+// of the replies without quotes.
 func (c *ServerConn) CurrentDir() (string, error) {
 	_, msg, err := c.cmd(StatusPathCreated, "PWD")
+	if code := ReplyCode(err); code == StatusBadCommand || code == StatusNotImplemented {
+		_, msg, err = c.cmd(StatusPathCreated, "XPWD")
+	}
 	if err != nil {
 		return "", err
 	}
 
-	start := strings.Index(msg, "\"")
-	end := strings.LastIndex(msg, "\"")
+	return parsePWDReply(msg)
+}
 
-	if start == -1 || end == -1 {
-		return "", errors.New("unsuported PWD response format")
+// parsePWDReply returns the path of a 257 reply, in which the quotes of a
+// quoted path are doubled.
+func parsePWDReply(msg string) (string, error) {
+	msg = strings.TrimSpace(msg)
+	if !strings.HasPrefix(msg, "\"") {
+		fields := strings.Fields(msg)
+		if len(fields) == 0 {
+			return "", errors.New("unsuported PWD response format")
+		}
+		return fields[0], nil
 	}
 
-	return msg[start+1 : end], nil
+	var path strings.Builder
+	for i := 1; i < len(msg); i++ {
+		if msg[i] != '"' {
+			path.WriteByte(msg[i])
+			continue
+		}
+		if i+1 < len(msg) && msg[i+1] == '"' {
+			path.WriteByte('"')
+			i++
+			continue
+		}
+		return path.String(), nil
+	}
+	return "", errors.New("unsuported PWD response format")
 }
 
 // FileSize issues a SIZE FTP command, which Returns the size of the file
@@ -609,117 +1990,436 @@ func (c *ServerConn) FileSize(path string) (int64, error) {
 	return strconv.ParseInt(msg, 10, 64)
 }
 
+// GetTime issues a MDTM FTP command, which returns the modification time of
+// the file. MDTM is described in RFC 3659.
+func (c *ServerConn) GetTime(path string) (time.Time, error) {
+	_, msg, err := c.cmd(StatusFile, "MDTM %s", path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return parseMDTM(msg)
+}
+
+// parseMDTM parses a time-val as described in RFC 3659: always in UTC,
+// optionally with fractional seconds.
+func parseMDTM(value string) (time.Time, error) {
+	if i := strings.IndexByte(value, '.'); i == 14 {
+		return time.ParseInLocation("20060102150405.999999999", value, time.UTC)
+	}
+	return time.ParseInLocation("20060102150405", value, time.UTC)
+}
+
 // Retr issues a RETR FTP command to fetch the specified file from the remote
 // FTP server.
 //
 // The returned ReadCloser must be closed to cleanup the FTP data connection.
-func (c *ServerConn) Retr(path string) (Responser, error) {
-	return c.RetrFrom(path, 0)
+func (c *ServerConn) Retr(path string, options ...TransferOption) (Responser, error) {
+	return c.RetrFrom(path, 0, options...)
 }
 
 // RetrFrom issues a RETR FTP command to fetch the specified file from the remote
 // FTP server, the server will not send the offset first bytes of the file.
 //
 // The returned ReadCloser must be closed to cleanup the FTP data connection.
-func (c *ServerConn) RetrFrom(path string, offset uint64) (Responser, error) {
-	conn, err := c.cmdDataConnFrom(offset, "RETR %s", path)
+func (c *ServerConn) RetrFrom(path string, offset uint64, options ...TransferOption) (Responser, error) {
+	to := newTransferOptions(options)
+	if to.decompressors != nil && offset != 0 {
+		return nil, errDecompressOffset
+	}
+
+	r, err := c.retrFrom(path, offset, to)
 	if err != nil {
 		return nil, err
 	}
+	if to.decompressors != nil {
+		return newDecompressedResponse(r, to.decompressors), nil
+	}
+	return r, nil
+}
+
+// ExecData issues an arbitrary command transferring data from the server, such
+// as a vendor extension, over a new data connection.
+// The command is sent as is and must be accepted with a 125 or 150 reply.
+//
+// The returned Responser must be closed to read the final reply and cleanup
+// the FTP data connection.
+// The cancellation of ctx bounds the command, its deadline also bounds the
+// transfer.
+func (c *ServerConn) ExecData(ctx context.Context, command string) (Responser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer c.withContext(ctx)()
 
-	return &Response{conn: conn, c: c}, nil
+	conn, err := c.cmdDataConnFrom(0, "%s", command)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	return &Response{
+		conn:     conn,
+		c:        c,
+		path:     command,
+		start:    time.Now(),
+		expected: c.transferSize,
+	}, nil
+}
+
+// retrFrom issues a RETR FTP command.
+func (c *ServerConn) retrFrom(path string, offset uint64, to *transferOptions) (*Response, error) {
+	start := time.Now()
+
+	// The size of a transfer without TransferWithExpectedSize is unknown
+	size := int64(-1)
+	if to.expectedSize > 0 {
+		size = max(to.expectedSize-int64(offset), 0)
+	}
+	compress, _, _ := c.compress(path, size, nil)
+	conn, err := c.cmdDataConnMode(offset, compress, "RETR %s", path)
+	if err != nil {
+		return nil, err
+	}
+	conn = limitRate(conn, to.rateLimiters()...)
+	if compress {
+		conn = newCompressedConn(conn, c.options.compression.Level, false)
+	}
+
+	deadline := to.deadline(start)
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+
+	expected := c.transferSize
+	if to.expectedSize > 0 {
+		expected = to.expectedSize
+	}
+
+	return &Response{
+		conn:     conn,
+		c:        c,
+		path:     path,
+		offset:   offset,
+		check:    c.newIntegrityCheck(),
+		hashes:   to.hashWriter(),
+		stats:    to.stats,
+		start:    start,
+		to:       to,
+		deadline: deadline,
+		expected: expected,
+	}, nil
 }
 
 // Stor issues a STOR FTP command to store a file to the remote FTP server.
 // Stor creates the specified file with the content of the io.Reader.
+// An *os.File is sent with sendfile when the data connection is a plain TCP
+// connection and the data is neither hashed nor verified.
+// The number of bytes sent, even if the transfer failed, is reported by
+// TransferWithStats.
 //
 // Hint: io.Pipe() can be used if an io.Writer is required.
-func (c *ServerConn) Stor(path string, r io.Reader) (code int, err error) {
-	return c.StorFrom(path, r, 0)
+func (c *ServerConn) Stor(path string, r io.Reader, options ...TransferOption) (code int, err error) {
+	return c.StorFrom(path, r, 0, options...)
 }
 
 // StorFrom issues a STOR FTP command to store a file to the remote FTP server.
 // Stor creates the specified file with the content of the io.Reader, writing
 // on the server will start at the given file offset.
+// The offset is set with REST, or APPE is used if DialWithAppendResume is set.
 //
 // Hint: io.Pipe() can be used if an io.Writer is required.
-func (c *ServerConn) StorFrom(path string, r io.Reader, offset uint64) (code int, err error) {
-	conn, err := c.cmdDataConnFrom(offset, "STOR %s", path)
+//
+// If a retry policy is configured and r implements io.Seeker, a failed
+// transfer is retried as a whole after seeking r back to its initial position.
+func (c *ServerConn) StorFrom(path string, r io.Reader, offset uint64, options ...TransferOption) (code int, err error) {
+	to := newTransferOptions(options)
+
+	seeker, ok := r.(io.Seeker)
+	if !ok || c.options.retryPolicy == nil {
+		return c.storFrom(path, r, offset, to)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return c.storFrom(path, r, offset, to)
+	}
+
+	rewind := false
+	err = c.withRetry(true, func() error {
+		if rewind {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		rewind = true
+
+		code, err = c.storFrom(path, r, offset, to)
+		return err
+	})
+	return code, err
+}
+
+// storFrom performs a single STOR transfer.
+func (c *ServerConn) storFrom(path string, r io.Reader, offset uint64, to *transferOptions) (code int, err error) {
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
+	start := time.Now()
+	compress, r, err := c.compress(path, readerSize(r), r)
 	if err != nil {
 		return 0, err
 	}
-	_, err = io.Copy(conn, r)
-	conn.Close()
+	conn, err := c.storDataConn(path, offset, compress)
 	if err != nil {
 		return 0, err
 	}
+	conn = limitRate(conn, to.rateLimiters()...)
+	if compress {
+		conn = newCompressedConn(conn, c.options.compression.Level, true)
+	}
+
+	deadline := to.deadline(start)
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+
+	check := c.newIntegrityCheck()
+	if check != nil {
+		r = io.TeeReader(r, check)
+	}
 
-	code, _, err = c.conn.ReadResponse(StatusClosingDataConnection)
+	to.resetHashes()
+	if w := to.hashWriter(); w != nil {
+		r = io.TeeReader(r, w)
+	}
+
+	stop := func() {}
+	if to.ctx != nil {
+		ctx := to.ctx
+		if !deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		stop = watchContext(ctx, conn)
+	}
+	n, err := c.copyData(conn, r)
+	stop()
+	if to.stats != nil {
+		defer func() { *to.stats = newTransferStats(n, offset, start) }()
+	}
+	if err != nil {
+		if to.ctx != nil && contextError(to.ctx, err) != err {
+			// The transfer is aborted out of the canceled context, to keep
+			// the connection usable
+			err = contextError(to.ctx, err)
+			defer c.withContext(context.Background())()
+		} else {
+			err = to.timeoutError(path, deadline, err)
+		}
+		c.abortTransfer(conn, n, err)
+		return 0, err
+	}
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+	closeErr := conn.Close()
+
+	code, _, err = c.endTransfer(n)
+	if err == nil && compress {
+		// The end of the compressed stream may not have been sent
+		err = closeErr
+	}
+	if err == nil && check != nil {
+		err = c.verifyIntegrity(path, offset, check)
+	}
 	return code, err
 }
 
+// storDataConn issues the command storing the file at path from offset, and
+// returns its data connection.
+func (c *ServerConn) storDataConn(path string, offset uint64, compress bool) (net.Conn, error) {
+	if offset == 0 || !c.options.appendResume {
+		return c.cmdDataConnMode(offset, compress, "STOR %s", path)
+	}
+
+	// APPE writes at the end of the file, which must thus end at offset
+	if c.HasFeature("SIZE") {
+		size, err := c.FileSize(path)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(size) != offset {
+			return nil, fmt.Errorf("ftp: can not append to %s at offset %d, its size is %d", path, offset, size)
+		}
+	}
+	return c.cmdDataConnMode(0, compress, "APPE %s", path)
+}
+
 // Rename renames a file on the remote FTP server.
 // if code > 0 then it's not a connection/protocol error. It's a servere reply error like 553 file
 // already exists
 func (c *ServerConn) Rename(from, to string) (code int, err error) {
-	code, _, err = c.cmd(StatusRequestFilePending, "RNFR %s", from)
-	if err != nil {
-		return code, err
+	if c.readOnly {
+		return 0, ErrReadOnly
 	}
-	code, _, err = c.cmd(StatusRequestedFileActionOK, "RNTO %s", to)
+
+	// RNTO needs the preceding RNFR: both are sent again on retry
+	err = c.withRetry(true, func() error {
+		code, _, err = c.rawCmd(StatusRequestFilePending, "RNFR %s", from)
+		if err != nil {
+			return err
+		}
+		code, _, err = c.rawCmd(StatusRequestedFileActionOK, "RNTO %s", to)
+		return err
+	})
 	return code, err
 }
 
 // Delete issues a DELE FTP command to delete the specified file from the
 // remote FTP server.
 func (c *ServerConn) Delete(path string) (code int, err error) {
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
 	code, _, err = c.cmd(StatusRequestedFileActionOK, "DELE %s", path)
 	return code, err
 }
 
-// RemoveDirRecur deletes a non-empty folder recursively using
-// RemoveDir and Delete
-func (c *ServerConn) RemoveDirRecur(path string) (code int, err error) {
-	err = c.ChangeDir(path)
-	if err != nil {
-		return 0, err
+// RemoveOptions limits the deletions of RemoveDirRecurWithOptions.
+type RemoveOptions struct {
+	// MaxDepth is the maximum depth of the deleted directories below the
+	// root directory, which is at depth 0. Defaults to 64.
+	MaxDepth int
+
+	// MaxEntries is the maximum number of files and directories to delete.
+	// There is no limit if it is 0.
+	MaxEntries int
+}
+
+// defaultRemoveMaxDepth stops the recursion on cyclic trees.
+const defaultRemoveMaxDepth = 64
+
+// ErrRemoveLimit is matched by the errors of RemoveDirRecurWithOptions
+// reporting a directory beyond the limits set by RemoveOptions.
+var ErrRemoveLimit = errors.New("ftp: recursive deletion limit reached")
+
+// RemoveDirRecur deletes a non-empty folder recursively using RemoveDir and
+// Delete, without changing the current directory.
+// It is RemoveDirRecurWithOptions with the default options.
+func (c *ServerConn) RemoveDirRecur(dir string) (code int, err error) {
+	return c.RemoveDirRecurWithOptions(dir, nil)
+}
+
+// RemoveDirRecurWithOptions deletes a non-empty folder recursively using
+// RemoveDir and Delete, without changing the current directory.
+// Symbolic links are deleted, never followed. The deletion stops at the limits
+// of opts, to prevent runaway deletions on cyclic or enormous trees.
+// The deletion goes on after a failure, and the paths which could not be
+// deleted are reported with a *RemoveError.
+func (c *ServerConn) RemoveDirRecurWithOptions(dir string, opts *RemoveOptions) (code int, err error) {
+	if c.readOnly {
+		return 0, ErrReadOnly
 	}
-	currentDir, err := c.CurrentDir()
-	if err != nil {
-		return 0, err
+
+	if !strings.HasPrefix(dir, "/") {
+		cwd, err := c.CurrentDir()
+		if err != nil {
+			return 0, err
+		}
+		dir = path.Join(cwd, dir)
+	}
+
+	rm := &recursiveRemoval{maxDepth: defaultRemoveMaxDepth}
+	if opts != nil {
+		if opts.MaxDepth > 0 {
+			rm.maxDepth = opts.MaxDepth
+		}
+		rm.maxEntries = opts.MaxEntries
 	}
 
-	entries, err := c.List(currentDir)
+	code = c.removeDirRecur(dir, 0, rm)
+	if len(rm.Failures) > 0 {
+		return code, &rm.RemoveError
+	}
+	return code, nil
+}
+
+// recursiveRemoval is the state of RemoveDirRecurWithOptions.
+type recursiveRemoval struct {
+	RemoveError
+	maxDepth   int
+	maxEntries int
+	entries    int
+	stopped    bool // once maxEntries is exceeded
+}
+
+// limitReached counts an entry to delete, and reports whether the maximum
+// number of entries is exceeded.
+func (rm *recursiveRemoval) limitReached() bool {
+	rm.entries++
+	rm.stopped = rm.maxEntries > 0 && rm.entries > rm.maxEntries
+	return rm.stopped
+}
+
+// removeDirRecur deletes the content of dir, at the given depth, then dir
+// itself, recording the failures. It returns the code of the last reply.
+func (c *ServerConn) removeDirRecur(dir string, depth int, rm *recursiveRemoval) (code int) {
+	if depth > rm.maxDepth {
+		rm.add(dir, fmt.Errorf("%w: depth %d", ErrRemoveLimit, depth))
+		return 0
+	}
+
+	entries, err := c.List(dir)
 	if err != nil {
-		return 0, err
+		rm.add(dir, err)
+		return 0
 	}
 
+	failures := len(rm.Failures)
 	for _, entry := range entries {
-		if entry.Name != ".." && entry.Name != "." {
-			if entry.Type == EntryTypeFolder {
-				code, err = c.RemoveDirRecur(currentDir + "/" + entry.Name)
-				if err != nil {
-					return code, err
-				}
-			} else {
-				code, err = c.Delete(entry.Name)
-				if err != nil {
-					return code, err
-				}
-			}
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		if rm.stopped {
+			return code
+		}
+
+		p := path.Join(dir, entry.Name)
+		if rm.limitReached() {
+			rm.add(p, fmt.Errorf("%w: %d entries", ErrRemoveLimit, rm.maxEntries))
+			return code
+		}
+
+		// Links are leaves, even to directories
+		if entry.Type == EntryTypeFolder {
+			code = c.removeDirRecur(p, depth+1, rm)
+		} else if code, err = c.Delete(p); err != nil {
+			rm.add(p, err)
 		}
 	}
-	err = c.ChangeDirToParent()
-	if err != nil {
-		return 0, err
+
+	// A directory which is not empty can not be removed
+	if len(rm.Failures) > failures {
+		return code
 	}
-	code, err = c.RemoveDir(currentDir)
-	return code, err
+	if code, err = c.RemoveDir(dir); err != nil {
+		rm.add(dir, err)
+	}
+	return code
 }
 
 // MakeDir issues a MKD FTP command to create the specified directory on the
 // remote FTP server.
 func (c *ServerConn) MakeDir(path string) (code int, err error) {
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
 	code, _, err = c.cmd(StatusPathCreated, "MKD %s", path)
 	return code, err
 }
@@ -727,6 +2427,9 @@ func (c *ServerConn) MakeDir(path string) (code int, err error) {
 // RemoveDir issues a RMD FTP command to remove the specified directory from
 // the remote FTP server.
 func (c *ServerConn) RemoveDir(path string) (code int, err error) {
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
 	code, _, err = c.cmd(StatusRequestedFileActionOK, "RMD %s", path)
 	return code, err
 }
@@ -742,19 +2445,102 @@ func (c *ServerConn) NoOp() error {
 // Logout issues a REIN FTP command to logout the current user.
 func (c *ServerConn) Logout() error {
 	_, _, err := c.cmd(StatusReady, "REIN")
+	if err == nil {
+		c.loggedIn = false
+		c.readOnly = false
+		c.cwd = ""
+	}
 	return err
 }
 
+// LogoutContext is Logout bounded by the deadline and cancellation of ctx.
+func (c *ServerConn) LogoutContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer c.withContext(ctx)()
+
+	return c.Logout()
+}
+
 // Quit issues a QUIT FTP command to properly close the connection from the
 // remote FTP server.
+// It does not wait for the reply of the server, see QuitContext.
 func (c *ServerConn) Quit() error {
-	c.conn.Cmd("QUIT")
-	return c.conn.Close()
+	return c.QuitContext(context.Background())
+}
+
+// QuitContext issues a QUIT FTP command, waits for the reply of the server
+// and closes the connection.
+// The deadline and cancellation of ctx bound the wait and the close, after
+// which the connection is closed anyway and the error of ctx is returned.
+// The reply is not awaited if ctx can not end the wait, or if the connection
+// is known to be dead.
+func (c *ServerConn) QuitContext(ctx context.Context) error {
+	c.closed = true
+	if c.stopKeepalive != nil {
+		close(c.stopKeepalive)
+		c.stopKeepalive = nil
+	}
+
+	// A command in progress, such as a keepalive awaiting its reply, holds
+	// the lock: ctx ends it by closing the connection
+	stopClose := context.AfterFunc(ctx, func() {
+		c.netConn.Close()
+	})
+	defer stopClose()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stop := watchContext(ctx, c.netConn)
+	_, err := c.conn.Cmd("QUIT")
+	if err == nil && ctx.Done() != nil && c.closing == nil {
+		_, _, err = c.readReply(StatusClosing)
+	}
+	closeErr := c.conn.Close()
+	stop()
+
+	// The reply itself is best effort, only the interruption is reported
+	if err = contextError(ctx, err); err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	return closeErr
 }
 
 // Read implements the io.Reader interface on a FTP data connection.
 func (r *Response) Read(buf []byte) (int, error) {
-	return r.conn.Read(buf)
+	n, err := r.conn.Read(buf)
+	r.n += int64(n)
+	err = r.to.timeoutError(r.path, r.deadline, err)
+	if r.check != nil {
+		r.check.Write(buf[:n])
+	}
+	if r.hashes != nil {
+		r.hashes.Write(buf[:n])
+	}
+	if err == io.EOF {
+		if received := int64(r.offset) + r.n; r.expected > 0 && received < r.expected {
+			return n, &ShortTransferError{Path: r.path, Expected: r.expected, Received: received}
+		}
+		r.eof = true
+	}
+	return n, err
+}
+
+// ExpectedSize returns the size of the file, as announced by the server in its
+// reply to RETR, such as "150 Opening data connection (12345 bytes)", or as
+// set by TransferWithExpectedSize. It returns -1 if the size is unknown.
+// Once known, reading fails with a *ShortTransferError if the server closes the
+// data connection before the end of the file.
+func (r *Response) ExpectedSize() int64 {
+	return r.expected
+}
+
+// WriteTo implements the io.WriterTo interface on a FTP data connection, with
+// the buffer size set by DialWithCopyBufferSize.
+func (r *Response) WriteTo(w io.Writer) (int64, error) {
+	return r.c.copyData(w, struct{ io.Reader }{r})
 }
 
 // Close implements the io.Closer interface on a FTP data connection.
@@ -764,15 +2550,40 @@ func (r *Response) Close() error {
 		return nil
 	}
 	err := r.conn.Close()
-	_, _, err2 := r.c.conn.ReadResponse(StatusClosingDataConnection)
-	if err2 != nil {
+	done := r.c.boundTransferEnd()
+	_, _, err2 := r.c.endTransfer(r.n)
+	if err2 = done(err2); err2 != nil {
 		err = err2
 	}
 	r.closed = true
+	if r.stats != nil {
+		*r.stats = newTransferStats(r.n, r.offset, r.start)
+	}
+
+	// Only a transfer read until its end can be verified
+	if err == nil && r.check != nil && r.eof {
+		err = r.c.verifyIntegrity(r.path, r.offset, r.check)
+	}
 	return err
 }
 
+// Abort aborts the transfer with ABOR and closes the data connection, when the
+// data is not read until its end, leaving the ServerConn usable.
+// After the first call to Abort or Close, Abort will do nothing and return nil.
+func (r *Response) Abort() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	done := r.c.boundTransferEnd()
+	return done(r.c.abortTransfer(r.conn, r.n, errTransferAborted))
+}
+
 // SetDeadline sets the deadlines associated with the connection.
+// The deadline set by TransferWithMaxDuration cannot be extended.
 func (r *Response) SetDeadline(t time.Time) error {
+	if !r.deadline.IsZero() && (t.IsZero() || t.After(r.deadline)) {
+		t = r.deadline
+	}
 	return r.conn.SetDeadline(t)
 }
@@ -0,0 +1,123 @@
+// Package ftpio provides io interfaces on top of FTP connections.
+package ftpio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/textproto"
+	"sync"
+
+	"github.com/snus8bit/ftp"
+)
+
+// ReaderAt implements io.ReaderAt on a remote file.
+// Each ReadAt call retrieves the requested range with REST and RETR,
+// allowing random-access consumers such as archive/zip to work on remote
+// files without downloading them.
+//
+// ReadAt calls are serialized when backed by a single ServerConn and run in
+// parallel, up to the pool size, when backed by a Pool.
+type ReaderAt struct {
+	path    string
+	size    int64
+	get     func() (*ftp.ServerConn, error)
+	release func(*ftp.ServerConn, error)
+}
+
+// NewReaderAt returns a ReaderAt on the remote file path, using c for all
+// the reads. The size of the file is obtained with SIZE.
+func NewReaderAt(c *ftp.ServerConn, path string) (*ReaderAt, error) {
+	var mu sync.Mutex
+
+	return newReaderAt(path, func() (*ftp.ServerConn, error) {
+		mu.Lock()
+		return c, nil
+	}, func(*ftp.ServerConn, error) {
+		mu.Unlock()
+	})
+}
+
+// NewPoolReaderAt returns a ReaderAt on the remote file path, using
+// connections from p for the reads. The size of the file is obtained with
+// SIZE.
+func NewPoolReaderAt(p *ftp.Pool, path string) (*ReaderAt, error) {
+	return newReaderAt(path, func() (*ftp.ServerConn, error) {
+		return p.Get(context.Background())
+	}, p.Release)
+}
+
+func newReaderAt(path string, get func() (*ftp.ServerConn, error), release func(*ftp.ServerConn, error)) (*ReaderAt, error) {
+	r := &ReaderAt{
+		path:    path,
+		get:     get,
+		release: release,
+	}
+
+	c, err := get()
+	if err != nil {
+		return nil, err
+	}
+	r.size, err = c.FileSize(path)
+	release(c, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Size returns the size of the remote file.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("ftpio: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	want := p
+	if remaining := r.size - off; int64(len(want)) > remaining {
+		want = want[:remaining]
+	}
+
+	c, err := r.get()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := r.readRange(c, want, off)
+	r.release(c, err)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readRange fills buf with the content of the file starting at off.
+func (r *ReaderAt) readRange(c *ftp.ServerConn, buf []byte, off int64) (int, error) {
+	resp, err := c.RetrFrom(r.path, uint64(off))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(resp, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	// The transfer is usually interrupted before the end of the file, which
+	// the server reports with a 4xx reply: this is not an error.
+	cerr := resp.Close()
+	var protoErr *textproto.Error
+	if err == nil && cerr != nil && !errors.As(cerr, &protoErr) {
+		err = cerr
+	}
+
+	return n, err
+}
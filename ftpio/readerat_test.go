@@ -0,0 +1,183 @@
+package ftpio
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/snus8bit/ftp"
+)
+
+// serveFile starts a minimal FTP server serving data for any RETR
+func serveFile(t *testing.T, data []byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, data)
+		}
+	}()
+
+	return l
+}
+
+func serveConn(conn net.Conn, data []byte) {
+	defer conn.Close()
+
+	proto := textproto.NewConn(conn)
+	proto.PrintfLine("220 Ready")
+
+	var dataListener net.Listener
+	rest := 0
+	for {
+		line, err := proto.ReadLine()
+		if err != nil {
+			return
+		}
+		parts := strings.SplitN(line, " ", 2)
+
+		switch parts[0] {
+		case "FEAT":
+			proto.PrintfLine("211-Features:\r\n EPSV\r\n SIZE\r\n REST STREAM\r\n211 End")
+		case "USER":
+			proto.PrintfLine("331 Password")
+		case "PASS":
+			proto.PrintfLine("230 Logged in")
+		case "TYPE":
+			proto.PrintfLine("200 Type set")
+		case "SIZE":
+			proto.PrintfLine("213 %d", len(data))
+		case "REST":
+			rest, _ = strconv.Atoi(parts[1])
+			proto.PrintfLine("350 Restarting")
+		case "EPSV":
+			dataListener, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				proto.PrintfLine("425 %s", err)
+				break
+			}
+			port := dataListener.Addr().(*net.TCPAddr).Port
+			proto.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", port)
+		case "RETR":
+			dc, err := dataListener.Accept()
+			dataListener.Close()
+			if err != nil {
+				proto.PrintfLine("425 %s", err)
+				break
+			}
+			proto.PrintfLine("150 Sending")
+			_, err = dc.Write(data[rest:])
+			dc.Close()
+			rest = 0
+			if err != nil {
+				proto.PrintfLine("426 Transfer aborted")
+			} else {
+				proto.PrintfLine("226 Transfer complete")
+			}
+		case "QUIT":
+			proto.PrintfLine("221 Bye")
+			return
+		default:
+			proto.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func dial(t *testing.T, addr string) *ftp.ServerConn {
+	c, err := ftp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReaderAt(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	l := serveFile(t, data)
+	defer l.Close()
+
+	c := dial(t, l.Addr().String())
+	defer c.Quit()
+
+	r, err := NewReaderAt(c, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("size %d, expected %d", r.Size(), len(data))
+	}
+
+	buf := make([]byte, 5)
+	n, err := r.ReadAt(buf, 10)
+	if err != nil || string(buf[:n]) != "abcde" {
+		t.Errorf("read %q (%v), expected %q", buf[:n], err, "abcde")
+	}
+
+	n, err = r.ReadAt(buf, 18)
+	if err != io.EOF || string(buf[:n]) != "ij" {
+		t.Errorf("read %q (%v), expected %q and EOF", buf[:n], err, "ij")
+	}
+
+	_, err = r.ReadAt(buf, 20)
+	if err != io.EOF {
+		t.Error("expected EOF, got:", err)
+	}
+}
+
+func TestPoolReaderAtZip(t *testing.T) {
+	archive := &bytes.Buffer{}
+	zw := zip.NewWriter(archive)
+	w, err := zw.Create("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("Hello, World"))
+	zw.Close()
+
+	l := serveFile(t, archive.Bytes())
+	defer l.Close()
+
+	pool := ftp.NewPool(2, func() (*ftp.ServerConn, error) {
+		return dial(t, l.Addr().String()), nil
+	})
+	defer pool.Close()
+
+	r, err := NewPoolReaderAt(pool, "archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "hello.txt" {
+		t.Fatal("unexpected files:", zr.File)
+	}
+
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(content) != "Hello, World" {
+		t.Errorf("read %q (%v)", content, err)
+	}
+}
@@ -0,0 +1,399 @@
+// Package containers runs real FTP servers, such as vsftpd, ProFTPD and
+// Pure-FTPd, in Docker containers for interoperability tests.
+//
+// It talks to the Docker Engine API at DOCKER_HOST, or at the default Unix
+// socket, and only supports unix:// and tcp:// hosts. The ports of the
+// containers of a local daemon are published on 127.0.0.1. Those of a tcp://
+// daemon are published on all the interfaces of its host, and the servers
+// announce the IPv4 address of the host of DOCKER_HOST in their passive mode
+// replies.
+package containers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTimeout bounds the start of the containers by Run.
+const startTimeout = 2 * time.Minute
+
+// The passive mode ports of a container are published on the same ports of
+// the host, which are picked at random in [minPassivePort, maxPassivePort],
+// up to startAttempts times if they are in use.
+const (
+	minPassivePort = 20000
+	maxPassivePort = 29999
+	startAttempts  = 5
+)
+
+// The credentials of the user created in the containers
+const (
+	User     = "ftptest"
+	Password = "ftptest"
+)
+
+// Image describes the Docker image of an FTP server.
+type Image struct {
+	// Name is the name of the image, with its tag.
+	Name string
+
+	// Ports is the number of passive mode ports.
+	Ports int
+
+	// Env returns the environment of the container, creating the user,
+	// announcing host in the passive mode replies and using the passive mode
+	// ports from minPort to maxPort.
+	Env func(host string, minPort, maxPort int) []string
+}
+
+// The supported images
+var (
+	VSFTPD = Image{
+		Name:  "fauria/vsftpd:latest",
+		Ports: 11,
+		Env: func(host string, minPort, maxPort int) []string {
+			return []string{
+				"FTP_USER=" + User, "FTP_PASS=" + Password, "PASV_ADDRESS=" + host,
+				"PASV_MIN_PORT=" + strconv.Itoa(minPort), "PASV_MAX_PORT=" + strconv.Itoa(maxPort),
+			}
+		},
+	}
+	ProFTPD = Image{
+		Name:  "kibatic/proftpd:latest",
+		Ports: 11,
+		Env: func(host string, minPort, maxPort int) []string {
+			return []string{
+				"FTP_LIST=" + User + ":" + Password, "MASQUERADE_ADDRESS=" + host,
+				"PASSIVE_MIN_PORT=" + strconv.Itoa(minPort), "PASSIVE_MAX_PORT=" + strconv.Itoa(maxPort),
+			}
+		},
+	}
+	PureFTPd = Image{
+		Name:  "stilliard/pure-ftpd:latest",
+		Ports: 10,
+		Env: func(host string, minPort, maxPort int) []string {
+			return []string{
+				"FTP_USER_NAME=" + User, "FTP_USER_PASS=" + Password, "FTP_USER_HOME=/home/ftpusers/" + User,
+				"PUBLICHOST=" + host, fmt.Sprintf("FTP_PASSIVE_PORTS=%d:%d", minPort, maxPort),
+			}
+		},
+	}
+)
+
+// Server is an FTP server running in a container.
+type Server struct {
+	// Addr is the address of the control connection, as host:port.
+	Addr string
+
+	// User and Password are the credentials of the user of the server.
+	User, Password string
+
+	// ID is the ID of the container.
+	ID string
+
+	docker *client
+}
+
+// Run starts a container of image for the test, and removes it at the end of
+// the test. The test is skipped if Docker is not available.
+func Run(t testing.TB, image Image) *Server {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	docker, err := newClient()
+	if err == nil {
+		err = docker.do(ctx, http.MethodGet, "/_ping", nil, nil)
+	}
+	if err != nil {
+		t.Skipf("Docker is not available: %v", err)
+	}
+
+	s, err := start(ctx, docker, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return s
+}
+
+// Start starts a container of image, pulling the image if needed, and waits
+// for the server to greet its clients. The container must be removed with
+// Close.
+func Start(ctx context.Context, image Image) (*Server, error) {
+	docker, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	return start(ctx, docker, image)
+}
+
+func start(ctx context.Context, docker *client, image Image) (*Server, error) {
+	if err := docker.pull(ctx, image.Name); err != nil {
+		return nil, err
+	}
+	host, err := docker.passiveHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		minPort := minPassivePort + rand.Intn(maxPassivePort-minPassivePort+2-image.Ports)
+		id, err := docker.create(ctx, image, host, minPort)
+		if err != nil {
+			return nil, err
+		}
+		s := &Server{User: User, Password: Password, ID: id, docker: docker}
+
+		err = s.run(ctx)
+		if err == nil {
+			return s, nil
+		}
+		s.Close()
+		if !isPortInUse(err) || attempt == startAttempts {
+			return nil, err
+		}
+	}
+}
+
+// isPortInUse reports whether err is the failure of Docker to publish a port
+// already in use, such as by another container.
+func isPortInUse(err error) bool {
+	e, ok := err.(*Error)
+	return ok && (strings.Contains(e.Message, "port is already allocated") || strings.Contains(e.Message, "address already in use"))
+}
+
+// run starts the container, and waits for the server.
+func (s *Server) run(ctx context.Context) error {
+	if err := s.docker.do(ctx, http.MethodPost, "/containers/"+s.ID+"/start", nil, nil); err != nil {
+		return err
+	}
+
+	var info struct {
+		NetworkSettings struct {
+			Ports map[string][]portBinding
+		}
+	}
+	if err := s.docker.do(ctx, http.MethodGet, "/containers/"+s.ID+"/json", nil, &info); err != nil {
+		return err
+	}
+	bindings := info.NetworkSettings.Ports["21/tcp"]
+	if len(bindings) == 0 {
+		return fmt.Errorf("containers: port 21 of %s is not published", s.ID)
+	}
+	s.Addr = net.JoinHostPort(s.docker.host, bindings[0].HostPort)
+
+	return waitReady(ctx, s.Addr)
+}
+
+// waitReady waits for the server at addr to send its greeting. The port
+// published by Docker accepts connections before the server is started.
+func waitReady(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(time.Second))
+			_, _, err = textproto.NewConn(conn).ReadResponse(220)
+			conn.Close()
+			if err == nil {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("containers: server at %s not ready: %w", addr, err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// Close stops and removes the container.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.docker.do(ctx, http.MethodDelete, "/containers/"+s.ID+"?force=1&v=1", nil, nil)
+}
+
+// client is a client of the Docker Engine API.
+type client struct {
+	http   *http.Client
+	base   string
+	host   string // of the published ports
+	bindIP string // the host IP publishing the ports, empty for all
+}
+
+func newClient() (*client, error) {
+	dockerHost := os.Getenv("DOCKER_HOST")
+	if dockerHost == "" {
+		dockerHost = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return nil, fmt.Errorf("containers: invalid DOCKER_HOST: %w", err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &client{http: &http.Client{Transport: transport}, base: "http://docker", host: "127.0.0.1", bindIP: "127.0.0.1"}, nil
+	case "tcp":
+		return &client{http: &http.Client{}, base: "http://" + u.Host, host: u.Hostname()}, nil
+	}
+	return nil, fmt.Errorf("containers: unsupported DOCKER_HOST %q", dockerHost)
+}
+
+// do sends a request with in encoded in JSON, and decodes the response into
+// out if not nil.
+func (c *client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	resp, err := c.request(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *client) request(ctx context.Context, method, path string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("containers: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var e struct{ Message string }
+		json.NewDecoder(resp.Body).Decode(&e)
+		if e.Message == "" {
+			e.Message = resp.Status
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: fmt.Sprintf("%s %s: %s", method, path, e.Message)}
+	}
+	return resp, nil
+}
+
+// Error is an error returned by the Docker Engine API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return "containers: " + e.Message
+}
+
+// passiveHost returns the IPv4 address announced by the servers in their
+// passive mode replies: the one of the host of the published ports.
+func (c *client) passiveHost(ctx context.Context) (string, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", c.host)
+	if err != nil {
+		return "", fmt.Errorf("containers: resolve the Docker host: %w", err)
+	}
+	return ips[0].String(), nil
+}
+
+// pull pulls the image unless it is present.
+func (c *client) pull(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodGet, "/images/"+name+"/json", nil, nil)
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound {
+		return err
+	}
+
+	resp, err := c.request(ctx, http.MethodPost, "/images/create?fromImage="+url.QueryEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The progress is streamed, and so are the errors
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var progress struct{ Error string }
+		if json.Unmarshal(scanner.Bytes(), &progress) == nil && progress.Error != "" {
+			return fmt.Errorf("containers: pull %s: %s", name, progress.Error)
+		}
+	}
+	return scanner.Err()
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string
+}
+
+// create creates a container of image announcing host, publishing the FTP
+// port on a random port of the host and the passive mode ports, from minPort,
+// on the same ports.
+func (c *client) create(ctx context.Context, image Image, host string, minPort int) (string, error) {
+	maxPort := minPort + image.Ports - 1
+	exposed := map[string]struct{}{"21/tcp": {}}
+	bindings := map[string][]portBinding{"21/tcp": {{HostIP: c.bindIP}}}
+	for port := minPort; port <= maxPort; port++ {
+		name := strconv.Itoa(port) + "/tcp"
+		exposed[name] = struct{}{}
+		bindings[name] = []portBinding{{HostIP: c.bindIP, HostPort: strconv.Itoa(port)}}
+	}
+
+	config := map[string]interface{}{
+		"Image":        image.Name,
+		"Env":          image.Env(host, minPort, maxPort),
+		"ExposedPorts": exposed,
+		"Labels":       map[string]string{"ftptest": strings.SplitN(image.Name, ":", 2)[0]},
+		"HostConfig":   map[string]interface{}{"PortBindings": bindings},
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/create", config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
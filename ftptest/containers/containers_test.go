@@ -0,0 +1,161 @@
+package containers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/snus8bit/ftp"
+	"github.com/snus8bit/ftp/ftptest"
+)
+
+// newFakeDocker returns a fake Docker Engine API whose containers publish
+// the FTP port of s, and the list of the requests it received. The first
+// conflicts containers fail to start, their ports being in use.
+func newFakeDocker(t *testing.T, s *ftptest.Server, conflicts int) *[]string {
+	_, port, _ := net.SplitHostPort(s.Addr)
+	var requests []string
+
+	docker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /images/fauria/vsftpd:latest/json":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "no such image"})
+		case "POST /images/create":
+			w.Write([]byte(`{"status":"Pulling"}` + "\n"))
+		case "POST /containers/create":
+			var config struct {
+				Image      string
+				Env        []string
+				HostConfig struct {
+					PortBindings map[string][]portBinding
+				}
+			}
+			if err := json.NewDecoder(r.Body).Decode(&config); err != nil || config.Image != VSFTPD.Name {
+				t.Errorf("unexpected config %+v, %v", config, err)
+			}
+			checkPassivePorts(t, config.Env, config.HostConfig.PortBindings)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"c0ffee"}`))
+		case "POST /containers/c0ffee/start":
+			if conflicts > 0 {
+				conflicts--
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"message": "driver failed programming external connectivity: Bind for 0.0.0.0:21100 failed: port is already allocated"})
+				break
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "DELETE /containers/c0ffee":
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /containers/c0ffee/json":
+			w.Write([]byte(`{"NetworkSettings":{"Ports":{"21/tcp":[{"HostIp":"127.0.0.1","HostPort":"` + port + `"}]}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(docker.Close)
+
+	t.Setenv("DOCKER_HOST", "tcp://"+docker.Listener.Addr().String())
+	return &requests
+}
+
+// checkPassivePorts checks that the passive mode ports set in the environment
+// of a vsftpd container are published on the same ports, on all the
+// interfaces of a tcp:// Docker host, and that 127.0.0.1 is announced.
+func checkPassivePorts(t *testing.T, env []string, bindings map[string][]portBinding) {
+	t.Helper()
+
+	vars := make(map[string]string)
+	for _, v := range env {
+		name, value, _ := strings.Cut(v, "=")
+		vars[name] = value
+	}
+	if vars["PASV_ADDRESS"] != "127.0.0.1" {
+		t.Errorf("announced %q", vars["PASV_ADDRESS"])
+	}
+
+	minPort, _ := strconv.Atoi(vars["PASV_MIN_PORT"])
+	maxPort, _ := strconv.Atoi(vars["PASV_MAX_PORT"])
+	if minPort < minPassivePort || maxPort > maxPassivePort || maxPort-minPort+1 != VSFTPD.Ports {
+		t.Errorf("passive ports %d-%d", minPort, maxPort)
+	}
+	for port := minPort; port <= maxPort; port++ {
+		name := strconv.Itoa(port) + "/tcp"
+		if b := bindings[name]; len(b) != 1 || b[0].HostIP != "" || b[0].HostPort != strconv.Itoa(port) {
+			t.Errorf("%s published on %+v", name, b)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	requests := newFakeDocker(t, s, 0)
+
+	server, err := Start(context.Background(), VSFTPD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Addr != s.Addr || server.ID != "c0ffee" {
+		t.Errorf("unexpected server %+v", server)
+	}
+
+	c, err := ftp.Dial(server.Addr, ftp.DialWithLogin(server.User, server.Password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Quit()
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if last := (*requests)[len(*requests)-1]; last != "DELETE /containers/c0ffee" {
+		t.Errorf("the container was not removed, last request %q", last)
+	}
+}
+
+func TestStartPortInUse(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	requests := newFakeDocker(t, s, 1)
+
+	server, err := Start(context.Background(), VSFTPD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// The container is removed, and created again with other ports
+	expected := []string{
+		"GET /images/fauria/vsftpd:latest/json",
+		"POST /images/create",
+		"POST /containers/create",
+		"POST /containers/c0ffee/start",
+		"DELETE /containers/c0ffee",
+		"POST /containers/create",
+		"POST /containers/c0ffee/start",
+		"GET /containers/c0ffee/json",
+	}
+	if !reflect.DeepEqual(*requests, expected) {
+		t.Errorf("expected %v, got %v", expected, *requests)
+	}
+}
+
+func TestRunSkip(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "tcp://127.0.0.1:1")
+
+	skipped := t.Run("run", func(t *testing.T) {
+		Run(t, PureFTPd)
+		t.Error("not skipped without Docker")
+	})
+	if !skipped {
+		t.Error("the test failed")
+	}
+}
@@ -0,0 +1,212 @@
+package ftptest
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// memFS is the in-memory server.ChtimesFS of a Server. The names are the ones
+// of fs.FS: slash-separated, unrooted paths.
+type memFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS // the directories are listed, except the root
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: make(fstest.MapFS)}
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files.Open(name)
+}
+
+// OpenFile returns a writer of the named file, whose content is replaced when
+// the writer is closed.
+func (m *memFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkParent("openfile", name); err != nil {
+		return nil, err
+	}
+	f, ok := m.files[name]
+	if ok && f.Mode.IsDir() {
+		return nil, &fs.PathError{Op: "openfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	w := &memWriter{fs: m, name: name, perm: perm, appending: flag&os.O_APPEND != 0}
+	if ok && flag&os.O_TRUNC == 0 {
+		w.data = append([]byte(nil), f.Data...)
+	}
+	return w, nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[name]
+	switch {
+	case !ok:
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	case f.Mode.IsDir() && len(m.children(name)) > 0:
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	if _, ok := m.files[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	m.files[name] = &fstest.MapFile{Mode: fs.ModeDir | perm, ModTime: time.Now()}
+	return nil
+}
+
+// Rename moves a file, or a directory and its files.
+func (m *memFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[oldname]; !ok {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	}
+	if err := m.checkParent("rename", newname); err != nil {
+		return err
+	}
+	for name, f := range m.files {
+		if name == oldname || strings.HasPrefix(name, oldname+"/") {
+			delete(m.files, name)
+			m.files[newname+strings.TrimPrefix(name, oldname)] = f
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Chtimes(name string, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	changed := *f
+	changed.ModTime = mtime
+	m.files[name] = &changed
+	return nil
+}
+
+// writeFile creates or replaces the named file, and its missing parent
+// directories.
+func (m *memFS) writeFile(name string, data []byte, perm fs.FileMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mkdirAll(path.Dir(name))
+	m.files[name] = &fstest.MapFile{Data: data, Mode: perm, ModTime: time.Now()}
+}
+
+// readFile returns the content of the named file, and whether it exists.
+func (m *memFS) readFile(name string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[name]
+	if !ok || f.Mode.IsDir() {
+		return nil, false
+	}
+	return append([]byte(nil), f.Data...), true
+}
+
+// mkdirAll creates the named directory and its parents. m.mu must be held.
+func (m *memFS) mkdirAll(name string) {
+	for ; name != "."; name = path.Dir(name) {
+		if _, ok := m.files[name]; !ok {
+			m.files[name] = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: time.Now()}
+		}
+	}
+}
+
+// checkParent checks that the parent of the named file is a directory.
+// m.mu must be held.
+func (m *memFS) checkParent(op, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if dir := path.Dir(name); dir != "." {
+		if f, ok := m.files[dir]; !ok || !f.Mode.IsDir() {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	return nil
+}
+
+// children returns the names of the files of the named directory.
+// m.mu must be held.
+func (m *memFS) children(dir string) []string {
+	var names []string
+	for name := range m.files {
+		if path.Dir(name) == dir {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// memWriter writes a file of a memFS.
+type memWriter struct {
+	fs        *memFS
+	name      string
+	perm      fs.FileMode
+	appending bool
+	data      []byte
+	offset    int64
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.appending {
+		w.offset = int64(len(w.data))
+	}
+	if end := w.offset + int64(len(p)); end > int64(len(w.data)) {
+		w.data = append(w.data, make([]byte, end-int64(len(w.data)))...)
+	}
+	n := copy(w.data[w.offset:], p)
+	w.offset += int64(n)
+	return n, nil
+}
+
+// Seek moves the offset of the next write, to resume an upload.
+func (w *memWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += w.offset
+	case io.SeekEnd:
+		offset += int64(len(w.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: w.name, Err: fs.ErrInvalid}
+	}
+	w.offset = offset
+	return offset, nil
+}
+
+func (w *memWriter) Close() error {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.fs.files[w.name] = &fstest.MapFile{Data: w.data, Mode: w.perm, ModTime: time.Now()}
+	return nil
+}
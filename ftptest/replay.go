@@ -0,0 +1,416 @@
+package ftptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snus8bit/ftp"
+)
+
+// dataTimeout bounds the wait for the connections of the recorded and
+// replayed sessions.
+const dataTimeout = 5 * time.Second
+
+// A transcript is a text file with one event of the session per line:
+//
+//	< 220 Welcome        a reply line received from the server
+//	> USER anonymous     a command line sent by the client
+//	= 42                 a data transfer of 42 bytes
+//	= 14 SnVzdCBzb21l... the same, keeping the data encoded in base64
+//
+// The arguments of PASS and ACCT are recorded as "****".
+
+// Recorder records the transcript of a session with a real server.
+// The session must use passive data connections, without TLS: the
+// connections are recorded below the TLS layer.
+type Recorder struct {
+	// KeepData records the data of the transfers. Otherwise only their
+	// size is recorded, and the replayer sends filler bytes.
+	KeepData bool
+
+	mu      sync.Mutex
+	lines   []string
+	control bool // the control connection was dialed
+}
+
+// Dial connects to the server at addr as ftp.Dial does, recording the
+// session. The connections are made by the recorder, overriding the
+// DialWithDialFunc, DialWithDialer and DialWithNetConn options.
+func (r *Recorder) Dial(addr string, options ...ftp.DialOption) (*ftp.ServerConn, error) {
+	return ftp.Dial(addr, append(options, ftp.DialWithDialFunc(r.dial))...)
+}
+
+// WriteTo writes the transcript recorded so far to w.
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for _, line := range r.lines {
+		m, err := io.WriteString(w, line+"\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (r *Recorder) record(line string) {
+	r.mu.Lock()
+	r.lines = append(r.lines, line)
+	r.mu.Unlock()
+}
+
+// dial makes the control connection, then the data connections.
+func (r *Recorder) dial(network, address string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, address, dataTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	control := !r.control
+	r.control = true
+	r.mu.Unlock()
+
+	if control {
+		return &controlRecorder{Conn: conn, r: r}, nil
+	}
+	return &dataRecorder{Conn: conn, r: r}, nil
+}
+
+// controlRecorder records the lines exchanged on the control connection.
+type controlRecorder struct {
+	net.Conn
+	r        *Recorder
+	commands []byte // incomplete lines
+	replies  []byte
+}
+
+func (c *controlRecorder) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.replies = c.recordLines(c.replies, p[:n], "<")
+	return n, err
+}
+
+func (c *controlRecorder) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.commands = c.recordLines(c.commands, p[:n], ">")
+	return n, err
+}
+
+// recordLines appends p to buf, and records its complete lines.
+func (c *controlRecorder) recordLines(buf, p []byte, direction string) []byte {
+	buf = append(buf, p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return buf
+		}
+
+		line := strings.TrimRight(string(buf[:i]), "\r")
+		buf = buf[i+1:]
+		if direction == ">" {
+			line = redactCommand(line)
+		}
+		c.r.record(direction + " " + line)
+	}
+}
+
+// redactCommand hides the argument of the secret commands.
+func redactCommand(line string) string {
+	verb, _, _ := strings.Cut(line, " ")
+	switch strings.ToUpper(verb) {
+	case "PASS", "ACCT":
+		return verb + " ****"
+	}
+	return line
+}
+
+// dataRecorder records the size, and the data if kept, of a data transfer.
+type dataRecorder struct {
+	net.Conn
+	r    *Recorder
+	n    int
+	data []byte
+	once sync.Once
+}
+
+func (c *dataRecorder) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.count(p[:n])
+	return n, err
+}
+
+func (c *dataRecorder) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.count(p[:n])
+	return n, err
+}
+
+func (c *dataRecorder) count(p []byte) {
+	c.n += len(p)
+	if c.r.KeepData {
+		c.data = append(c.data, p...)
+	}
+}
+
+func (c *dataRecorder) Close() error {
+	c.once.Do(func() {
+		line := "= " + strconv.Itoa(c.n)
+		if c.r.KeepData && c.n > 0 {
+			line += " " + base64.StdEncoding.EncodeToString(c.data)
+		}
+		c.r.record(line)
+	})
+	return c.Conn.Close()
+}
+
+// event is a line of a transcript.
+type event struct {
+	kind byte // '<', '>' or '='
+	line string
+	size int
+	data []byte // nil if not kept
+}
+
+// Replayer serves a recorded transcript to each client connecting to it,
+// checking that the client sends the recorded commands.
+// The passive mode replies are rewritten with the address of the replayer.
+type Replayer struct {
+	// Addr is the address of the replayer, as host:port.
+	Addr string
+
+	listener net.Listener
+	events   []event
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	err    error
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewReplayer parses the transcript and starts replaying it on the loopback
+// interface. It must be closed with Close.
+func NewReplayer(transcript io.Reader) (*Replayer, error) {
+	events, err := parseTranscript(transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replayer{
+		Addr:     l.Addr().String(),
+		listener: l,
+		events:   events,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	r.wg.Add(1)
+	go r.serve()
+	return r, nil
+}
+
+func parseTranscript(transcript io.Reader) ([]event, error) {
+	var events []event
+	scanner := bufio.NewScanner(transcript)
+	scanner.Buffer(nil, 64<<20)
+	for i := 1; scanner.Scan(); i++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[1] != ' ' {
+			return nil, fmt.Errorf("ftptest: transcript line %d: invalid event %q", i, line)
+		}
+
+		e := event{kind: line[0], line: line[2:]}
+		switch e.kind {
+		case '<', '>':
+		case '=':
+			size, data, _ := strings.Cut(e.line, " ")
+			var err error
+			if e.size, err = strconv.Atoi(size); err != nil {
+				return nil, fmt.Errorf("ftptest: transcript line %d: invalid size %q", i, size)
+			}
+			if data != "" {
+				if e.data, err = base64.StdEncoding.DecodeString(data); err != nil {
+					return nil, fmt.Errorf("ftptest: transcript line %d: %w", i, err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("ftptest: transcript line %d: invalid event %q", i, line)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// Close stops the replayer and closes the sessions, then waits for them to
+// end.
+func (r *Replayer) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.listener.Close()
+	for conn := range r.conns {
+		conn.Close()
+	}
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+// Err returns the first difference between the commands sent by the clients
+// and the transcript, or nil.
+func (r *Replayer) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Replayer) fail(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+}
+
+func (r *Replayer) serve() {
+	defer r.wg.Done()
+
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			conn.Close()
+			return
+		}
+		r.conns[conn] = struct{}{}
+		r.wg.Add(1)
+		r.mu.Unlock()
+
+		go func() {
+			defer r.wg.Done()
+			r.replay(conn)
+
+			r.mu.Lock()
+			delete(r.conns, conn)
+			r.mu.Unlock()
+		}()
+	}
+}
+
+// The addresses of the passive mode replies
+var (
+	pasvAddrRegexp = regexp.MustCompile(`\d+,\d+,\d+,\d+,\d+,\d+`)
+	epsvAddrRegexp = regexp.MustCompile(`\(\|\|\|\d+\|\)`)
+)
+
+// replay replays the transcript on the control connection conn.
+func (r *Replayer) replay(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	var data net.Listener
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+	var verb string
+
+	for _, e := range r.events {
+		switch e.kind {
+		case '<':
+			line := e.line
+			passive := strings.HasPrefix(line, "227 ") || strings.HasPrefix(line, "229 ")
+			if passive {
+				if data != nil {
+					data.Close()
+				}
+				var err error
+				if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+					r.fail(err)
+					return
+				}
+				port := data.Addr().(*net.TCPAddr).Port
+				line = pasvAddrRegexp.ReplaceAllLiteralString(line, fmt.Sprintf("127,0,0,1,%d,%d", port>>8, port&0xff))
+				line = epsvAddrRegexp.ReplaceAllLiteralString(line, fmt.Sprintf("(|||%d|)", port))
+			}
+			if err := text.PrintfLine("%s", line); err != nil {
+				return
+			}
+
+		case '>':
+			line, err := text.ReadLine()
+			if err != nil {
+				r.fail(fmt.Errorf("ftptest: replay expected %q: %w", e.line, err))
+				return
+			}
+			if redactCommand(line) != e.line {
+				r.fail(fmt.Errorf("ftptest: replay expected %q, got %q", e.line, line))
+				text.PrintfLine("500 Unexpected command, expected %s", e.line)
+				return
+			}
+			verb, _, _ = strings.Cut(strings.ToUpper(line), " ")
+
+		case '=':
+			if data == nil {
+				r.fail(errors.New("ftptest: replay transfer without passive mode"))
+				return
+			}
+			if err := r.transfer(data, verb, e); err != nil {
+				r.fail(fmt.Errorf("ftptest: replay %s transfer: %w", verb, err))
+				return
+			}
+			data.Close()
+			data = nil
+		}
+	}
+}
+
+// transfer accepts the data connection, and sends or receives the data of
+// the event.
+func (r *Replayer) transfer(l net.Listener, verb string, e event) error {
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(dataTimeout))
+	conn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch verb {
+	case "STOR", "APPE", "STOU":
+		_, err = io.Copy(io.Discard, conn)
+	default:
+		payload := e.data
+		if payload == nil {
+			payload = bytes.Repeat([]byte{'x'}, e.size)
+		}
+		_, err = conn.Write(payload)
+	}
+	return err
+}
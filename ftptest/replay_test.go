@@ -0,0 +1,129 @@
+package ftptest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/snus8bit/ftp"
+)
+
+// runSession runs the same commands against the recorded and the replayed
+// servers.
+func runSession(t *testing.T, c *ftp.ServerConn) ([]string, string) {
+	if err := c.Login("user", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := c.NameList("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Retr("readme.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Stor("upload.txt", bytes.NewBufferString("world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	return names, string(data)
+}
+
+func TestRecordReplay(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFile("/readme.txt", []byte("hello"))
+
+	recorder := &Recorder{KeepData: true}
+	c, err := recorder.Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, data := runSession(t, c)
+
+	var transcript bytes.Buffer
+	if _, err := recorder.WriteTo(&transcript); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(transcript.String(), "> PASS ****\n") {
+		t.Errorf("the password was recorded:\n%s", transcript.String())
+	}
+	s.Close()
+
+	replayer, err := NewReplayer(&transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+
+	c, err = ftp.Dial(replayer.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayedNames, replayedData := runSession(t, c)
+	if !reflect.DeepEqual(replayedNames, names) || replayedData != data {
+		t.Errorf("replayed %v %q, recorded %v %q", replayedNames, replayedData, names, data)
+	}
+	if err := replayer.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	transcript := `< 220 Quirky server
+> FEAT
+< 211-Features:
+<  SIZE
+< 211 End
+> USER anonymous
+< 331 Password please
+> PASS ****
+< 230 Logged in
+> TYPE I
+< 200 Type set to I
+> SIZE file
+< 213 = 42 bytes
+> QUIT
+< 221 Bye
+`
+	replayer, err := NewReplayer(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+
+	c, err := ftp.Dial(replayer.Addr, ftp.DialWithLogin("anonymous", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.FileSize("other"); ftp.ReplyCode(err) != ftp.StatusBadCommand {
+		t.Errorf("expected a 500 reply, got %v", err)
+	}
+	c.Quit()
+
+	if err := replayer.Err(); err == nil || !strings.Contains(err.Error(), `expected "SIZE file", got "SIZE other"`) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseTranscriptInvalid(t *testing.T) {
+	for _, transcript := range []string{"220 Missing direction\n", "= many\n", "? 220\n"} {
+		if _, err := NewReplayer(strings.NewReader(transcript)); err == nil {
+			t.Errorf("no error parsing %q", transcript)
+		}
+	}
+}
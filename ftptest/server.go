@@ -0,0 +1,247 @@
+// Package ftptest provides an in-memory FTP server for testing FTP clients.
+package ftptest
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snus8bit/ftp/server"
+)
+
+// Server is an FTP server storing its files in memory, listening on the
+// loopback interface. It runs the engine of the server package, with scripted
+// faults.
+// Its methods are safe to be called concurrently with the sessions.
+type Server struct {
+	// Addr is the address of the server, as host:port.
+	Addr string
+
+	srv  *server.Server
+	fs   *memFS
+	done chan struct{}
+
+	mu          sync.Mutex
+	features    []string
+	setFeatures bool              // features replace the ones of the engine
+	users       map[string]string // nil accepts any user
+	listings    map[string]string
+	nameLists   map[string][]string
+	faults      map[string][]Fault
+	commands    []string
+}
+
+// NewServer starts a server with an empty root directory, accepting any user.
+// It panics if it can not listen, and must be closed with Close.
+func NewServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		Addr:      l.Addr().String(),
+		fs:        newMemFS(),
+		done:      make(chan struct{}),
+		listings:  make(map[string]string),
+		nameLists: make(map[string][]string),
+		faults:    make(map[string][]Fault),
+	}
+	s.srv = &server.Server{FS: s.fs, Auth: s.auth, Hook: s.hook}
+
+	go func() {
+		defer close(s.done)
+		s.srv.Serve(l)
+	}()
+	return s
+}
+
+// Close stops the server and closes the sessions, then waits for them to end.
+func (s *Server) Close() {
+	s.srv.Close()
+	<-s.done
+}
+
+// SetFeatures sets the features listed by FEAT, such as "MLST type*;size*;".
+// FEAT is refused if there is none.
+func (s *Server) SetFeatures(features ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.features = features
+	s.setFeatures = true
+}
+
+// AddUser restricts the logins to the users added, with their password.
+func (s *Server) AddUser(user, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.users == nil {
+		s.users = make(map[string]string)
+	}
+	s.users[user] = password
+}
+
+// WriteFile creates or replaces the file at the absolute path p, and its
+// missing parent directories.
+func (s *Server) WriteFile(p string, data []byte) {
+	s.fs.writeFile(fsName(p), append([]byte(nil), data...), 0644)
+}
+
+// Mkdir creates the directory at the absolute path p, and its missing parent
+// directories.
+func (s *Server) Mkdir(p string) {
+	s.fs.mu.Lock()
+	defer s.fs.mu.Unlock()
+	s.fs.mkdirAll(fsName(p))
+}
+
+// ReadFile returns the content of the file at the absolute path p, and
+// whether it exists.
+func (s *Server) ReadFile(p string) ([]byte, bool) {
+	return s.fs.readFile(fsName(p))
+}
+
+// SetModTime sets the modification time of the file at the absolute path p.
+func (s *Server) SetModTime(p string, t time.Time) {
+	s.fs.Chtimes(fsName(p), t)
+}
+
+// SetListing sets the data sent by LIST for the directory at the absolute
+// path dir, instead of the listing of its files, to test the parsing of the
+// formats of other servers.
+func (s *Server) SetListing(dir, listing string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listings[path.Clean("/"+dir)] = listing
+}
+
+// SetNameList sets the names sent by NLST for the directory at the absolute
+// path dir, instead of the names of its files, such as the paths sent by some
+// servers.
+func (s *Server) SetNameList(dir string, names ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nameLists[path.Clean("/"+dir)] = names
+}
+
+// Fault is a scripted misbehavior of the server for a command.
+type Fault struct {
+	// Delay postpones the reply.
+	Delay time.Duration
+
+	// Reply, if not empty, is sent instead of the normal reply, such as
+	// "451 Local error" or a nonstandard code. After a 421 reply, the session
+	// is closed.
+	Reply string
+
+	// DropData closes the data connection after DataBytes bytes were sent or
+	// received, and fails the transfer with a 426 reply.
+	DropData bool
+
+	// TruncateData closes the data connection after DataBytes bytes were sent
+	// or received, but completes the transfer with a 226 reply, as servers
+	// truncating their listings.
+	TruncateData bool
+
+	// DataBytes is the length of the data transferred before DropData or
+	// TruncateData apply.
+	DataBytes int64
+}
+
+// Script sets the faults applied, in order, to the next commands verb, such
+// as "RETR". The commands after the last fault are executed normally.
+func (s *Server) Script(verb string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[strings.ToUpper(verb)] = faults
+}
+
+// SetReplies sets replies sent, in order, instead of the normal replies to
+// the next commands verb, such as "RETR". This injects failures, such as
+// "451 Local error" or "421 Service not available", after which the session
+// is closed.
+func (s *Server) SetReplies(verb string, replies ...string) {
+	faults := make([]Fault, len(replies))
+	for i, reply := range replies {
+		faults[i] = Fault{Reply: reply}
+	}
+	s.Script(verb, faults...)
+}
+
+// Commands returns the verbs of the commands received so far, by all the
+// sessions.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *Server) auth(user, password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expected, ok := s.users[user]
+	return s.users == nil || (ok && expected == password)
+}
+
+// hook records the commands, and turns the next scripted fault of each
+// command into the action of the engine.
+func (s *Server) hook(cmd server.Command) server.Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands = append(s.commands, cmd.Verb)
+	var fault Fault
+	if faults := s.faults[cmd.Verb]; len(faults) > 0 {
+		fault = faults[0]
+		s.faults[cmd.Verb] = faults[1:]
+	}
+	action := server.Action{
+		Delay:        fault.Delay,
+		Reply:        fault.Reply,
+		DropData:     fault.DropData,
+		TruncateData: fault.TruncateData,
+		DataBytes:    fault.DataBytes,
+	}
+
+	switch {
+	case action.Reply != "":
+	case cmd.Verb == "FEAT" && s.setFeatures:
+		action.Reply = s.featReply()
+	case cmd.Verb == "LIST":
+		if listing, ok := s.listings[cmd.Path]; ok {
+			action.Listing = []byte(listing)
+		}
+	case cmd.Verb == "NLST":
+		if names, ok := s.nameLists[cmd.Path]; ok {
+			action.Listing = []byte(strings.Join(names, "\r\n") + "\r\n")
+		}
+	}
+	return action
+}
+
+// featReply returns the reply to FEAT listing the features set by
+// SetFeatures. s.mu must be held.
+func (s *Server) featReply() string {
+	if len(s.features) == 0 {
+		return "502 FEAT not implemented"
+	}
+	var b strings.Builder
+	b.WriteString("211-Features:\r\n")
+	for _, feature := range s.features {
+		b.WriteString(" " + feature + "\r\n")
+	}
+	b.WriteString("211 End")
+	return b.String()
+}
+
+// fsName returns the name in the file system of the absolute path p.
+func fsName(p string) string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
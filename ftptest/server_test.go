@@ -0,0 +1,232 @@
+package ftptest
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snus8bit/ftp"
+)
+
+func dial(t *testing.T, s *Server) *ftp.ServerConn {
+	c, err := ftp.Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("user", "password"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestServerFiles(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFile("/pub/readme.txt", []byte("hello"))
+
+	c := dial(t, s)
+	defer c.Quit()
+
+	r, err := c.Retr("/pub/readme.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("read %q", data)
+	}
+
+	if err := c.ChangeDir("/pub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Stor("upload.txt", bytes.NewBufferString("world")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.ReadFile("/pub/upload.txt"); !ok || string(data) != "world" {
+		t.Errorf("stored %q, %v", data, ok)
+	}
+
+	entries, err := c.List(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if expected := []string{"readme.txt", "upload.txt"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("listed %v, expected %v", names, expected)
+	}
+
+	if _, err := c.Rename("upload.txt", "renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := c.FileSize("renamed.txt"); err != nil || size != 5 {
+		t.Errorf("size %d, %v", size, err)
+	}
+	if _, err := c.Delete("renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.FileSize("renamed.txt"); !errors.Is(err, ftp.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestServerUsers(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddUser("user", "secret")
+
+	c, err := ftp.Dial(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	if err := c.Login("user", "wrong"); !errors.Is(err, ftp.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn, got %v", err)
+	}
+	if err := c.Login("user", "secret"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerListing(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetFeatures("EPSV")
+	s.SetListing("/", "-rw-r--r--   1 ftp      wheel          14 Jan 29 10:29 canned\r\n")
+
+	c := dial(t, s)
+	defer c.Quit()
+
+	entries, err := c.List("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "canned" || entries[0].Size != 14 {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
+
+func TestServerNameList(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Mkdir("/incoming")
+	s.SetNameList("/", "/incoming")
+
+	c := dial(t, s)
+	defer c.Quit()
+
+	names, err := c.NameList("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"/incoming"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+}
+
+func TestServerReplies(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFile("file", []byte("data"))
+	s.SetReplies("SIZE", "451 Local error")
+
+	c := dial(t, s)
+	defer c.Quit()
+
+	if _, err := c.FileSize("file"); ftp.ReplyCode(err) != 451 {
+		t.Errorf("expected a 451 reply, got %v", err)
+	}
+	// The next commands are replied normally
+	if size, err := c.FileSize("file"); err != nil || size != 4 {
+		t.Errorf("size %d, %v", size, err)
+	}
+
+	commands := s.Commands()
+	if last := commands[len(commands)-2:]; !reflect.DeepEqual(last, []string{"SIZE", "SIZE"}) {
+		t.Errorf("unexpected commands %v", commands)
+	}
+}
+
+func TestServerDropData(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFile("file", []byte("some data"))
+	s.Script("RETR", Fault{DropData: true, DataBytes: 4})
+
+	c := dial(t, s)
+	defer c.Quit()
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "some" {
+		t.Errorf("read %q", data)
+	}
+	if err := r.Close(); ftp.ReplyCode(err) != 426 {
+		t.Errorf("expected a 426 reply, got %v", err)
+	}
+
+	// The next transfer completes
+	r, err = c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(r)
+	if err := r.Close(); err != nil || string(data) != "some data" {
+		t.Errorf("read %q, %v", data, err)
+	}
+}
+
+func TestServerTruncateData(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFile("a", nil)
+	s.WriteFile("b", nil)
+	s.Script("NLST", Fault{TruncateData: true, DataBytes: 3})
+
+	c := dial(t, s)
+	defer c.Quit()
+
+	names, err := c.NameList("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("listed %v, expected %v", names, expected)
+	}
+}
+
+func TestServerDelay(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Script("NOOP", Fault{Delay: 50 * time.Millisecond}, Fault{Reply: "299 Unusual but fine"})
+
+	c := dial(t, s)
+	defer c.Quit()
+
+	start := time.Now()
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("the reply was not delayed, took %s", elapsed)
+	}
+
+	if err := c.NoOp(); ftp.ReplyCode(err) != 299 {
+		t.Errorf("expected a 299 reply, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package ftp
+
+import "time"
+
+// CommandHook is called around each command sent on the control connection,
+// except for the keepalive NOOPs, for instance to audit the session or to
+// rewrite commands.
+type CommandHook interface {
+	// BeforeCommand is called with the command line before it is sent.
+	// It returns the line to send instead, or an error aborting the command
+	// before anything is sent.
+	// Note that the line of the PASS command contains the password.
+	BeforeCommand(line string) (string, error)
+
+	// AfterReply is called with the reply to the command line, or the error
+	// which prevented it from being read, and the duration of the exchange.
+	// It returns the error reported to the caller, usually err itself.
+	// Returning an error selected by the RetryPolicy makes the command retried.
+	AfterReply(line string, code int, message string, elapsed time.Duration, err error) error
+}
+
+// DialWithCommandHook returns a DialOption that configures the ServerConn to
+// call hook around each command.
+// It can be given several times: the hooks are called in order.
+func DialWithCommandHook(hook CommandHook) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.commandHooks = append(do.commandHooks, hook)
+	}}
+}
+
+// beforeCommand passes line through the BeforeCommand hooks.
+func (c *ServerConn) beforeCommand(line string) (string, error) {
+	for _, hook := range c.options.commandHooks {
+		var err error
+		if line, err = hook.BeforeCommand(line); err != nil {
+			return "", err
+		}
+	}
+	return line, nil
+}
+
+// afterReply passes the reply through the AfterReply hooks.
+func (c *ServerConn) afterReply(line string, code int, message string, elapsed time.Duration, err error) error {
+	for _, hook := range c.options.commandHooks {
+		err = hook.AfterReply(line, code, message, elapsed, err)
+	}
+	return err
+}
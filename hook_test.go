@@ -0,0 +1,55 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errForbidden = errors.New("forbidden command")
+
+// testHook forbids RMD, renames "old" to "new" and records the replies
+type testHook struct {
+	replies []string
+}
+
+func (h *testHook) BeforeCommand(line string) (string, error) {
+	if strings.HasPrefix(line, "RMD ") {
+		return "", errForbidden
+	}
+	return strings.Replace(line, "old", "new", 1), nil
+}
+
+func (h *testHook) AfterReply(line string, code int, message string, elapsed time.Duration, err error) error {
+	if elapsed <= 0 {
+		return errors.New("expected a positive duration")
+	}
+	h.replies = append(h.replies, fmt.Sprintf("%s: %d", redactCommand(line), code))
+	return err
+}
+
+func TestCommandHook(t *testing.T) {
+	hook := &testHook{}
+	mock, c := openConn(t, "127.0.0.1", DialWithCommandHook(hook))
+
+	if _, err := c.Delete("old"); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.RemoveDir(testDir); err != errForbidden {
+		t.Errorf("expected errForbidden, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"DELE"})
+
+	if mock.lines[4] != "DELE new" {
+		t.Errorf("expected the rewritten command, got %q", mock.lines[4])
+	}
+
+	expected := []string{"FEAT: 211", "USER anonymous: 331", "PASS ****: 230", "TYPE I: 200", "DELE new: 250"}
+	if !reflect.DeepEqual(hook.replies, expected) {
+		t.Errorf("expected %v, got %v", expected, hook.replies)
+	}
+}
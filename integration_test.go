@@ -0,0 +1,386 @@
+// The tests of this file run the client against the in-memory server of
+// ftptest. They are in the external test package: ftptest imports ftp, so the
+// internal tests can not import it, and use the protocol-level mock of
+// conn_test.go instead.
+
+package ftp_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snus8bit/ftp"
+	"github.com/snus8bit/ftp/ftptest"
+)
+
+const (
+	testData = "Just some text"
+	testDir  = "mydir"
+)
+
+// dialServer starts a server, and returns a client logged in as anonymous.
+func dialServer(t *testing.T, options ...ftp.DialOption) (*ftptest.Server, *ftp.ServerConn) {
+	s, c := openConn(t, options...)
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+// openConn starts a server with the files of the mock of the internal tests,
+// and returns a client connected to it, not logged in yet.
+func openConn(t *testing.T, options ...ftp.DialOption) (*ftptest.Server, *ftp.ServerConn) {
+	s := newServer(t)
+	c, err := ftp.Dial(s.Addr, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+// newServer starts a server with the files of the mock of the internal tests.
+// As the mock, it lists the root directory with paths.
+func newServer(t *testing.T) *ftptest.Server {
+	s := ftptest.NewServer()
+	t.Cleanup(s.Close)
+	s.WriteFile("/incoming/magic-file", make([]byte, 42))
+	s.SetNameList("/", "/incoming")
+	return s
+}
+
+func TestConnPASV(t *testing.T) {
+	testConn(t, true)
+}
+
+func TestConnEPSV(t *testing.T) {
+	testConn(t, false)
+}
+
+func testConn(t *testing.T, disableEPSV bool) {
+
+	_, c := openConn(t, ftp.DialWithTimeout(5*time.Second), ftp.DialWithDisabledEPSV(disableEPSV))
+
+	err := c.Login("anonymous", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.NoOp()
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = c.ChangeDir("incoming")
+	if err != nil {
+		t.Error(err)
+	}
+
+	dir, err := c.CurrentDir()
+	if err != nil {
+		t.Error(err)
+	} else {
+		if dir != "/incoming" {
+			t.Error("Wrong dir: " + dir)
+		}
+	}
+
+	data := bytes.NewBufferString(testData)
+	_, err = c.Stor("test", data)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = c.List(".")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = c.Rename("test", "tset")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Read without deadline
+	r, err := c.Retr("tset")
+	if err != nil {
+		t.Error(err)
+	} else {
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(buf) != testData {
+			t.Errorf("'%s'", buf)
+		}
+		r.Close()
+		r.Close() // test we can close two times
+	}
+
+	// Read with deadline
+	r, err = c.Retr("tset")
+	if err != nil {
+		t.Error(err)
+	} else {
+		r.SetDeadline(time.Now())
+		_, err := ioutil.ReadAll(r)
+		if err == nil {
+			t.Error("deadline should have caused error")
+		} else if !strings.HasSuffix(err.Error(), "i/o timeout") {
+			t.Error(err)
+		}
+		r.Close()
+	}
+
+	// Read with offset
+	r, err = c.RetrFrom("tset", 5)
+	if err != nil {
+		t.Error(err)
+	} else {
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Error(err)
+		}
+		expected := testData[5:]
+		if string(buf) != expected {
+			t.Errorf("read %q, expected %q", buf, expected)
+		}
+		r.Close()
+	}
+
+	fileSize, err := c.FileSize("magic-file")
+	if err != nil {
+		t.Error(err)
+	}
+	if fileSize != 42 {
+		t.Errorf("file size %q, expected %q", fileSize, 42)
+	}
+
+	_, err = c.FileSize("not-found")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	_, err = c.Delete("tset")
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = c.MakeDir(testDir)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = c.ChangeDir(testDir)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = c.ChangeDirToParent()
+	if err != nil {
+		t.Error(err)
+	}
+
+	entries, err := c.NameList("/")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(entries) != 1 || entries[0] != "/incoming" {
+		t.Errorf("Unexpected entries: %v", entries)
+	}
+
+	_, err = c.RemoveDir(testDir)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = c.Logout()
+	if err != nil {
+		if protoErr := err.(*ftp.ReplyError); protoErr != nil {
+			if protoErr.Code != ftp.StatusNotImplemented {
+				t.Error(err)
+			}
+		} else {
+			t.Error(err)
+		}
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.NoOp()
+	if err == nil {
+		t.Error("Expected error")
+	}
+}
+
+// TestConnFiles checks the files of the server after the commands of testConn.
+func TestConnFiles(t *testing.T) {
+	s, c := dialServer(t)
+	defer c.Quit()
+
+	if err := c.ChangeDir("incoming"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Stor("test", bytes.NewBufferString(testData)); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.ReadFile("/incoming/test"); !ok || string(data) != testData {
+		t.Errorf("stored %q, %v", data, ok)
+	}
+	if entries, err := c.List("."); err != nil || len(entries) != 2 {
+		t.Errorf("listed %v, %v", entries, err)
+	}
+	if _, err := c.FileSize("not-found"); !errors.Is(err, ftp.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := c.MakeDir(testDir); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := c.NameList("/incoming"); err != nil || !reflect.DeepEqual(names, []string{"magic-file", testDir, "test"}) {
+		t.Errorf("listed %v, %v", names, err)
+	}
+}
+
+// TestConnect tests the legacy Connect function
+func TestConnect(t *testing.T) {
+	s := newServer(t)
+
+	c, err := ftp.Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrongLogin(t *testing.T) {
+	s := newServer(t)
+	s.AddUser("user", "password")
+
+	c, err := ftp.DialTimeout(s.Addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	err = c.Login("zoo2Shia", "fei5Yix9")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestWrongLoginNotLoggedIn(t *testing.T) {
+	s := newServer(t)
+	s.AddUser("user", "password")
+
+	c, err := ftp.DialTimeout(s.Addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	if err := c.Login("zoo2Shia", "fei5Yix9"); !errors.Is(err, ftp.ErrNotLoggedIn) {
+		t.Fatalf("expected ErrNotLoggedIn, got %v", err)
+	}
+}
+
+func TestDeleteDirRecur(t *testing.T) {
+	s, c := dialServer(t)
+	s.WriteFile("/testDir/file", []byte(testData))
+	s.WriteFile("/testDir/sub/other", []byte(testData))
+	s.Mkdir("/testDir/empty")
+
+	_, err := c.RemoveDirRecur("testDir")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteDirRecurRemoved(t *testing.T) {
+	s, c := dialServer(t)
+	defer c.Quit()
+	s.WriteFile("/testDir/file", []byte(testData))
+	s.WriteFile("/testDir/sub/other", []byte(testData))
+	s.Mkdir("/testDir/empty")
+
+	if _, err := c.RemoveDirRecur("testDir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NameList("/testDir"); !errors.Is(err, ftp.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMissingFolderDeleteDirRecur(t *testing.T) {
+	_, c := dialServer(t)
+
+	_, err := c.RemoveDirRecur("missing-dir")
+	if err == nil {
+		t.Fatal("expected error got nil")
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoReconnect(t *testing.T) {
+	s, c := dialServer(t, ftp.DialWithAutoReconnect(true))
+	defer c.Quit()
+	s.Mkdir("/incoming")
+
+	if err := c.ChangeDir("incoming"); err != nil {
+		t.Fatal(err)
+	}
+	s.SetReplies("NOOP", "421 Service not available")
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The session is restored in the directory, then the command is replayed
+	commands := s.Commands()
+	expected := []string{"CWD", "PWD", "NOOP", "FEAT", "USER", "PASS", "TYPE", "OPTS", "CWD", "NOOP"}
+	if len(commands) < len(expected) || !reflect.DeepEqual(commands[len(commands)-len(expected):], expected) {
+		t.Errorf("unexpected commands %v", commands)
+	}
+	if dir, err := c.CurrentDir(); err != nil || dir != "/incoming" {
+		t.Errorf("current dir %q, %v", dir, err)
+	}
+}
+
+func TestAutoReconnectDisabled(t *testing.T) {
+	s, c := dialServer(t)
+	defer c.Quit()
+	s.SetReplies("NOOP", "421 Service not available")
+
+	if err := c.NoOp(); !errors.Is(err, ftp.ErrServiceClosing) {
+		t.Fatalf("expected ErrServiceClosing, got %v", err)
+	}
+}
+
+func TestGetTime(t *testing.T) {
+	s, c := dialServer(t)
+	defer c.Quit()
+	expected := time.Date(2020, 11, 12, 13, 14, 15, 0, time.UTC)
+	s.WriteFile("/file", []byte(testData))
+	s.SetModTime("/file", expected)
+
+	if tm, err := c.GetTime("file"); err != nil || !tm.Equal(expected) {
+		t.Errorf("time %v, %v, expected %v", tm, err, expected)
+	}
+	if _, err := c.GetTime("missing-file"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
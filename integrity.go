@@ -0,0 +1,187 @@
+package ftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// ErrIntegrity is matched by the errors reporting that a transferred file
+// differs from its remote copy.
+var ErrIntegrity = errors.New("ftp: integrity check failed")
+
+// IntegrityError describes a mismatch detected after a transfer.
+// errors.Is(err, ErrIntegrity) reports true for an *IntegrityError.
+type IntegrityError struct {
+	Path   string
+	Check  string // "SIZE" or the name of the checksum algorithm
+	Local  string
+	Remote string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("ftp: integrity check failed for %s: %s is %s locally, %s on the server", e.Path, e.Check, e.Local, e.Remote)
+}
+
+// Is makes errors.Is(err, ErrIntegrity) report true.
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrity
+}
+
+// DialWithIntegrityCheck returns a DialOption that configures the ServerConn
+// to verify files after Stor and Retr transfers complete.
+// The size of the remote file is compared with SIZE when supported, and a
+// checksum of the data is compared with HASH or XCRC when the server
+// advertises them. Transfers resumed from an offset are only checked by size.
+// Retr transfers are verified on Close, if the data was read until EOF.
+// A mismatch is reported with an *IntegrityError.
+func DialWithIntegrityCheck(enabled bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.checkIntegrity = enabled
+	}}
+}
+
+// integrityCheck counts and hashes the data of a transfer.
+type integrityCheck struct {
+	n       int64
+	hash    hash.Hash
+	algo    string
+	command string
+}
+
+// newIntegrityCheck returns a check for the next transfer, or nil if the
+// integrity check is disabled.
+func (c *ServerConn) newIntegrityCheck() *integrityCheck {
+	if !c.options.checkIntegrity {
+		return nil
+	}
+
+	check := &integrityCheck{}
+	check.command, check.algo, check.hash = c.checksumMethod()
+	return check
+}
+
+// checksumMethod returns the command computing the checksums of the remote
+// files, HASH or else XCRC, with its algorithm and a hash computing the same
+// checksums locally. The hash is nil if the server supports neither.
+func (c *ServerConn) checksumMethod() (command, algo string, h hash.Hash) {
+	if algos, ok := c.features["HASH"]; ok {
+		algo = selectedHashAlgo(algos)
+		if h = newHash(algo); h != nil {
+			return "HASH", algo, h
+		}
+	}
+	if _, ok := c.features["XCRC"]; ok {
+		return "XCRC", "CRC32", crc32.NewIEEE()
+	}
+	return "", "", nil
+}
+
+func (check *integrityCheck) Write(buf []byte) (int, error) {
+	check.n += int64(len(buf))
+	if check.hash != nil {
+		check.hash.Write(buf)
+	}
+	return len(buf), nil
+}
+
+// verifyIntegrity compares the transferred data with the remote file.
+func (c *ServerConn) verifyIntegrity(path string, offset uint64, check *integrityCheck) error {
+	if _, ok := c.features["SIZE"]; ok {
+		size, err := c.FileSize(path)
+		if err != nil {
+			return err
+		}
+		if local := int64(offset) + check.n; local != size {
+			return &IntegrityError{
+				Path:   path,
+				Check:  "SIZE",
+				Local:  fmt.Sprint(local),
+				Remote: fmt.Sprint(size),
+			}
+		}
+	}
+
+	if check.hash == nil || offset != 0 {
+		return nil
+	}
+
+	remote, err := c.remoteChecksum(check.command, path)
+	if err != nil {
+		return err
+	}
+
+	if local := hex.EncodeToString(check.hash.Sum(nil)); !strings.EqualFold(local, remote) {
+		return &IntegrityError{
+			Path:   path,
+			Check:  check.algo,
+			Local:  local,
+			Remote: remote,
+		}
+	}
+
+	return nil
+}
+
+// remoteChecksum returns the hex encoded checksum of a remote file computed
+// with the HASH, XMD5 or XCRC command.
+func (c *ServerConn) remoteChecksum(command, path string) (string, error) {
+	if command == "XCRC" || command == "XMD5" {
+		_, msg, err := c.cmd(StatusRequestedFileActionOK, "%s %s", command, path)
+		if err != nil {
+			return "", err
+		}
+		fields := strings.Fields(msg)
+		if len(fields) == 0 {
+			return "", fmt.Errorf("invalid %s response format", command)
+		}
+		return fields[0], nil
+	}
+
+	// 213 <algorithm> <start>-<end> <hash> <path>
+	_, msg, err := c.cmd(StatusFile, "HASH %s", path)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(msg)
+	if len(fields) < 3 {
+		return "", errors.New("invalid HASH response format")
+	}
+	return fields[2], nil
+}
+
+// selectedHashAlgo returns the algorithm marked as selected with a '*' in the
+// HASH feature, as described in draft-bryan-ftp-hash.
+func selectedHashAlgo(algos string) string {
+	for _, algo := range strings.Split(algos, ";") {
+		if strings.HasSuffix(algo, "*") {
+			return strings.TrimSuffix(algo, "*")
+		}
+	}
+	return ""
+}
+
+// newHash returns a hash.Hash for the given HASH algorithm name, or nil if
+// the algorithm is not supported.
+func newHash(algo string) hash.Hash {
+	switch strings.ToUpper(algo) {
+	case "MD5":
+		return md5.New()
+	case "SHA-1":
+		return sha1.New()
+	case "SHA-256":
+		return sha256.New()
+	case "SHA-512":
+		return sha512.New()
+	case "CRC32":
+		return crc32.NewIEEE()
+	}
+	return nil
+}
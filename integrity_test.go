@@ -0,0 +1,109 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+)
+
+func TestIntegrityStorHash(t *testing.T) {
+	sum := sha256.Sum256([]byte(testData))
+	mock, c := openConnReplies(t, map[string][]string{
+		"FEAT": {"211-Features:\r\n EPSV\r\n SIZE\r\n HASH SHA-1;SHA-256*;MD5\r\n211 End"},
+		"SIZE": {fmt.Sprintf("213 %d", len(testData))},
+		"HASH": {fmt.Sprintf("213 SHA-256 0-%d %s file", len(testData)-1, hex.EncodeToString(sum[:]))},
+	}, DialWithIntegrityCheck(true))
+
+	_, err := c.Stor("file", bytes.NewBufferString(testData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "SIZE", "HASH"})
+}
+
+func TestIntegrityStorHashMismatch(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"FEAT": {"211-Features:\r\n EPSV\r\n SIZE\r\n HASH SHA-256*\r\n211 End"},
+		"SIZE": {fmt.Sprintf("213 %d", len(testData))},
+		"HASH": {"213 SHA-256 0-13 0123456789abcdef file"},
+	}, DialWithIntegrityCheck(true))
+
+	_, err := c.Stor("file", bytes.NewBufferString(testData))
+	if !errors.Is(err, ErrIntegrity) {
+		t.Fatal("expected ErrIntegrity, got:", err)
+	}
+	if integrityErr, ok := err.(*IntegrityError); !ok || integrityErr.Check != "SHA-256" {
+		t.Error("unexpected error:", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "SIZE", "HASH"})
+}
+
+func TestIntegrityRetrXCRC(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"FEAT": {"211-Features:\r\n EPSV\r\n SIZE\r\n XCRC\r\n211 End"},
+		"SIZE": {fmt.Sprintf("213 %d", len(testData))},
+		"XCRC": {fmt.Sprintf("250 %08X", crc32.ChecksumIEEE([]byte(testData)))},
+	}, DialWithIntegrityCheck(true))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "SIZE", "XCRC"})
+}
+
+func TestIntegrityRetrXCRCOverXMD5(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"FEAT": {"211-Features:\r\n EPSV\r\n SIZE\r\n XMD5\r\n XCRC\r\n211 End"},
+		"SIZE": {fmt.Sprintf("213 %d", len(testData))},
+		"XCRC": {fmt.Sprintf("250 %08X", crc32.ChecksumIEEE([]byte(testData)))},
+	}, DialWithIntegrityCheck(true))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "SIZE", "XCRC"})
+}
+
+func TestIntegrityRetrSizeMismatch(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"SIZE": {"213 100"},
+	}, DialWithIntegrityCheck(true))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Close()
+	if integrityErr, ok := err.(*IntegrityError); !ok || integrityErr.Check != "SIZE" {
+		t.Fatal("expected a SIZE integrity error, got:", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "SIZE"})
+}
@@ -0,0 +1,74 @@
+package ftp
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// keepalive sends a NOOP on the control connection every time it has been
+// idle for interval, until stop is closed.
+func (c *ServerConn) keepalive(interval time.Duration, stop <-chan struct{}) {
+	tick := interval / 2
+	if tick <= 0 {
+		tick = interval
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !c.keepaliveTick(interval) {
+				return
+			}
+		}
+	}
+}
+
+// keepaliveTick sends a NOOP if the control connection is idle, and reports
+// whether the keepalive goes on.
+// A failure of the control connection is reported to the next command by
+// exchange, and stops the keepalive until a reconnection. The NOOP must be
+// answered within interval: the commands wait for the keepalive, which would
+// otherwise block them forever if the reply is lost.
+func (c *ServerConn) keepaliveTick(interval time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keepaliveErr != nil {
+		return false
+	}
+	if c.transferring || time.Since(c.lastActivity) < interval {
+		return true
+	}
+
+	if c.netConn != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		defer watchContext(ctx, c.netConn)()
+	}
+
+	err := c.skipUnsolicited()
+	if err == nil {
+		_, err = c.conn.Cmd("NOOP")
+	}
+	var code int
+	if err == nil {
+		code, _, err = c.readReply(StatusCommandOK)
+	}
+
+	switch {
+	case err == nil:
+		c.lastActivity = time.Now()
+		return true
+	case isReplyError(err) && code != StatusNotAvailable:
+		// The server is alive, but rejected NOOP
+		return true
+	}
+	c.keepaliveErr = fmt.Errorf("%w: keepalive: %w", ErrConnectionBroken, err)
+	return false
+}
@@ -0,0 +1,105 @@
+package ftp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithKeepalive(20*time.Millisecond))
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.Wait()
+
+	noops := 0
+	for _, cmd := range mock.commands {
+		if cmd == "NOOP" {
+			noops++
+		}
+	}
+	if noops == 0 {
+		t.Fatal("expected at least one NOOP, got:", mock.commands)
+	}
+}
+
+func TestKeepaliveFailure(t *testing.T) {
+	for _, reconnect := range []bool{false, true} {
+		mock, err := newFtpMock(t, "127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mock.Close()
+		mock.dropCommand = "NOOP"
+
+		c, err := Dial(mock.Addr(), DialWithKeepalive(20*time.Millisecond), DialWithAutoReconnect(reconnect))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Login("anonymous", "anonymous"); err != nil {
+			t.Fatal(err)
+		}
+
+		// The keepalive stops once the connection is dropped
+		time.Sleep(100 * time.Millisecond)
+
+		err = c.ChangeDir("incoming")
+		if reconnect {
+			if err != nil {
+				t.Fatal(err)
+			}
+			closeConn(t, mock, c, []string{"NOOP", "FEAT", "USER", "PASS", "TYPE", "CWD", "PWD"})
+			continue
+		}
+
+		if !errors.Is(err, ErrConnectionBroken) {
+			t.Errorf("expected ErrConnectionBroken, got %v", err)
+		}
+		c.Quit()
+		mock.Wait()
+		if expected := []string{"FEAT", "USER", "PASS", "TYPE", "NOOP"}; !reflect.DeepEqual(mock.commands, expected) {
+			t.Errorf("unexpected commands %v, expected %v", mock.commands, expected)
+		}
+	}
+}
+
+func TestKeepaliveUnanswered(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.ignoreCommand = "NOOP"
+
+	c, err := Dial(mock.Addr(), DialWithKeepalive(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lost reply marks the connection broken, instead of blocking the
+	// next commands
+	time.Sleep(100 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.ChangeDir("incoming")
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrConnectionBroken) {
+			t.Errorf("expected ErrConnectionBroken, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command is blocked by the keepalive")
+	}
+
+	c.Quit()
+	mock.Wait()
+}
@@ -0,0 +1,103 @@
+package ftp
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// LogLevels are the levels of the records written to the logger given to
+// DialWithLogger.
+type LogLevels struct {
+	Command   slog.Level // each command and the code of its reply
+	Transfer  slog.Level // start and end of the data transfers
+	Reconnect slog.Level // automatic reconnections
+}
+
+// DefaultLogLevels are the levels used unless DialWithLogLevels is given.
+var DefaultLogLevels = LogLevels{
+	Command:   slog.LevelDebug,
+	Transfer:  slog.LevelInfo,
+	Reconnect: slog.LevelWarn,
+}
+
+// DialWithLogger returns a DialOption that configures the ServerConn to log
+// the commands and reply codes, the start and end of the data transfers and
+// the automatic reconnections to logger.
+// Unlike DialWithDebugOutput, the records are structured and passwords are
+// never written.
+func DialWithLogger(logger *slog.Logger) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.logger = logger
+	}}
+}
+
+// DialWithLogLevels returns a DialOption that configures the levels of the
+// records logged with DialWithLogger.
+func DialWithLogLevels(levels LogLevels) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.logLevels = &levels
+	}}
+}
+
+// log writes a record if a logger is configured.
+func (c *ServerConn) log(level slog.Level, msg string, args ...any) {
+	if logger := c.options.logger; logger != nil {
+		logger.Log(context.Background(), level, msg, args...)
+	}
+}
+
+// logLevels returns the configured log levels.
+func (c *ServerConn) logLevels() *LogLevels {
+	if c.options.logLevels != nil {
+		return c.options.logLevels
+	}
+	return &DefaultLogLevels
+}
+
+// logCommand logs a command, without its argument if it is a secret, and
+// the code of the reply.
+func (c *ServerConn) logCommand(line string, code int, err error) {
+	if c.options.logger == nil {
+		return
+	}
+
+	args := []any{"command", redactCommand(line), "code", code}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	c.log(c.logLevels().Command, "ftp command", args...)
+}
+
+// logTransferStart logs the start of a data transfer.
+func (c *ServerConn) logTransferStart(line string, offset uint64) {
+	c.log(c.logLevels().Transfer, "ftp transfer started", "command", line, "offset", offset)
+}
+
+// logTransferEnd logs the end of a data transfer of n bytes.
+func (c *ServerConn) logTransferEnd(n int64, code int, elapsed time.Duration, err error) {
+	args := []any{"bytes", n, "code", code, "elapsed", elapsed}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	c.log(c.logLevels().Transfer, "ftp transfer finished", args...)
+}
+
+// redactCommand hides the argument of the commands carrying a secret.
+func redactCommand(line string) string {
+	verb := commandVerb(line)
+	switch strings.ToUpper(verb) {
+	case "PASS", "ACCT":
+		return verb + " ****"
+	}
+	return line
+}
+
+// commandVerb returns the first word of a command line.
+func commandVerb(line string) string {
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
@@ -0,0 +1,68 @@
+package ftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	mock, c := openConn(t, "127.0.0.1", DialWithLogger(logger))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR"})
+
+	output := buf.String()
+	for _, expected := range []string{
+		`level=DEBUG msg="ftp command" command="USER anonymous" code=331`,
+		`level=DEBUG msg="ftp command" command="PASS ****" code=230`,
+		`level=INFO msg="ftp transfer started" command="RETR file" offset=0`,
+		`level=INFO msg="ftp transfer finished" bytes=14 code=226`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in the log, got:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "PASS anonymous") {
+		t.Errorf("the password must not be logged, got:\n%s", output)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(buf, nil))
+
+	mock, c := openConn(t, "127.0.0.1", DialWithLogger(logger), DialWithLogLevels(LogLevels{
+		Command:  slog.LevelInfo,
+		Transfer: slog.LevelDebug,
+	}))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR"})
+
+	output := buf.String()
+	if !strings.Contains(output, `level=INFO msg="ftp command" command="RETR file" code=150`) {
+		t.Errorf("expected the commands in the log, got:\n%s", output)
+	}
+	if strings.Contains(output, "ftp transfer") {
+		t.Errorf("expected no transfer in the log, got:\n%s", output)
+	}
+}
@@ -0,0 +1,58 @@
+package ftp
+
+import (
+	"strings"
+	"time"
+)
+
+// TransferDirection tells whether a data transfer downloads or uploads data.
+type TransferDirection int
+
+// The directions of a transfer
+const (
+	TransferDownload TransferDirection = iota // RETR and the listings
+	TransferUpload                            // STOR, APPE and STOU
+)
+
+func (d TransferDirection) String() string {
+	if d == TransferUpload {
+		return "upload"
+	}
+	return "download"
+}
+
+// MetricsCollector receives the measures of a ServerConn, for instance to
+// update Prometheus collectors or expvar variables.
+// Its methods are called synchronously and must not block.
+type MetricsCollector interface {
+	// ObserveCommand is called after each command with its verb, such as
+	// "RETR", and the code of the reply, or 0 if none was received.
+	ObserveCommand(verb string, code int)
+
+	// ObserveTransfer is called at the end of each data transfer with the
+	// number of bytes moved and its duration. err is not nil if the transfer
+	// failed.
+	ObserveTransfer(direction TransferDirection, bytes int64, elapsed time.Duration, err error)
+
+	// ObserveReconnect is called after each automatic reconnection.
+	// err is not nil if it failed.
+	ObserveReconnect(err error)
+}
+
+// DialWithMetrics returns a DialOption that configures the ServerConn to
+// report its commands, transfers and reconnections to collector.
+func DialWithMetrics(collector MetricsCollector) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.metrics = collector
+	}}
+}
+
+// transferDirection returns the direction of the transfer started by the
+// command line.
+func transferDirection(line string) TransferDirection {
+	switch strings.ToUpper(commandVerb(line)) {
+	case "STOR", "APPE", "STOU":
+		return TransferUpload
+	}
+	return TransferDownload
+}
@@ -0,0 +1,99 @@
+package ftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	mu         sync.Mutex
+	commands   map[string][]int
+	bytes      map[TransferDirection]int64
+	transfers  int
+	reconnects int
+}
+
+func (m *testMetrics) ObserveCommand(verb string, code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.commands == nil {
+		m.commands = map[string][]int{}
+	}
+	m.commands[verb] = append(m.commands[verb], code)
+}
+
+func (m *testMetrics) ObserveTransfer(direction TransferDirection, n int64, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.bytes == nil {
+		m.bytes = map[TransferDirection]int64{}
+	}
+	m.bytes[direction] += n
+	m.transfers++
+}
+
+func (m *testMetrics) ObserveReconnect(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reconnects++
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &testMetrics{}
+
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.dropCommand = "NOOP"
+
+	c, err := Dial(mock.Addr(), DialWithMetrics(metrics), DialWithAutoReconnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	if _, err := c.Stor("upload", bytes.NewBufferString("upload data")); err != nil {
+		t.Error(err)
+	}
+
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Quit()
+	mock.Wait()
+
+	expected := map[TransferDirection]int64{
+		TransferDownload: int64(len(testData)),
+		TransferUpload:   int64(len("upload data")),
+	}
+	if !reflect.DeepEqual(metrics.bytes, expected) {
+		t.Errorf("expected %v, got %v", expected, metrics.bytes)
+	}
+	if metrics.transfers != 2 {
+		t.Errorf("expected 2 transfers, got %d", metrics.transfers)
+	}
+	if metrics.reconnects != 1 {
+		t.Errorf("expected 1 reconnect, got %d", metrics.reconnects)
+	}
+	if codes := metrics.commands["PASS"]; !reflect.DeepEqual(codes, []int{230, 230}) {
+		t.Errorf("expected a PASS command before and after the reconnection, got %v", codes)
+	}
+}
@@ -0,0 +1,157 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// TimeSource tells which command reported a modification time.
+type TimeSource int
+
+// The sources of GetModTime, from the most to the least precise
+const (
+	TimeSourceMDTM TimeSource = iota + 1
+	TimeSourceMLST
+	TimeSourceLIST
+)
+
+// String returns the command of the source.
+func (s TimeSource) String() string {
+	switch s {
+	case TimeSourceMDTM:
+		return "MDTM"
+	case TimeSourceMLST:
+		return "MLST"
+	case TimeSourceLIST:
+		return "LIST"
+	default:
+		return "unknown"
+	}
+}
+
+// ModTime is a modification time returned by GetModTime.
+type ModTime struct {
+	Time   time.Time
+	Source TimeSource
+
+	// Precision is a second or finer for MDTM and MLST. LIST only reports
+	// minutes for recent files, and days for the others.
+	Precision time.Duration
+}
+
+// GetModTime returns the modification time of the file at path, trying MDTM,
+// then the modify fact of MLST when supported, then the listing of LIST.
+// The times from MDTM and MLST are in UTC, the ones from LIST in the location
+// set by DialWithLocation or SetLocation.
+func (c *ServerConn) GetModTime(path string) (ModTime, error) {
+	_, msg, err := c.cmd(StatusFile, "MDTM %s", path)
+	if err == nil {
+		var t time.Time
+		if t, err = parseMDTM(msg); err == nil {
+			return ModTime{Time: t, Source: TimeSourceMDTM, Precision: mdtmPrecision(msg)}, nil
+		}
+	}
+	if c.isConnectionError(err) {
+		return ModTime{}, err
+	}
+
+	if c.HasFeature("MLST") {
+		_, msg, err = c.cmd(StatusRequestedFileActionOK, "MLST %s", path)
+		if err == nil {
+			var mt ModTime
+			if mt, err = parseMLSTModify(msg); err == nil {
+				return mt, nil
+			}
+		}
+		if c.isConnectionError(err) {
+			return ModTime{}, err
+		}
+	}
+
+	return c.listModTime(path)
+}
+
+// Touch creates an empty file at path if it is missing, otherwise sets its
+// modification time to the current time with MFMT.
+// An existing file is left unchanged if the server does not support MFMT, and
+// an error is returned.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) Touch(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer c.withContext(ctx)()
+
+	if c.HasFeature("MFMT") {
+		err := c.setModTime(path, time.Now())
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	} else if _, err := c.Stat(ctx, path); err == nil {
+		return errors.New("ftp: MFMT not supported, can not touch an existing file")
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	// Storing the file also sets its modification time
+	_, err := c.Stor(path, &bytes.Buffer{})
+	return err
+}
+
+// setModTime sets the modification time of the file at path with MFMT.
+func (c *ServerConn) setModTime(path string, t time.Time) error {
+	_, _, err := c.cmd(StatusFile, "MFMT %s %s", t.UTC().Format("20060102150405"), path)
+	return err
+}
+
+// listModTime returns the modification time of the file at p from LIST.
+func (c *ServerConn) listModTime(p string) (ModTime, error) {
+	entries, err := c.List(p)
+	if err != nil {
+		return ModTime{}, err
+	}
+
+	for _, entry := range entries {
+		if len(entries) > 1 && entry.Name != path.Base(p) {
+			continue
+		}
+
+		// The time of day is only listed for recent files
+		precision := time.Minute
+		if entry.Time.Hour() == 0 && entry.Time.Minute() == 0 {
+			precision = 24 * time.Hour
+		}
+		return ModTime{Time: entry.Time, Source: TimeSourceLIST, Precision: precision}, nil
+	}
+	return ModTime{}, errors.New("ftp: file not found in the listing")
+}
+
+// parseMLSTModify parses the modify fact of a reply to MLST.
+func parseMLSTModify(message string) (ModTime, error) {
+	value, ok := parseMLSTFacts(message)["modify"]
+	if !ok {
+		return ModTime{}, errors.New("ftp: no modify fact in the MLST reply")
+	}
+
+	t, err := parseMDTM(value)
+	if err != nil {
+		return ModTime{}, err
+	}
+	return ModTime{Time: t, Source: TimeSourceMLST, Precision: mdtmPrecision(value)}, nil
+}
+
+// mdtmPrecision returns the precision of a time in the format of MDTM, such
+// as "20201112131415" or "20201112131415.123".
+func mdtmPrecision(value string) time.Duration {
+	precision := time.Second
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		for digits := len(value) - i - 1; digits > 0 && precision > time.Nanosecond; digits-- {
+			precision /= 10
+		}
+	}
+	return precision
+}
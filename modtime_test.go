@@ -0,0 +1,106 @@
+package ftp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetModTimeMDTM(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	mt, err := c.GetModTime("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ModTime{
+		Time:      time.Date(2020, 11, 12, 13, 14, 15, 0, time.UTC),
+		Source:    TimeSourceMDTM,
+		Precision: time.Second,
+	}
+	if mt != expected {
+		t.Errorf("time %+v, expected %+v", mt, expected)
+	}
+
+	closeConn(t, mock, c, []string{"MDTM"})
+}
+
+func TestGetModTimeMLST(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"MDTM": {"550 Not a plain file"},
+		"MLST": {"250-Listing file\r\n type=file;size=14;modify=20201112131415.123; file\r\n250 End"},
+	}, DialWithFeatureOverride("MLST", true))
+
+	mt, err := c.GetModTime("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt.Source != TimeSourceMLST || mt.Precision != time.Millisecond {
+		t.Errorf("unexpected time %+v", mt)
+	}
+	if expected := time.Date(2020, 11, 12, 13, 14, 15, 123000000, time.UTC); !mt.Time.Equal(expected) {
+		t.Errorf("time %v, expected %v", mt.Time, expected)
+	}
+
+	closeConn(t, mock, c, []string{"MDTM", "MLST"})
+}
+
+func TestGetModTimeLIST(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"MDTM": {"502 Command not implemented"},
+	})
+
+	mt, err := c.GetModTime("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt.Source != TimeSourceLIST || mt.Precision != time.Minute {
+		t.Errorf("unexpected time %+v", mt)
+	}
+	if mt.Time.Month() != time.January || mt.Time.Day() != 29 || mt.Time.Hour() != 10 || mt.Time.Minute() != 29 {
+		t.Errorf("unexpected time %v", mt.Time)
+	}
+
+	closeConn(t, mock, c, []string{"MDTM", "EPSV", "LIST"})
+}
+
+func TestTouchMFMT(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithFeatureOverride("MFMT", true))
+
+	if err := c.Touch(context.Background(), "file"); err != nil {
+		t.Fatal(err)
+	}
+	// A missing file is created
+	if err := c.Touch(context.Background(), "missing-file"); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"MFMT", "MFMT", "EPSV", "STOR"})
+}
+
+func TestTouchWithoutMFMT(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	if err := c.Touch(context.Background(), "new"); err != nil {
+		t.Fatal(err)
+	}
+	// The time of an existing file can not be set
+	if err := c.Touch(context.Background(), "lo"); err == nil {
+		t.Error("expected an error")
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "EPSV", "LIST", "EPSV", "STOR", "EPSV", "LIST"})
+}
+
+func TestMDTMPrecision(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"20201112131415":           time.Second,
+		"20201112131415.1":         100 * time.Millisecond,
+		"20201112131415.123456":    time.Microsecond,
+		"20201112131415.123456789": time.Nanosecond,
+	} {
+		if precision := mdtmPrecision(value); precision != expected {
+			t.Errorf("mdtmPrecision(%q) = %s, expected %s", value, precision, expected)
+		}
+	}
+}
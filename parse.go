@@ -3,6 +3,7 @@ package ftp
 import (
 	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +13,45 @@ var errUnsupportedListLine = errors.New("unsupported LIST line")
 var errUnsupportedListDate = errors.New("unsupported LIST date")
 var errUnknownListEntryType = errors.New("unknown entry type")
 
+// ErrListParse is matched by the *ListParseError returned in strict listing
+// mode, see DialWithStrictListing.
+var ErrListParse = errors.New("ftp: can not parse listing line")
+
+// ListParseError reports a listing line which could not be parsed.
+// errors.Is(err, ErrListParse) reports true for a *ListParseError.
+type ListParseError struct {
+	Line string
+	Err  error
+}
+
+func (e *ListParseError) Error() string {
+	return fmt.Sprintf("ftp: can not parse listing line %q: %v", e.Line, e.Err)
+}
+
+// Is makes errors.Is(err, ErrListParse) report true.
+func (e *ListParseError) Is(target error) bool {
+	return target == ErrListParse
+}
+
+// Unwrap returns the error of the parser.
+func (e *ListParseError) Unwrap() error {
+	return e.Err
+}
+
+// isListHeader reports whether a listing line lists no file, such as the
+// "total 42" line of ls or an empty line.
+func isListHeader(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	if len(fields) != 2 || fields[0] != "total" {
+		return false
+	}
+	_, err := strconv.ParseUint(fields[1], 10, 64)
+	return err == nil
+}
+
 type parseFunc func(string, time.Time, *time.Location) (*Entry, error)
 
 var listLineParsers = []parseFunc{
@@ -64,11 +104,56 @@ func parseRFC3659ListLine(line string, now time.Time, loc *time.Location) (*Entr
 			}
 		case "size":
 			e.setSize(value)
+		case "unix.mode":
+			if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+				e.Mode = os.FileMode(mode) & os.ModePerm
+			}
 		}
 	}
 	return e, nil
 }
 
+// isMLSDDotEntry reports whether an MLSD line lists the current or the parent
+// directory, with the type fact cdir or pdir.
+func isMLSDDotEntry(line string) bool {
+	facts, _, ok := strings.Cut(line, " ")
+	if !ok {
+		return false
+	}
+	for _, fact := range strings.Split(facts, ";") {
+		if name, value, ok := strings.Cut(fact, "="); ok && strings.EqualFold(name, "type") {
+			value = strings.ToLower(value)
+			return value == "cdir" || value == "pdir"
+		}
+	}
+	return false
+}
+
+// parseMLSTFacts returns the facts of a reply to MLST, by lower case name.
+func parseMLSTFacts(message string) map[string]string {
+	facts := make(map[string]string)
+
+	// The facts are on the line starting with a space, before the path
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		line = strings.TrimLeft(line, " ")
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[:i]
+		}
+
+		for _, fact := range strings.Split(line, ";") {
+			if i := strings.IndexByte(fact, '='); i > 0 {
+				facts[strings.ToLower(fact[:i])] = fact[i+1:]
+			}
+		}
+		break
+	}
+	return facts
+}
+
 // parseLsListLine parses a directory line in a format based on the output of
 // the UNIX ls command.
 func parseLsListLine(line string, now time.Time, loc *time.Location) (*Entry, error) {
@@ -124,6 +209,7 @@ func parseLsListLine(line string, now time.Time, loc *time.Location) (*Entry, er
 
 	e := &Entry{
 		Name: scanner.Remaining(),
+		Mode: parseLsMode(fields[0]),
 	}
 	switch fields[0][0] {
 	case '-':
@@ -227,6 +313,17 @@ func parseListLine(line string, now time.Time, loc *time.Location) (*Entry, erro
 	return nil, errUnsupportedListLine
 }
 
+// parseLsMode returns the permission bits of a mode such as "drwxr-xr-x".
+func parseLsMode(mode string) os.FileMode {
+	var perm os.FileMode
+	for i := 1; i < 10 && i < len(mode); i++ {
+		if mode[i] != '-' && mode[i] != 'S' && mode[i] != 'T' {
+			perm |= 1 << uint(9-i)
+		}
+	}
+	return perm
+}
+
 func (e *Entry) setSize(str string) (err error) {
 	e.Size, err = strconv.ParseUint(str, 0, 64)
 	return
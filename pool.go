@@ -0,0 +1,327 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned when getting a connection from a closed Pool.
+var ErrPoolClosed = errors.New("ftp: pool closed")
+
+// Pool is a bounded set of connections to the same server, dialed on demand
+// and reused across operations.
+// It is safe for concurrent use.
+type Pool struct {
+	dial      func() (*ServerConn, error)
+	slots     *limiter
+	transfers *limiter // nil if not limited
+	host      *HostLimit
+
+	mu     sync.Mutex
+	idle   []*ServerConn
+	closed bool
+}
+
+// PoolOption configures a Pool, see NewPool.
+type PoolOption struct {
+	setup func(p *Pool)
+}
+
+// PoolWithMaxConcurrentTransfers returns a PoolOption bounding the number of
+// data transfers in progress on the connections of the Pool, which may be
+// lower than its size to keep connections available for the other commands.
+// The transfers wait for a slot in the order they were started, as long as
+// the context of the operation allows.
+func PoolWithMaxConcurrentTransfers(n int) PoolOption {
+	return PoolOption{func(p *Pool) {
+		p.transfers = newLimiter(n)
+	}}
+}
+
+// PoolWithHostLimit returns a PoolOption bounding the connections of the Pool
+// with l, shared by all the pools connecting to the same server, such as with
+// different credentials, so that together they do not exceed the connection
+// limit of the server. Idle connections count until they are closed: a Pool
+// waiting for a connection closes the idle connections of the other pools.
+func PoolWithHostLimit(l *HostLimit) PoolOption {
+	return PoolOption{func(p *Pool) {
+		p.host = l
+	}}
+}
+
+// NewPool returns a Pool holding at most size connections, each created by
+// calling dial, which is expected to return a logged in connection.
+// Get waits for a connection in the order of the calls.
+func NewPool(size int, dial func() (*ServerConn, error), options ...PoolOption) *Pool {
+	p := &Pool{
+		dial:  dial,
+		slots: newLimiter(size),
+	}
+	for _, option := range options {
+		option.setup(p)
+	}
+	if p.host != nil {
+		p.host.register(p)
+	}
+	return p
+}
+
+// Get returns an idle connection or dials a new one, waiting for a
+// connection to be released if the pool is full.
+// The connection must be handed back with Put or Discard.
+func (p *Pool) Get(ctx context.Context) (*ServerConn, error) {
+	if err := p.slots.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.slots.release()
+		return nil, ErrPoolClosed
+	}
+	var expired []*ServerConn
+	for n := len(p.idle); n > 0; n = len(p.idle) {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		if !c.Expired() {
+			p.mu.Unlock()
+			p.quitAll(expired)
+			return c, nil
+		}
+		expired = append(expired, c)
+	}
+	p.mu.Unlock()
+
+	// Expired connections are replaced by a new one
+	p.quitAll(expired)
+
+	if p.host != nil {
+		if err := p.host.acquire(ctx, p); err != nil {
+			p.slots.release()
+			return nil, err
+		}
+	}
+	c, err := p.dial()
+	if err != nil {
+		if p.host != nil {
+			p.host.slots.release()
+		}
+		p.slots.release()
+		return nil, err
+	}
+	c.transferLimit = p.transfers
+	return c, nil
+}
+
+// Put hands a healthy connection back to the pool.
+// Expired connections, see ServerConn.Expired, are closed instead.
+func (p *Pool) Put(c *ServerConn) {
+	p.mu.Lock()
+	if p.closed || c.Expired() {
+		p.mu.Unlock()
+		p.quit(c)
+	} else {
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+	}
+	p.slots.release()
+}
+
+// Discard closes a connection that is no longer usable and frees its slot.
+func (p *Pool) Discard(c *ServerConn) {
+	p.quit(c)
+	p.slots.release()
+}
+
+// Release hands c back with Put, or with Discard if err shows that the
+// connection is broken.
+func (p *Pool) Release(c *ServerConn, err error) {
+	if err != nil && c.isConnectionError(err) {
+		p.Discard(c)
+	} else {
+		p.Put(c)
+	}
+}
+
+// Close closes the idle connections. Connections currently in use are closed
+// when handed back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	if p.host != nil {
+		p.host.unregister(p)
+	}
+	return p.quitAll(idle)
+}
+
+// closeIdle closes the oldest idle connection, and reports whether there was
+// one.
+func (p *Pool) closeIdle() bool {
+	p.mu.Lock()
+	if len(p.idle) == 0 {
+		p.mu.Unlock()
+		return false
+	}
+	c := p.idle[0]
+	p.idle = p.idle[1:]
+	p.mu.Unlock()
+
+	p.quit(c)
+	return true
+}
+
+// quit closes a connection of the pool, and returns the error of Quit.
+func (p *Pool) quit(c *ServerConn) error {
+	err := c.Quit()
+	if p.host != nil {
+		p.host.slots.release()
+	}
+	return err
+}
+
+// quitAll closes all the connections, and returns the last error.
+func (p *Pool) quitAll(conns []*ServerConn) error {
+	var err error
+	for _, c := range conns {
+		if e := p.quit(c); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// HostLimit bounds the number of connections opened to a server by several
+// pools, see PoolWithHostLimit. The connections are granted in the order they
+// were requested.
+// It is safe for concurrent use.
+type HostLimit struct {
+	slots *limiter
+
+	mu    sync.Mutex
+	pools []*Pool
+}
+
+// NewHostLimit returns a HostLimit allowing max connections.
+func NewHostLimit(max int) *HostLimit {
+	return &HostLimit{slots: newLimiter(max)}
+}
+
+func (h *HostLimit) register(p *Pool) {
+	h.mu.Lock()
+	h.pools = append(h.pools, p)
+	h.mu.Unlock()
+}
+
+func (h *HostLimit) unregister(p *Pool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, pool := range h.pools {
+		if pool == p {
+			h.pools = append(h.pools[:i:i], h.pools[i+1:]...)
+			return
+		}
+	}
+}
+
+// acquire waits for a connection slot for the pool p. If none is available,
+// an idle connection of another pool is closed.
+func (h *HostLimit) acquire(ctx context.Context, p *Pool) error {
+	if h.slots.tryAcquire() {
+		return nil
+	}
+
+	h.mu.Lock()
+	pools := append([]*Pool(nil), h.pools...)
+	h.mu.Unlock()
+	for _, pool := range pools {
+		if pool != p && pool.closeIdle() {
+			break
+		}
+	}
+
+	return h.slots.acquire(ctx)
+}
+
+// limiter is a counting semaphore granting its slots in the order they were
+// requested.
+type limiter struct {
+	mu      sync.Mutex
+	max     int
+	used    int
+	waiters []chan struct{}
+}
+
+func newLimiter(max int) *limiter {
+	if max < 1 {
+		max = 1
+	}
+	return &limiter{max: max}
+}
+
+// tryAcquire takes a slot if one is available without waiting.
+func (l *limiter) tryAcquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.used < l.max && len(l.waiters) == 0 {
+		l.used++
+		return true
+	}
+	return false
+}
+
+// acquire waits for a slot, or for ctx to be done.
+func (l *limiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.used < l.max && len(l.waiters) == 0 {
+		l.used++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// Granted meanwhile
+		l.releaseLocked()
+	default:
+		for i, w := range l.waiters {
+			if w == ready {
+				l.waiters = append(l.waiters[:i:i], l.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// release frees a slot, handing it to the first waiter if any.
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.releaseLocked()
+	l.mu.Unlock()
+}
+
+func (l *limiter) releaseLocked() {
+	if len(l.waiters) > 0 {
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+		return
+	}
+	l.used--
+}
@@ -0,0 +1,152 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// newMocksPool returns a pool dialing a new mock server for each connection
+func newMocksPool(t *testing.T, size int, options ...PoolOption) (*Pool, *int) {
+	dialed := new(int)
+	pool := NewPool(size, func() (*ServerConn, error) {
+		mock, err := newFtpMock(t, "127.0.0.1")
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(mock.Close)
+
+		c, err := Dial(mock.Addr())
+		if err != nil {
+			return nil, err
+		}
+		*dialed++
+		return c, c.Login("anonymous", "anonymous")
+	}, options...)
+	t.Cleanup(func() { pool.Close() })
+
+	return pool, dialed
+}
+
+func TestLimiterOrder(t *testing.T) {
+	l := newLimiter(1)
+	ctx := context.Background()
+	if err := l.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A canceled waiter leaves the queue
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.acquire(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	granted := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			l.acquire(ctx)
+			granted <- i
+		}(i)
+		// Wait for the goroutine to queue
+		for {
+			l.mu.Lock()
+			n := len(l.waiters)
+			l.mu.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		l.release()
+		if got := <-granted; got != i {
+			t.Errorf("slot granted to %d, expected %d", got, i)
+		}
+	}
+}
+
+func TestPoolMaxConcurrentTransfers(t *testing.T) {
+	pool, _ := newMocksPool(t, 2, PoolWithMaxConcurrentTransfers(1))
+	ctx := context.Background()
+
+	c1, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c1.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second transfer waits for the first one
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	restore := c2.withContext(timeout)
+	_, err = c2.Retr("file")
+	restore()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Other commands are not limited
+	if err := c2.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.ReadAll(r)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = c2.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+	r.Close()
+
+	pool.Put(c1)
+	pool.Put(c2)
+}
+
+func TestPoolHostLimit(t *testing.T) {
+	limit := NewHostLimit(1)
+	pool1, dialed1 := newMocksPool(t, 2, PoolWithHostLimit(limit))
+	pool2, dialed2 := newMocksPool(t, 2, PoolWithHostLimit(limit))
+	ctx := context.Background()
+
+	c, err := pool1.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection in use holds the slot
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool2.Get(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Once idle, it is closed for the other pool
+	pool1.Put(c)
+	c, err = pool2.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool1.mu.Lock()
+	idle := len(pool1.idle)
+	pool1.mu.Unlock()
+	if idle != 0 || *dialed1 != 1 || *dialed2 != 1 {
+		t.Errorf("%d idle connections, dialed %d and %d", idle, *dialed1, *dialed2)
+	}
+	pool2.Put(c)
+}
@@ -0,0 +1,79 @@
+package ftp
+
+import "fmt"
+
+// ProxyType is the login convention of a classic FTP proxy.
+type ProxyType int
+
+// The login conventions of classic FTP proxies
+const (
+	// ProxyUserAtHost logs in with "USER user@host" and "PASS password".
+	ProxyUserAtHost ProxyType = iota
+	// ProxySite logs in to the proxy, issues "SITE host", then logs in to the
+	// server.
+	ProxySite
+	// ProxyOpen logs in to the proxy, issues "OPEN host", then logs in to the
+	// server.
+	ProxyOpen
+)
+
+// ProxyConfig describes how to reach a FTP server through a classic FTP
+// proxy. The address given to Dial is the one of the proxy.
+type ProxyConfig struct {
+	Type ProxyType
+
+	// Host is the FTP server the proxy connects to, as "host" or "host:port".
+	Host string
+
+	// User and Password authenticate against the proxy itself.
+	// They are ignored by ProxyUserAtHost and are optional for the others.
+	User     string
+	Password string
+}
+
+// DialWithProxy returns a DialOption that configures the ServerConn to log in
+// through a classic FTP proxy following the given convention.
+// The server features are discovered again once logged in to the server.
+func DialWithProxy(config ProxyConfig) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.proxy = &config
+	}}
+}
+
+// proxyLogin logs in to the server through the proxy.
+func (c *ServerConn) proxyLogin(user, password string) error {
+	proxy := c.options.proxy
+
+	switch proxy.Type {
+	case ProxyUserAtHost:
+		if err := c.authenticate(user+"@"+proxy.Host, password); err != nil {
+			return err
+		}
+	case ProxySite, ProxyOpen:
+		if proxy.User != "" {
+			if err := c.authenticate(proxy.User, proxy.Password); err != nil {
+				return err
+			}
+		}
+
+		command := "SITE"
+		if proxy.Type == ProxyOpen {
+			command = "OPEN"
+		}
+		code, message, err := c.cmd(-1, "%s %s", command, proxy.Host)
+		if err != nil {
+			return err
+		}
+		if code/100 != 2 {
+			return newReplyError(code, message)
+		}
+
+		if err := c.authenticate(user, password); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown proxy type %d", proxy.Type)
+	}
+
+	return c.discoverFeatures()
+}
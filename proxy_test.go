@@ -0,0 +1,100 @@
+package ftp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func testProxy(t *testing.T, config ProxyConfig, replies map[string][]string, expected []string) {
+	mock, c := openConnReplies(t, replies, DialWithProxy(config))
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.Wait()
+
+	if !reflect.DeepEqual(mock.lines, expected) {
+		t.Fatal("unexpected sequence of commands:", mock.lines, "expected:", expected)
+	}
+}
+
+func TestProxyUserAtHost(t *testing.T) {
+	testProxy(t, ProxyConfig{
+		Type: ProxyUserAtHost,
+		Host: "ftp.example.org",
+	}, map[string][]string{
+		"USER": {"331 Password required"},
+	}, []string{
+		"FEAT",
+		"USER anonymous@ftp.example.org",
+		"PASS anonymous",
+		"FEAT",
+		"TYPE I",
+		"QUIT",
+	})
+}
+
+func TestProxySite(t *testing.T) {
+	testProxy(t, ProxyConfig{
+		Type:     ProxySite,
+		Host:     "ftp.example.org:2121",
+		User:     "proxyuser",
+		Password: "proxypass",
+	}, map[string][]string{
+		"USER": {"331 Proxy password required"},
+		"SITE": {"220 Connected to ftp.example.org"},
+	}, []string{
+		"FEAT",
+		"USER proxyuser",
+		"PASS proxypass",
+		"SITE ftp.example.org:2121",
+		"USER anonymous",
+		"PASS anonymous",
+		"FEAT",
+		"TYPE I",
+		"QUIT",
+	})
+}
+
+func TestProxyOpen(t *testing.T) {
+	testProxy(t, ProxyConfig{
+		Type: ProxyOpen,
+		Host: "ftp.example.org",
+	}, map[string][]string{
+		"OPEN": {"220 Connected to ftp.example.org"},
+	}, []string{
+		"FEAT",
+		"OPEN ftp.example.org",
+		"USER anonymous",
+		"PASS anonymous",
+		"FEAT",
+		"TYPE I",
+		"QUIT",
+	})
+}
+
+func TestProxySiteRefused(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.cannedReplies = map[string][]string{
+		"SITE": {"530 Connection to ftp.example.org denied"},
+	}
+
+	c, err := Dial(mock.Addr(), DialWithProxy(ProxyConfig{
+		Type: ProxySite,
+		Host: "ftp.example.org",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	err = c.Login("anonymous", "anonymous")
+	if !errors.Is(err, ErrNotLoggedIn) || ReplyCode(err) != StatusNotLoggedIn {
+		t.Errorf("expected a 530 reply, got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package ftp
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrQueueClosed is reported by jobs enqueued after the Queue was closed.
+var ErrQueueClosed = errors.New("ftp: queue closed")
+
+// JobState describes the progress of a queued Job.
+type JobState int
+
+// The states of a Job
+const (
+	JobPending JobState = iota
+	JobRunning
+	JobDone
+	JobFailed
+)
+
+// Job is a unit of work executed by a Queue on a pooled connection.
+type Job struct {
+	// Name identifies the job in status reports.
+	Name string
+
+	// Priority orders the pending jobs: higher priorities run first, jobs
+	// with the same priority run in submission order.
+	Priority int
+
+	// Run performs the job on the given connection.
+	Run func(c *ServerConn) error
+
+	// OnStatus, if not nil, is called every time the job changes state.
+	OnStatus func(state JobState, err error)
+}
+
+// DownloadJob returns a Job retrieving the remote file into w.
+func DownloadJob(remotePath string, w io.Writer) Job {
+	return Job{
+		Name: remotePath,
+		Run: func(c *ServerConn) error {
+			r, err := c.Retr(remotePath)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(w, r)
+			if cerr := r.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+	}
+}
+
+// UploadJob returns a Job storing the content of r into the remote file.
+func UploadJob(remotePath string, r io.Reader) Job {
+	return Job{
+		Name: remotePath,
+		Run: func(c *ServerConn) error {
+			_, err := c.Stor(remotePath, r)
+			return err
+		},
+	}
+}
+
+// Ticket tracks a Job submitted to a Queue.
+type Ticket struct {
+	job  Job
+	seq  uint64
+	done chan struct{}
+
+	mu    sync.Mutex
+	state JobState
+	err   error
+}
+
+// Job returns the tracked job.
+func (t *Ticket) Job() Job {
+	return t.job
+}
+
+// State returns the current state of the job.
+func (t *Ticket) State() JobState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Done returns a channel closed when the job has finished.
+func (t *Ticket) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait waits for the job to finish and returns its error.
+func (t *Ticket) Wait() error {
+	<-t.done
+	return t.err
+}
+
+func (t *Ticket) setState(state JobState, err error) {
+	t.mu.Lock()
+	t.state = state
+	t.err = err
+	t.mu.Unlock()
+
+	if t.job.OnStatus != nil {
+		t.job.OnStatus(state, err)
+	}
+	if state == JobDone || state == JobFailed {
+		close(t.done)
+	}
+}
+
+// Queue schedules transfer jobs by priority and executes them on a Pool with
+// a bounded number of concurrent jobs.
+// It is safe for concurrent use.
+type Queue struct {
+	pool *Pool
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending ticketHeap
+	seq     uint64
+	closed  bool
+
+	schedule  *Schedule
+	usedDay   time.Time // start of the day of usedBytes
+	usedBytes int64
+}
+
+// NewQueue returns a Queue running at most concurrency jobs at a time on
+// connections taken from pool.
+func NewQueue(pool *Pool, concurrency int) *Queue {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	q := &Queue{pool: pool}
+	q.cond = sync.NewCond(&q.mu)
+
+	q.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go q.worker()
+	}
+
+	return q
+}
+
+// Enqueue submits a job and returns its Ticket.
+func (q *Queue) Enqueue(job Job) *Ticket {
+	t := &Ticket{
+		job:  job,
+		done: make(chan struct{}),
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		t.setState(JobFailed, ErrQueueClosed)
+		return t
+	}
+	q.seq++
+	t.seq = q.seq
+	heap.Push(&q.pending, t)
+	q.mu.Unlock()
+
+	q.cond.Signal()
+	return t
+}
+
+// SetSchedule restricts when the pending jobs start to the schedule s, for
+// instance to pause nightly mirror jobs during business hours, or removes the
+// restrictions if s is nil. The bytes transferred by the jobs count against
+// the daily budget of s whatever the schedule they ran under.
+// Close waits for the pending jobs, including the ones waiting for their
+// schedule.
+func (q *Queue) SetSchedule(s *Schedule) {
+	if s != nil {
+		copied := *s
+		copied.Windows = append([]TimeWindow(nil), s.Windows...)
+		s = &copied
+	}
+
+	q.mu.Lock()
+	q.schedule = s
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// Close stops accepting jobs and waits for the pending ones to complete.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	q.cond.Broadcast()
+	q.wg.Wait()
+}
+
+// next returns the pending job with the highest priority, or nil when the
+// queue is closed and drained.
+func (q *Queue) next() *Ticket {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.pending.Len() == 0 {
+			if q.closed {
+				return nil
+			}
+			q.cond.Wait()
+			continue
+		}
+
+		delay := q.scheduleDelay()
+		if delay <= 0 {
+			break
+		}
+		timer := time.AfterFunc(delay, func() {
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		})
+		q.cond.Wait()
+		timer.Stop()
+	}
+
+	return heap.Pop(&q.pending).(*Ticket)
+}
+
+// scheduleDelay returns the time to wait before starting a job, according to
+// the schedule. q.mu must be held.
+func (q *Queue) scheduleDelay() time.Duration {
+	if q.schedule == nil {
+		return 0
+	}
+	now := time.Now()
+	q.resetUsage(now)
+	return q.schedule.delay(now, q.usedBytes)
+}
+
+// resetUsage resets the daily budget when a new day has started.
+// q.mu must be held.
+func (q *Queue) resetUsage(now time.Time) {
+	if day := q.schedule.day(now); !day.Equal(q.usedDay) {
+		q.usedDay = day
+		q.usedBytes = 0
+	}
+}
+
+// addUsage counts n transferred bytes against the daily budget.
+func (q *Queue) addUsage(n int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.schedule != nil {
+		q.resetUsage(time.Now())
+	}
+	q.usedBytes += n
+}
+
+func (q *Queue) worker() {
+	defer q.wg.Done()
+
+	for t := q.next(); t != nil; t = q.next() {
+		q.run(t)
+	}
+}
+
+func (q *Queue) run(t *Ticket) {
+	t.setState(JobRunning, nil)
+
+	c, err := q.pool.Get(context.Background())
+	if err != nil {
+		t.setState(JobFailed, err)
+		return
+	}
+
+	before := c.Stats()
+	err = t.job.Run(c)
+	after := c.Stats()
+	q.addUsage(after.BytesUploaded + after.BytesDownloaded - before.BytesUploaded - before.BytesDownloaded)
+	q.pool.Release(c, err)
+
+	if err != nil {
+		t.setState(JobFailed, err)
+	} else {
+		t.setState(JobDone, nil)
+	}
+}
+
+// ticketHeap implements heap.Interface ordering tickets by priority, then by
+// submission order.
+type ticketHeap []*Ticket
+
+func (h ticketHeap) Len() int { return len(h) }
+
+func (h ticketHeap) Less(i, j int) bool {
+	if h[i].job.Priority != h[j].job.Priority {
+		return h[i].job.Priority > h[j].job.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h ticketHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *ticketHeap) Push(x interface{}) { *h = append(*h, x.(*Ticket)) }
+
+func (h *ticketHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	*h = old[:n-1]
+	return t
+}
@@ -0,0 +1,181 @@
+package ftp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newMockPool returns a single-connection pool dialing the mock server
+func newMockPool(t *testing.T) (*ftpMock, *Pool) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewPool(1, func() (*ServerConn, error) {
+		c, err := Dial(mock.Addr())
+		if err != nil {
+			return nil, err
+		}
+		return c, c.Login("anonymous", "anonymous")
+	})
+
+	return mock, pool
+}
+
+func TestQueuePriority(t *testing.T) {
+	mock, pool := newMockPool(t)
+	defer mock.Close()
+
+	q := NewQueue(pool, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.Enqueue(Job{Run: func(c *ServerConn) error {
+		close(started)
+		<-release
+		return nil
+	}})
+	<-started
+
+	var mu sync.Mutex
+	var order []string
+	for _, job := range []Job{
+		{Name: "low", Priority: 1},
+		{Name: "high", Priority: 3},
+		{Name: "medium", Priority: 2},
+		{Name: "high2", Priority: 3},
+	} {
+		name := job.Name
+		job.Run = func(c *ServerConn) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}
+		q.Enqueue(job)
+	}
+
+	close(release)
+	q.Close()
+	pool.Close()
+	mock.Wait()
+
+	expected := []string{"high", "high2", "medium", "low"}
+	for i := range expected {
+		if i >= len(order) || order[i] != expected[i] {
+			t.Fatal("unexpected order:", order, "expected:", expected)
+		}
+	}
+}
+
+func TestQueueTransfers(t *testing.T) {
+	mock, pool := newMockPool(t)
+	defer mock.Close()
+
+	q := NewQueue(pool, 1)
+
+	var states []JobState
+	upload := UploadJob("file", bytes.NewBufferString(testData))
+	upload.OnStatus = func(state JobState, err error) {
+		states = append(states, state)
+	}
+	if err := q.Enqueue(upload).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0] != JobRunning || states[1] != JobDone {
+		t.Error("unexpected states:", states)
+	}
+
+	buf := &bytes.Buffer{}
+	ticket := q.Enqueue(DownloadJob("file", buf))
+	if err := ticket.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if ticket.State() != JobDone {
+		t.Error("unexpected state:", ticket.State())
+	}
+	if buf.String() != testData {
+		t.Errorf("downloaded %q, expected %q", buf.String(), testData)
+	}
+
+	q.Close()
+	if err := q.Enqueue(DownloadJob("file", buf)).Wait(); err != ErrQueueClosed {
+		t.Error("expected ErrQueueClosed, got:", err)
+	}
+
+	pool.Close()
+	mock.Wait()
+
+	expected := []string{"FEAT", "USER", "PASS", "TYPE", "EPSV", "STOR", "EPSV", "RETR", "QUIT"}
+	if !reflect.DeepEqual(mock.commands, expected) {
+		t.Error("unexpected sequence of commands:", mock.commands, "expected:", expected)
+	}
+}
+
+func TestScheduleDelay(t *testing.T) {
+	s := &Schedule{
+		Windows: []TimeWindow{
+			{Start: 22 * time.Hour, End: 6 * time.Hour},
+			{Start: 12 * time.Hour, End: 13 * time.Hour},
+		},
+		DailyBytes: 1000,
+		Location:   time.UTC,
+	}
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 3, 1, hour, min, 0, 0, time.UTC)
+	}
+
+	for _, tc := range []struct {
+		now   time.Time
+		used  int64
+		delay time.Duration
+	}{
+		{at(23, 0), 0, 0},
+		{at(5, 59), 0, 0},
+		{at(6, 0), 0, 6 * time.Hour},
+		{at(12, 30), 999, 0},
+		{at(13, 0), 0, 9 * time.Hour},
+		{at(23, 0), 1000, time.Hour},
+	} {
+		if delay := s.delay(tc.now, tc.used); delay != tc.delay {
+			t.Errorf("%s with %d bytes used: delay %s, expected %s", tc.now.Format("15:04"), tc.used, delay, tc.delay)
+		}
+	}
+}
+
+func TestQueueDailyBytes(t *testing.T) {
+	mock, pool := newMockPool(t)
+	defer mock.Close()
+
+	q := NewQueue(pool, 1)
+	q.SetSchedule(&Schedule{DailyBytes: 1})
+
+	if err := q.Enqueue(UploadJob("file", bytes.NewBufferString(testData))).Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The budget is exhausted until tomorrow
+	ticket := q.Enqueue(DownloadJob("file", ioutil.Discard))
+	select {
+	case <-ticket.Done():
+		t.Fatal("the job ran over budget")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if ticket.State() != JobPending {
+		t.Error("unexpected state:", ticket.State())
+	}
+
+	q.SetSchedule(nil)
+	if err := ticket.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Close()
+	pool.Close()
+	mock.Wait()
+}
@@ -0,0 +1,125 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrNoQuota is returned by Quota when the server reports no quota.
+var ErrNoQuota = errors.New("ftp: no quota reported")
+
+// Quota is the storage quota of the logged in user.
+// The fields are -1 when unknown or unlimited.
+type Quota struct {
+	UsedBytes  int64
+	LimitBytes int64
+	UsedFiles  int64
+	LimitFiles int64
+}
+
+// AvailableBytes returns the number of bytes which can still be stored, or -1
+// if unknown or unlimited.
+func (q *Quota) AvailableBytes() int64 {
+	if q.LimitBytes < 0 || q.UsedBytes < 0 {
+		return -1
+	}
+	if q.UsedBytes > q.LimitBytes {
+		return 0
+	}
+	return q.LimitBytes - q.UsedBytes
+}
+
+// Quota returns the quota of the logged in user, as reported by SITE QUOTA,
+// or QUOT if SITE QUOTA is not supported. The replies of ProFTPD
+// (mod_quotatab), listing the uploaded bytes and files, and of Pure-FTPd are
+// recognized.
+// ErrNoQuota is returned if the reply reports no quota.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) Quota(ctx context.Context) (*Quota, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer c.withContext(ctx)()
+
+	code, msg, err := c.cmd(-1, "SITE QUOTA")
+	if err == nil && code/100 != 2 {
+		code, msg, err = c.cmd(-1, "QUOT")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code/100 != 2 {
+		return nil, newReplyError(code, msg)
+	}
+
+	return parseQuota(msg)
+}
+
+// Pure-FTPd quota messages, in kilobytes
+var (
+	quotaFilesRegexp = regexp.MustCompile(`(\d+) files used \(\d+%\) - authorized: (\d+) files`)
+	quotaSizeRegexp  = regexp.MustCompile(`(\d+) Kb used \(\d+%\) - authorized: (\d+) Kb`)
+)
+
+// parseQuota parses the reply to a quota command.
+func parseQuota(message string) (*Quota, error) {
+	q := &Quota{UsedBytes: -1, LimitBytes: -1, UsedFiles: -1, LimitFiles: -1}
+	found := false
+
+	for _, line := range strings.Split(message, "\n") {
+		if m := quotaFilesRegexp.FindStringSubmatch(line); m != nil {
+			q.UsedFiles, _ = strconv.ParseInt(m[1], 10, 64)
+			q.LimitFiles, _ = strconv.ParseInt(m[2], 10, 64)
+			found = true
+			continue
+		}
+		if m := quotaSizeRegexp.FindStringSubmatch(line); m != nil {
+			used, _ := strconv.ParseInt(m[1], 10, 64)
+			limit, _ := strconv.ParseInt(m[2], 10, 64)
+			q.UsedBytes, q.LimitBytes = used*1024, limit*1024
+			found = true
+			continue
+		}
+
+		// ProFTPD: "  Uploaded bytes:	12345.00/1048576.00"
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		var used, limit *int64
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "uploaded bytes":
+			used, limit = &q.UsedBytes, &q.LimitBytes
+		case "uploaded files":
+			used, limit = &q.UsedFiles, &q.LimitFiles
+		default:
+			continue
+		}
+		found = true
+
+		usedValue, limitValue, ok := strings.Cut(strings.TrimSpace(value), "/")
+		if !ok {
+			// "unlimited"
+			continue
+		}
+		*used = parseQuotaValue(usedValue)
+		*limit = parseQuotaValue(limitValue)
+	}
+
+	if !found {
+		return nil, ErrNoQuota
+	}
+	return q, nil
+}
+
+// parseQuotaValue parses a number such as "12345.00", or returns -1.
+func parseQuotaValue(s string) int64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return -1
+	}
+	return int64(f)
+}
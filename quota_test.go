@@ -0,0 +1,57 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQuotaProFTPD(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"SITE": {"200-The current quota for this session are [current/limit]:\r\n" +
+			"200-Name: anonymous\r\n" +
+			"200-Quota Type: User\r\n" +
+			"200-  Uploaded bytes:\t12345.00/1048576.00\r\n" +
+			"200-  Downloaded bytes:\tunlimited\r\n" +
+			"200-  Uploaded files:\tunlimited\r\n" +
+			"200 Please contact root if these entries are inaccurate"},
+	})
+
+	q, err := c.Quota(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Quota{UsedBytes: 12345, LimitBytes: 1048576, UsedFiles: -1, LimitFiles: -1}
+	if *q != expected {
+		t.Errorf("quota %+v, expected %+v", *q, expected)
+	}
+	if available := q.AvailableBytes(); available != 1048576-12345 {
+		t.Errorf("available %d bytes", available)
+	}
+
+	closeConn(t, mock, c, []string{"SITE"})
+}
+
+func TestQuotaPureFTPd(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"QUOT": {"200-12 files used (1%) - authorized: 1000 files\r\n200 40 Kb used (4%) - authorized: 1000 Kb"},
+	})
+
+	// SITE QUOTA is refused
+	q, err := c.Quota(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Quota{UsedBytes: 40 * 1024, LimitBytes: 1000 * 1024, UsedFiles: 12, LimitFiles: 1000}
+	if *q != expected {
+		t.Errorf("quota %+v, expected %+v", *q, expected)
+	}
+
+	closeConn(t, mock, c, []string{"SITE", "QUOT"})
+}
+
+func TestParseQuotaNone(t *testing.T) {
+	if _, err := parseQuota("No quota for this user"); !errors.Is(err, ErrNoQuota) {
+		t.Errorf("expected ErrNoQuota, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package ftp
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// rateLimitChunk is the maximum size of the reads and writes of a rate
+// limited data connection, smoothing the throughput.
+const rateLimitChunk = 16 * 1024
+
+// RateLimiter limits the throughput of the data transfers sharing it, such as
+// all the transfers of a process, of several ServerConns or pools, see
+// DialWithRateLimiter.
+// It allows bursts of a tenth of a second of data.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second, unlimited if 0
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond, or no limit if
+// bytesPerSecond is 0 or negative.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+// SetRate changes the rate of the limiter, including for the transfers in
+// progress, for instance for the off-peak hours.
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = 0
+	if bytesPerSecond > 0 {
+		l.rate = float64(bytesPerSecond)
+	}
+	l.tokens = l.burst()
+	l.last = time.Now()
+}
+
+// burst returns the maximum number of tokens.
+func (l *RateLimiter) burst() float64 {
+	return l.rate / 10
+}
+
+// reserve takes n bytes from the limiter, and returns the time to wait before
+// moving them.
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if burst := l.burst(); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// DialWithRateLimiter returns a DialOption that limits the throughput of the
+// data transfers of the ServerConn, listings included, with l, which may be
+// shared with other connections. The option may be given several times, for
+// instance for a process-wide limiter and a per-tenant one: the lowest rate
+// applies, as with TransferWithRateLimit.
+func DialWithRateLimiter(l *RateLimiter) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.rateLimiters = append(do.rateLimiters, l)
+	}}
+}
+
+// TransferWithRateLimit returns a TransferOption that limits the throughput
+// of a Retr or Stor transfer to bytesPerSecond, on top of the limiters given
+// to DialWithRateLimiter: the lowest rate applies.
+func TransferWithRateLimit(bytesPerSecond int64) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.rateLimit = bytesPerSecond
+	}}
+}
+
+// limitRate returns conn limited by the limiters, if any. The limiters of a
+// limited conn are completed, so that the lowest rate applies.
+func limitRate(conn net.Conn, limiters ...*RateLimiter) net.Conn {
+	if len(limiters) == 0 {
+		return conn
+	}
+	if limited, ok := conn.(*rateLimitedConn); ok {
+		limited.limiters = append(limited.limiters[:len(limited.limiters):len(limited.limiters)], limiters...)
+		return limited
+	}
+	return &rateLimitedConn{Conn: conn, limiters: limiters, wake: make(chan struct{})}
+}
+
+// rateLimitedConn is a data connection whose reads and writes wait for their
+// limiters. The waits are interrupted by Close and the deadlines.
+type rateLimitedConn struct {
+	net.Conn
+	limiters []*RateLimiter
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	closed        bool
+	wake          chan struct{} // closed when the above change
+}
+
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	if len(p) > rateLimitChunk {
+		p = p[:rateLimitChunk]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if waitErr := c.wait(n, false); err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), rateLimitChunk)]
+		if err := c.wait(len(chunk), true); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait waits for n bytes to be allowed by all the limiters.
+func (c *rateLimitedConn) wait(n int, write bool) error {
+	var delay time.Duration
+	for _, l := range c.limiters {
+		delay = max(delay, l.reserve(n))
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	end := time.Now().Add(delay)
+	for {
+		c.mu.Lock()
+		deadline, closed, wake := c.readDeadline, c.closed, c.wake
+		if write {
+			deadline = c.writeDeadline
+		}
+		c.mu.Unlock()
+
+		now := time.Now()
+		switch {
+		case closed:
+			return net.ErrClosed
+		case !deadline.IsZero() && !now.Before(deadline):
+			return os.ErrDeadlineExceeded
+		case !now.Before(end):
+			return nil
+		}
+
+		wakeAt := end
+		if !deadline.IsZero() && deadline.Before(end) {
+			wakeAt = deadline
+		}
+		timer := time.NewTimer(wakeAt.Sub(now))
+		select {
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		}
+	}
+}
+
+// update applies f to the state, and wakes up the waits.
+func (c *rateLimitedConn) update(f func()) {
+	c.mu.Lock()
+	f()
+	close(c.wake)
+	c.wake = make(chan struct{})
+	c.mu.Unlock()
+}
+
+func (c *rateLimitedConn) Close() error {
+	c.update(func() { c.closed = true })
+	return c.Conn.Close()
+}
+
+func (c *rateLimitedConn) SetDeadline(t time.Time) error {
+	c.update(func() { c.readDeadline, c.writeDeadline = t, t })
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *rateLimitedConn) SetReadDeadline(t time.Time) error {
+	c.update(func() { c.readDeadline = t })
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *rateLimitedConn) SetWriteDeadline(t time.Time) error {
+	c.update(func() { c.writeDeadline = t })
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,90 @@
+package ftp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := NewRateLimiter(1000)
+
+	// A burst of a tenth of a second is allowed
+	if d := l.reserve(100); d != 0 {
+		t.Errorf("waiting %s for the burst", d)
+	}
+	if d := l.reserve(500); d < 400*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("waiting %s, expected about 500ms", d)
+	}
+
+	l.SetRate(0)
+	if d := l.reserve(1 << 30); d != 0 {
+		t.Errorf("waiting %s without limit", d)
+	}
+}
+
+func TestRateLimitCompose(t *testing.T) {
+	const size = 2000
+
+	for _, tc := range []struct {
+		name     string
+		dialRate int64
+		rate     int64
+	}{
+		{"transfer", 1 << 30, 10000},
+		{"dial", 10000, 1 << 30},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, c := openConn(t, "127.0.0.1", DialWithRateLimiter(NewRateLimiter(tc.dialRate)))
+
+			start := time.Now()
+			data := bytes.Repeat([]byte("x"), size)
+			if _, err := c.Stor("file", bytes.NewReader(data), TransferWithRateLimit(tc.rate)); err != nil {
+				t.Fatal(err)
+			}
+
+			// The burst of 1000 bytes is immediate, the rest takes 100ms
+			if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+				t.Errorf("transfer took %s, expected at least 100ms", elapsed)
+			}
+
+			closeConn(t, mock, c, []string{"EPSV", "STOR"})
+		})
+	}
+}
+
+func TestRateLimitDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn := limitRate(client, NewRateLimiter(1))
+	defer conn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("some data"))
+		errs <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	conn.SetDeadline(time.Now())
+	select {
+	case err := <-errs:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("expected os.ErrDeadlineExceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the wait was not interrupted")
+	}
+}
@@ -0,0 +1,169 @@
+package ftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxReplySize is the maximum size of the message of a reply, unless
+// DialWithMaxReplySize is given.
+const DefaultMaxReplySize = 1 << 20
+
+// DialWithMaxReplySize returns a DialOption that configures the maximum size
+// in bytes of the message of the replies read by the ServerConn, such as the
+// huge replies to FEAT or STAT of some servers. The end of a longer reply is
+// skipped, and the command fails with a *ReplyTooLongError, except FEAT whose
+// truncated reply is used. A negative size disables the limit.
+func DialWithMaxReplySize(size int) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.maxReplySize = size
+	}}
+}
+
+// ReplyTooLongError is returned for a reply longer than the maximum size given
+// to DialWithMaxReplySize. The truncated message is returned with the error by
+// the commands returning the message of the reply.
+type ReplyTooLongError struct {
+	Code int
+	Max  int
+}
+
+func (e *ReplyTooLongError) Error() string {
+	return fmt.Sprintf("ftp: %d reply longer than %d bytes", e.Code, e.Max)
+}
+
+// readReply reads a reply, like textproto.Reader.ReadResponse, truncating
+// its message to the maximum reply size.
+func (c *ServerConn) readReply(expected int) (int, string, error) {
+	limit := c.options.maxReplySize
+	if limit == 0 {
+		limit = DefaultMaxReplySize
+	}
+	rr := &replyReader{r: c.conn.R, limit: limit}
+	return rr.read(expected)
+}
+
+// replyReader reads a reply, up to a maximum message size.
+type replyReader struct {
+	r         *bufio.Reader
+	limit     int // no limit if negative
+	message   strings.Builder
+	truncated bool
+}
+
+func (rr *replyReader) read(expected int) (int, string, error) {
+	line, err := rr.readLine()
+	if err != nil {
+		return 0, "", err
+	}
+	code, continued, message, err := parseReplyLine(line)
+	if err != nil {
+		return 0, "", err
+	}
+	rr.add(message)
+
+	// Lines not starting with the code are part of the message
+	for continued {
+		line, err := rr.readLine()
+		if err != nil {
+			return 0, "", err
+		}
+
+		code2, more, moreMessage, err := parseReplyLine(line)
+		if err != nil || code2 != code {
+			rr.add("\n" + line)
+			continue
+		}
+		continued = more
+		rr.add("\n" + moreMessage)
+	}
+
+	message = rr.message.String()
+	if !isExpectedCode(code, expected) {
+		return code, message, newReplyError(code, message)
+	}
+	if rr.truncated {
+		return code, message, &ReplyTooLongError{Code: code, Max: rr.limit}
+	}
+	return code, message, nil
+}
+
+// readLine reads a line without its end. Once the message is full, only the
+// beginning of the line is kept, enough to find the end of the reply.
+func (rr *replyReader) readLine() (string, error) {
+	keep := -1
+	if rr.limit >= 0 {
+		keep = len("200 ") + max(rr.limit-rr.message.Len(), 0) + len("\r\n")
+	}
+
+	var line []byte
+	var read bool
+	for {
+		chunk, err := rr.r.ReadSlice('\n')
+		read = read || len(chunk) > 0
+		if keep >= 0 && len(line)+len(chunk) > keep {
+			chunk = chunk[:max(keep-len(line), 0)]
+			rr.truncated = true
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && !(err == io.EOF && read) {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// add appends s to the message, up to the limit.
+func (rr *replyReader) add(s string) {
+	if rr.limit >= 0 && rr.message.Len()+len(s) > rr.limit {
+		s = s[:max(rr.limit-rr.message.Len(), 0)]
+		rr.truncated = true
+	}
+	rr.message.WriteString(s)
+}
+
+// parseReplyLine parses a line of a reply, as "code message" or
+// "code-message" if the reply continues.
+func parseReplyLine(line string) (code int, continued bool, message string, err error) {
+	if len(line) < 4 || line[3] != ' ' && line[3] != '-' {
+		return 0, false, "", textproto.ProtocolError("short response: " + line)
+	}
+	code, err = strconv.Atoi(line[0:3])
+	if err != nil || code < 100 {
+		return 0, false, "", textproto.ProtocolError("invalid response code: " + line)
+	}
+	return code, line[3] == '-', line[4:], nil
+}
+
+// isExpectedCode reports whether code is expected, as for
+// textproto.Reader.ReadResponse: expected is a full code, or its first one or
+// two digits, or negative for any code.
+func isExpectedCode(code, expected int) bool {
+	switch {
+	case 1 <= expected && expected < 10:
+		return code/100 == expected
+	case 10 <= expected && expected < 100:
+		return code/10 == expected
+	case 100 <= expected && expected < 1000:
+		return code == expected
+	}
+	return true
+}
+
+// isReplyError reports whether err is about a reply which was read entirely,
+// leaving the control connection usable.
+func isReplyError(err error) bool {
+	switch err.(type) {
+	case *ReplyError, *ReplyTooLongError:
+		return true
+	}
+	return false
+}
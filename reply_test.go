@@ -0,0 +1,82 @@
+package ftp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestReplyReader(t *testing.T) {
+	for _, tc := range []struct {
+		input     string
+		limit     int
+		code      int
+		message   string
+		truncated bool
+	}{
+		{"200 OK\r\n", -1, 200, "OK", false},
+		{"211-Features:\r\n MDTM\r\n211 End\r\n", -1, 211, "Features:\n MDTM\nEnd", false},
+		{"211-Features:\r\n MDTM\r\n211 End\r\n", 18, 211, "Features:\n MDTM\nEn", true},
+		{"211-Features:\r\n MDTM\r\n211 End\r\n", 19, 211, "Features:\n MDTM\nEnd", false},
+		{"230-Welcome\r\n220 not the end\r\n230 Logged in\r\n", -1, 230, "Welcome\n220 not the end\nLogged in", false},
+		{"200 " + strings.Repeat("x", 10000) + "\r\n", 100, 200, strings.Repeat("x", 100), true},
+	} {
+		r := bufio.NewReaderSize(strings.NewReader(tc.input+"250 next\r\n"), 16)
+		rr := &replyReader{r: r, limit: tc.limit}
+		code, message, err := rr.read(-1)
+		var tooLong *ReplyTooLongError
+		if tc.truncated != errors.As(err, &tooLong) || (err != nil && !tc.truncated) {
+			t.Errorf("%q: unexpected error %v", tc.input, err)
+		}
+		if code != tc.code || message != tc.message {
+			t.Errorf("%q: read %d %q, expected %d %q", tc.input, code, message, tc.code, tc.message)
+		}
+
+		// The whole reply was read
+		rr = &replyReader{r: r, limit: tc.limit}
+		if code, _, err := rr.read(250); code != 250 || err != nil {
+			t.Errorf("%q: next reply %d, %v", tc.input, code, err)
+		}
+	}
+
+	rr := &replyReader{r: bufio.NewReader(strings.NewReader("no code\r\n")), limit: -1}
+	if _, _, err := rr.read(-1); !errors.As(err, new(textproto.ProtocolError)) {
+		t.Errorf("unexpected error %v", err)
+	}
+	rr = &replyReader{r: bufio.NewReader(strings.NewReader("211-truncated\r\n")), limit: -1}
+	if _, _, err := rr.read(-1); err != io.EOF {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestReplyTooLong(t *testing.T) {
+	feat := "211-Features:\r\n MDTM\r\n" + strings.Repeat(" X-PADDING\r\n", 100) + " SIZE\r\n211 End"
+	mock, c := openConnReplies(t, map[string][]string{
+		"FEAT": {feat},
+		"STAT": {"211-Status:\r\n" + strings.Repeat("padding\r\n", 100) + "211 End"},
+	}, DialWithMaxReplySize(64))
+
+	// The truncated reply to FEAT is used
+	if !c.HasFeature("MDTM") || c.HasFeature("SIZE") {
+		t.Errorf("unexpected features %v", c.Features())
+	}
+
+	code, message, err := c.cmd(-1, "STAT")
+	var tooLong *ReplyTooLongError
+	if !errors.As(err, &tooLong) || tooLong.Code != StatusSystem || tooLong.Max != 64 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if code != StatusSystem || len(message) != 64 || !strings.HasPrefix(message, "Status:\npadding\n") {
+		t.Errorf("unexpected reply %d %q", code, message)
+	}
+
+	// The whole reply was read
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"STAT", "NOOP"})
+}
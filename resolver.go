@@ -0,0 +1,113 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Endpoint is an address of a FTP server found by a Resolver.
+type Endpoint struct {
+	// Addr is the host:port to dial.
+	Addr string
+
+	// TLS enables implicit TLS, with a default config if none was given to
+	// DialWithTLS.
+	TLS bool
+}
+
+// Resolver returns the endpoints of the server named by the address given to
+// Dial, in order of preference.
+type Resolver func(ctx context.Context, name string) ([]Endpoint, error)
+
+// DialWithResolver returns a DialOption that configures Dial to look up the
+// endpoints of the address with resolver, and to connect to the first one
+// available, as DialMulti does.
+// The endpoint is resolved once: the automatic reconnections reuse it.
+func DialWithResolver(resolver Resolver) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.resolver = resolver
+	}}
+}
+
+// SRVResolver returns a Resolver looking up the SRV records of the name given
+// to Dial with r, or net.DefaultResolver if nil: the _ftps._tcp records, for
+// implicit TLS, are preferred to the _ftp._tcp ones.
+// If there are no records, the name itself is dialed, on port 21 by default.
+func SRVResolver(r *net.Resolver) Resolver {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+
+	return func(ctx context.Context, name string) ([]Endpoint, error) {
+		host := hostname(name)
+
+		var endpoints []Endpoint
+		for _, service := range []string{"ftps", "ftp"} {
+			_, records, err := r.LookupSRV(ctx, service, "tcp", host)
+			var dnsErr *net.DNSError
+			if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+				return nil, err
+			}
+
+			for _, record := range records {
+				endpoints = append(endpoints, Endpoint{
+					Addr: net.JoinHostPort(record.Target, fmt.Sprint(record.Port)),
+					TLS:  service == "ftps",
+				})
+			}
+		}
+
+		if len(endpoints) == 0 {
+			if host == name {
+				name = net.JoinHostPort(host, "21")
+			}
+			endpoints = append(endpoints, Endpoint{Addr: name})
+		}
+		return endpoints, nil
+	}
+}
+
+// dialResolved connects to the first available endpoint of addr.
+func dialResolved(addr string, do *dialOptions) (*ServerConn, error) {
+	ctx := do.context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	endpoints, err := do.resolver(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, endpoint := range endpoints {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		edo := *do
+		edo.resolver = nil
+		if endpoint.TLS && edo.tlsConfig == nil {
+			edo.tlsConfig = &tls.Config{}
+		}
+
+		c, err := dial(endpoint.Addr, &edo)
+		if err == nil {
+			return c, nil
+		}
+
+		err = fmt.Errorf("%s: %w", endpoint.Addr, err)
+		if errors.Is(err, ErrNotLoggedIn) {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("ftp: no endpoint found for %s", addr)
+	}
+	return nil, errors.Join(errs...)
+}
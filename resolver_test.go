@@ -0,0 +1,48 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestDialWithResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := l.Addr().String()
+	l.Close()
+
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	resolver := func(ctx context.Context, name string) ([]Endpoint, error) {
+		if name != "ftp.example.com" {
+			t.Errorf("unexpected name %q", name)
+		}
+		return []Endpoint{{Addr: unreachable}, {Addr: mock.Addr()}}, nil
+	}
+
+	c, err := Dial("ftp.example.com", DialWithResolver(resolver), DialWithLogin("anonymous", "anonymous"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, nil)
+}
+
+func TestDialWithResolverError(t *testing.T) {
+	errLookup := errors.New("lookup failed")
+	resolver := func(ctx context.Context, name string) ([]Endpoint, error) {
+		return nil, errLookup
+	}
+
+	if _, err := Dial("ftp.example.com", DialWithResolver(resolver)); !errors.Is(err, errLookup) {
+		t.Errorf("expected the lookup error, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package ftp
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// RetryPolicy describes how failed commands and transfers are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	MaxAttempts int
+
+	// Backoff returns the delay to wait before the given retry, starting at 1.
+	// No delay is applied if nil. The end of the context of the operation
+	// interrupts the wait, and its error is returned.
+	Backoff func(retry int) time.Duration
+
+	// Retryable reports whether a failed attempt may be retried.
+	// DefaultRetryable is used if nil.
+	Retryable func(err error) bool
+}
+
+// DialWithRetryPolicy returns a DialOption that configures the ServerConn to
+// retry failed commands and the opening of data transfers according to the
+// given policy.
+// Connection errors are only retried when DialWithAutoReconnect is enabled,
+// as the control connection must be re-established first.
+func DialWithRetryPolicy(policy RetryPolicy) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.retryPolicy = &policy
+	}}
+}
+
+// DefaultRetryable reports whether err is a transient failure: a 4xx reply,
+// except 421 which closes the connection, or a connection error.
+func DefaultRetryable(err error) bool {
+	if code := ReplyCode(err); code != 0 && code != StatusNotAvailable {
+		return IsTemporary(err)
+	}
+	return isNetworkError(err)
+}
+
+// ExponentialBackoff returns a Backoff function doubling the delay after each
+// retry, starting at base and capped at max.
+func ExponentialBackoff(base, max time.Duration) func(retry int) time.Duration {
+	return func(retry int) time.Duration {
+		d := base
+		for i := 1; i < retry && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// replayableCommands are the commands replayed once the lost control
+// connection is restored: the server may have performed the lost attempt, which
+// does not change their effect. The data commands are only replayed before
+// any data is transferred.
+var replayableCommands = map[string]bool{
+	"CDUP": true,
+	"CWD":  true,
+	"FEAT": true,
+	"LIST": true,
+	"MDTM": true,
+	"MLSD": true,
+	"MLST": true,
+	"NLST": true,
+	"NOOP": true,
+	"PWD":  true,
+	"RETR": true,
+	"SIZE": true,
+	"STAT": true,
+	"SYST": true,
+	"TYPE": true,
+}
+
+// replayable reports whether the command formatted by format may be replayed
+// after the control connection was lost.
+func replayable(format string) bool {
+	verb, _, _ := strings.Cut(format, " ")
+	return replayableCommands[strings.ToUpper(verb)]
+}
+
+// withRetry runs f and retries it according to the retry policy.
+// If f failed because the control connection was lost and auto-reconnect is
+// enabled, the connection is restored before retrying. f is then only
+// replayed if replay is set, or if it did not send any command: otherwise the
+// error is returned once the connection is restored.
+// Commands issued by f are not retried individually.
+func (c *ServerConn) withRetry(replay bool, f func() error) error {
+	policy := c.options.retryPolicy
+	if c.inRetry || (policy == nil && !c.options.autoReconnect) {
+		return f()
+	}
+
+	c.inRetry = true
+	defer func() { c.inRetry = false }()
+
+	maxAttempts := 1
+	if c.options.autoReconnect {
+		maxAttempts = 2
+	}
+	if policy != nil && policy.MaxAttempts > maxAttempts {
+		maxAttempts = policy.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		sent := c.sent
+		err := f()
+		if err == nil || c.closed {
+			return err
+		}
+
+		connErr := c.isConnectionError(err)
+		if connErr && !c.options.autoReconnect {
+			return err
+		}
+		if connErr && !replay && c.sent != sent {
+			// The server may have performed the command: the connection is
+			// only restored for the next commands
+			if err := c.reconnect(); err != nil {
+				return err
+			}
+			return err
+		}
+		if attempt >= maxAttempts || (!connErr && !policy.retryable(err)) {
+			return err
+		}
+
+		if policy != nil && policy.Backoff != nil {
+			if err := c.sleep(policy.Backoff(attempt)); err != nil {
+				return err
+			}
+		}
+
+		if connErr {
+			if err := c.reconnect(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sleep waits for d, or returns the error of the context of the current
+// operation if it ends first.
+func (c *ServerConn) sleep(d time.Duration) error {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryable reports whether err may be retried under the policy.
+func (p *RetryPolicy) retryable(err error) bool {
+	if p == nil {
+		return false
+	}
+	if p.Retryable == nil {
+		return DefaultRetryable(err)
+	}
+	return p.Retryable(err)
+}
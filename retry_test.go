@@ -0,0 +1,169 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryTransient(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithRetryPolicy(RetryPolicy{MaxAttempts: 3}))
+	mock.cannedReplies = map[string][]string{
+		"DELE": {"450 File busy", "450 File busy"},
+	}
+
+	_, err := c.Delete("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"DELE", "DELE", "DELE"})
+}
+
+func TestRetryExhausted(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+	mock.cannedReplies = map[string][]string{
+		"DELE": {"450 File busy", "450 File busy"},
+	}
+
+	_, err := c.Delete("file")
+	if protoErr, ok := err.(*ReplyError); !ok || protoErr.Code != StatusFileActionIgnored {
+		t.Fatal("expected 450 error, got:", err)
+	}
+
+	closeConn(t, mock, c, []string{"DELE", "DELE"})
+}
+
+func TestRetryPermanent(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithRetryPolicy(RetryPolicy{MaxAttempts: 3}))
+	mock.cannedReplies = map[string][]string{
+		"DELE": {"550 No such file"},
+	}
+
+	_, err := c.Delete("file")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	closeConn(t, mock, c, []string{"DELE"})
+}
+
+func TestRetryBackoffCanceled(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(retry int) time.Duration { return time.Minute },
+	}
+	mock, c := openConn(t, "127.0.0.1", DialWithRetryPolicy(policy))
+	mock.cannedReplies = map[string][]string{
+		"OPTS": {"450 Busy"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Opts(ctx, "HASH", "SHA-256"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the backoff ignored the context, took %s", elapsed)
+	}
+
+	closeConn(t, mock, c, []string{"OPTS"})
+}
+
+func TestRetryStor(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+	mock.cannedReplies = map[string][]string{
+		"STOR": {"452 Insufficient storage"},
+	}
+
+	_, err := c.Stor("file", bytes.NewReader([]byte(testData)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "EPSV", "STOR"})
+}
+
+// dialDropping returns a connection, with auto-reconnect, to a mock dropping
+// the control connection on the first command sent with the given verb.
+func dialDropping(t *testing.T, command string) (*ftpMock, *ServerConn) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.dropCommand = command
+
+	c, err := Dial(mock.Addr(), DialWithAutoReconnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	return mock, c
+}
+
+func TestReconnectNotReplayed(t *testing.T) {
+	mock, c := dialDropping(t, "DELE")
+	defer mock.Close()
+
+	// The file may have been deleted: DELE is not sent again
+	if _, err := c.Delete("file"); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+
+	// The connection is restored for the next commands
+	if _, err := c.Delete("file"); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"DELE", "FEAT", "USER", "PASS", "TYPE", "DELE"})
+}
+
+func TestReconnectRename(t *testing.T) {
+	mock, c := dialDropping(t, "RNTO")
+	defer mock.Close()
+
+	if _, err := c.Rename("from", "to"); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"RNFR", "RNTO", "FEAT", "USER", "PASS", "TYPE", "RNFR", "RNTO"})
+}
+
+func TestConnectionErrorDataConn(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	control := &net.OpError{Op: "read", Net: "tcp", Source: c.netConn.LocalAddr(), Addr: c.netConn.RemoteAddr(), Err: syscall.ECONNRESET}
+	if !c.isConnectionError(control) {
+		t.Error("expected an error of the control connection to be a connection error")
+	}
+	if !c.isConnectionError(fmt.Errorf("%w: %w", ErrConnectionBroken, control)) {
+		t.Error("expected ErrConnectionBroken to be a connection error")
+	}
+
+	data := &net.OpError{Op: "dial", Net: "tcp", Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, Err: syscall.ECONNREFUSED}
+	if c.isConnectionError(data) {
+		t.Error("expected an error of a data connection not to be a connection error")
+	}
+	if !DefaultRetryable(data) {
+		t.Error("expected an error of a data connection to be retryable")
+	}
+
+	closeConn(t, mock, c, nil)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 5*time.Second)
+
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := backoff(retry + 1); d != expected {
+			t.Errorf("retry %d: got %s, expected %s", retry+1, d, expected)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package ftp
+
+import (
+	"time"
+)
+
+// TimeWindow is a daily period of time, given as offsets from midnight.
+// A window whose End is before its Start spans midnight, such as 22:00 to
+// 06:00.
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// contains reports whether offset, from midnight, is within the window.
+func (w TimeWindow) contains(offset time.Duration) bool {
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Schedule restricts when the jobs of a Queue start, see Queue.SetSchedule.
+// Running jobs are never interrupted: a mirror made of one job per file
+// pauses between two files.
+type Schedule struct {
+	// Windows are the daily periods in which jobs may start. Jobs may
+	// start at any time if empty.
+	Windows []TimeWindow
+
+	// DailyBytes is the number of bytes the jobs may transfer per day,
+	// unlimited if 0 or negative. Once it is exceeded, the jobs wait for the
+	// next day.
+	DailyBytes int64
+
+	// Location is the time zone of the windows and days, time.Local if nil.
+	Location *time.Location
+}
+
+// location returns the time zone of the schedule.
+func (s *Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+// day returns the midnight starting the day of t.
+func (s *Schedule) day(t time.Time) time.Time {
+	t = t.In(s.location())
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// delay returns the time to wait from now before a job may start, used bytes
+// having been transferred since the start of the day.
+func (s *Schedule) delay(now time.Time, used int64) time.Duration {
+	midnight := s.day(now)
+	if s.DailyBytes > 0 && used >= s.DailyBytes {
+		return midnight.AddDate(0, 0, 1).Sub(now)
+	}
+	if len(s.Windows) == 0 {
+		return 0
+	}
+
+	offset := now.Sub(midnight)
+	var delay time.Duration
+	for i, w := range s.Windows {
+		if w.contains(offset) {
+			return 0
+		}
+		start := midnight.Add(w.Start)
+		if !start.After(now) {
+			start = midnight.AddDate(0, 0, 1).Add(w.Start)
+		}
+		if d := start.Sub(now); i == 0 || d < delay {
+			delay = d
+		}
+	}
+	return delay
+}
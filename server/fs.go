@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteFS is a file system which the clients can modify. A Server backed by a
+// plain fs.FS is read-only.
+// The names are the ones of fs.FS: slash-separated, unrooted paths.
+type WriteFS interface {
+	fs.FS
+
+	// OpenFile opens the named file for writing, as os.OpenFile, with the
+	// flags os.O_WRONLY, os.O_CREATE, os.O_TRUNC and os.O_APPEND.
+	// Resuming an upload at an offset requires the returned file to
+	// implement io.Seeker.
+	OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error)
+
+	// Remove removes the named file or empty directory.
+	Remove(name string) error
+
+	// Mkdir creates the named directory.
+	Mkdir(name string, perm fs.FileMode) error
+
+	// Rename renames the file or directory oldname to newname.
+	Rename(oldname, newname string) error
+}
+
+// ChtimesFS is a WriteFS which can set the modification time of its files,
+// enabling MFMT.
+type ChtimesFS interface {
+	WriteFS
+
+	// Chtimes sets the modification time of the named file.
+	Chtimes(name string, mtime time.Time) error
+}
+
+// DirFS returns a WriteFS, also a ChtimesFS, on the tree of files rooted at
+// the directory dir, as os.DirFS does.
+func DirFS(dir string) WriteFS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	return os.DirFS(string(dir)).Open(name)
+}
+
+func (dir dirFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(os.DirFS(string(dir)), name)
+}
+
+func (dir dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(os.DirFS(string(dir)), name)
+}
+
+func (dir dirFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	p, err := dir.join("openfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, flag, perm)
+}
+
+func (dir dirFS) Remove(name string) error {
+	p, err := dir.join("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (dir dirFS) Mkdir(name string, perm fs.FileMode) error {
+	p, err := dir.join("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(p, perm)
+}
+
+func (dir dirFS) Rename(oldname, newname string) error {
+	from, err := dir.join("rename", oldname)
+	if err != nil {
+		return err
+	}
+	to, err := dir.join("rename", newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+func (dir dirFS) Chtimes(name string, mtime time.Time) error {
+	p, err := dir.join("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, time.Time{}, mtime)
+}
+
+// join returns the path of the named file, which must be valid and not the
+// root. fs.ValidPath accepts the backslashes, which separate the elements on
+// Windows: the local path must not escape the directory either.
+func (dir dirFS) join(op, name string) (string, error) {
+	p := filepath.FromSlash(name)
+	if !fs.ValidPath(name) || name == "." || !filepath.IsLocal(p) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(dir), p), nil
+}
+
+// errReadOnly is reported for the modifications of a plain fs.FS.
+var errReadOnly = errors.New("read-only file system")
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Command is a command received by a Server, see Server.Hook.
+type Command struct {
+	// Verb is the command, in upper case, such as "RETR".
+	Verb string
+
+	// Arg is the argument of the command, as sent by the client.
+	Arg string
+
+	// Path is the absolute path of the file named by the commands on files,
+	// such as RETR or LIST, and empty for the other commands.
+	Path string
+}
+
+// Action alters how a Server handles a command, see Server.Hook.
+// The zero value executes the command normally.
+type Action struct {
+	// Delay postpones the execution of the command.
+	Delay time.Duration
+
+	// Reply, if not empty, is sent instead of executing the command, such as
+	// "451 Local error" or a nonstandard code. After a 421 reply, the session
+	// is closed.
+	Reply string
+
+	// Listing, if not nil, is sent by LIST, NLST and MLSD instead of the
+	// listing of the files, to mimic the formats of other servers.
+	Listing []byte
+
+	// DropData closes the data connection after DataBytes bytes were sent or
+	// received, and fails the transfer with a 426 reply.
+	DropData bool
+
+	// TruncateData closes the data connection after DataBytes bytes were sent
+	// or received, but completes the transfer with a 226 reply, as servers
+	// truncating their listings.
+	TruncateData bool
+
+	// DataBytes is the length of the data transferred before DropData or
+	// TruncateData apply.
+	DataBytes int64
+}
+
+// errDataDropped fails the transfers cut by Action.DropData.
+var errDataDropped = errors.New("data connection closed")
+
+// commandPath returns the absolute path named by the argument of a command
+// on files, or "".
+func (ss *session) commandPath(verb, arg string) string {
+	switch verb {
+	case "LIST", "NLST", "MLSD":
+		return ss.abs(listPath(arg))
+	case "MFMT":
+		_, name, _ := strings.Cut(arg, " ")
+		return ss.abs(name)
+	case "CWD", "MLST", "RETR", "STOR", "APPE", "SIZE", "MDTM",
+		"DELE", "MKD", "XMKD", "RMD", "XRMD", "RNFR", "RNTO":
+		return ss.abs(arg)
+	}
+	return ""
+}
+
+// applyHook calls Server.Hook for a command, waits for the delay of the
+// action, and returns the reply to send instead of executing the command, if
+// any.
+func (ss *session) applyHook(verb, arg string) string {
+	ss.action = Action{}
+	if ss.s.Hook == nil {
+		return ""
+	}
+	ss.action = ss.s.Hook(Command{Verb: verb, Arg: arg, Path: ss.commandPath(verb, arg)})
+	time.Sleep(ss.action.Delay)
+	return ss.action.Reply
+}
+
+// dataLimit returns the number of bytes transferred before the action of the
+// command closes the data connection, or -1.
+func (ss *session) dataLimit() int64 {
+	if ss.action.DropData || ss.action.TruncateData {
+		return ss.action.DataBytes
+	}
+	return -1
+}
+
+// sendData copies r to the data connection, within the limit of the action.
+func (ss *session) sendData(conn net.Conn, r io.Reader) error {
+	if n := ss.dataLimit(); n >= 0 {
+		r = io.LimitReader(r, n)
+	}
+	if _, err := io.Copy(conn, r); err != nil {
+		return err
+	}
+	if ss.action.DropData {
+		return errDataDropped
+	}
+	return nil
+}
+
+// receiveData copies the data connection to w, within the limit of the
+// action.
+func (ss *session) receiveData(w io.Writer, conn net.Conn) error {
+	var r io.Reader = conn
+	if n := ss.dataLimit(); n >= 0 {
+		r = io.LimitReader(conn, n)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if ss.action.DropData {
+		return errDataDropped
+	}
+	return nil
+}
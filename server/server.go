@@ -0,0 +1,900 @@
+// Package server implements an embedded FTP server, serving a fs.FS.
+//
+// It supports the commands of RFC 959, the extensions of RFC 3659 (MLSD, MLST,
+// SIZE, MDTM, REST STREAM), MFMT, passive (PASV and EPSV) and active (PORT and
+// EPRT) data connections, and FTPS, both explicit with AUTH TLS and implicit
+// with ListenAndServeTLS. The active data connections are only made to the
+// host of the client, against the bounce attacks of RFC 2577.
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snus8bit/ftp"
+)
+
+// statusAuthOK is the reply accepting AUTH TLS, defined in RFC 4217.
+const statusAuthOK = 234
+
+// statusBadProtocol is the reply to EPRT for an unsupported network protocol,
+// defined in RFC 2428.
+const statusBadProtocol = 522
+
+// dataTimeout bounds the wait for the data connections of the clients.
+const dataTimeout = 30 * time.Second
+
+// ErrServerClosed is returned by Serve after a call to Close.
+var ErrServerClosed = errors.New("server: closed")
+
+// Server is an FTP server serving the files of FS.
+// The fields must not be modified once the server is started.
+type Server struct {
+	// FS holds the served files. The clients can only modify a WriteFS, once
+	// authenticated by Auth.
+	FS fs.FS
+
+	// Auth, if not nil, reports whether the credentials of a user are valid.
+	// If nil, only the anonymous users, "anonymous" and "ftp", are accepted,
+	// with any password, and the files are read-only.
+	Auth func(user, password string) bool
+
+	// TLSConfig, if not nil, enables AUTH TLS and the protection of the data
+	// connections with PROT P. It is required by ListenAndServeTLS.
+	TLSConfig *tls.Config
+
+	// RequireTLS refuses the logins on control connections without TLS.
+	RequireTLS bool
+
+	// Hook, if not nil, is called with each command received, before it is
+	// executed, and returns how to handle it, such as to inject failures in
+	// tests. It is called concurrently by the sessions.
+	Hook func(cmd Command) Action
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS listens on the TCP address addr and serves the
+// connections with implicit TLS, using TLSConfig.
+func (s *Server) ListenAndServeTLS(addr string) error {
+	if s.TLSConfig == nil {
+		return errors.New("server: no TLSConfig")
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(tls.NewListener(l, s.TLSConfig))
+}
+
+// Serve accepts the connections of l, serving each of them in a new
+// goroutine, until l fails or the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			newSession(s, conn).serve()
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the listeners and closes the connections, then waits for the
+// sessions to end.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// session is the state of a control connection.
+type session struct {
+	s        *Server
+	conn     net.Conn
+	text     *textproto.Conn
+	tls      bool // on the control connection
+	protect  bool // set by PROT P
+	user     string
+	loggedIn bool
+	cwd      string
+	rest     int64
+	renaming string
+	passive  net.Listener
+	active   *net.TCPAddr // set by PORT and EPRT
+	action   Action       // of the current command, see Server.Hook
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	_, isTLS := conn.(*tls.Conn)
+	return &session{
+		s:       s,
+		conn:    conn,
+		text:    textproto.NewConn(conn),
+		tls:     isTLS,
+		protect: isTLS,
+		cwd:     "/",
+	}
+}
+
+func (ss *session) reply(code int, format string, args ...interface{}) {
+	ss.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (ss *session) serve() {
+	defer ss.conn.Close()
+	defer ss.resetData()
+
+	ss.reply(ftp.StatusReady, "Service ready")
+	for {
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		if reply := ss.applyHook(verb, arg); reply != "" {
+			ss.resetData()
+			ss.text.PrintfLine("%s", reply)
+			if strings.HasPrefix(reply, "421") {
+				return
+			}
+			continue
+		}
+
+		if !ss.handle(verb, arg) {
+			return
+		}
+	}
+}
+
+// handle executes a command, and reports whether the session goes on.
+func (ss *session) handle(verb, arg string) bool {
+	switch verb {
+	case "QUIT":
+		ss.reply(ftp.StatusClosing, "Goodbye")
+		return false
+	case "AUTH":
+		return ss.auth(arg)
+	case "FEAT":
+		ss.feat()
+	case "USER":
+		ss.user = arg
+		ss.loggedIn = false
+		if ss.s.RequireTLS && !ss.tls {
+			ss.reply(ftp.StatusNotLoggedIn, "TLS required")
+		} else {
+			ss.reply(ftp.StatusUserOK, "Password required for %s", arg)
+		}
+	case "PASS":
+		ss.pass(arg)
+	case "PBSZ":
+		ss.reply(ftp.StatusCommandOK, "PBSZ=0")
+	case "PROT":
+		ss.prot(arg)
+	case "SYST":
+		ss.reply(ftp.StatusName, "UNIX Type: L8")
+	case "NOOP":
+		ss.reply(ftp.StatusCommandOK, "NOOP ok")
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			ss.reply(ftp.StatusCommandOK, "UTF8 set to on")
+		} else {
+			ss.reply(ftp.StatusBadArguments, "Option not understood")
+		}
+	default:
+		if !ss.loggedIn {
+			ss.reply(ftp.StatusNotLoggedIn, "Please login with USER and PASS")
+			return true
+		}
+		ss.handleFiles(verb, arg)
+	}
+	return true
+}
+
+// auth upgrades the control connection to TLS.
+func (ss *session) auth(arg string) bool {
+	if ss.s.TLSConfig == nil || ss.tls {
+		ss.reply(ftp.StatusNotImplemented, "AUTH not available")
+		return true
+	}
+	if mech := strings.ToUpper(arg); mech != "TLS" && mech != "SSL" && mech != "TLS-C" {
+		ss.reply(ftp.StatusNotImplementedParameter, "Unsupported mechanism %s", arg)
+		return true
+	}
+
+	ss.reply(statusAuthOK, "AUTH %s successful", arg)
+	conn := tls.Server(ss.conn, ss.s.TLSConfig)
+	if err := conn.Handshake(); err != nil {
+		return false
+	}
+	ss.conn = conn
+	ss.text = textproto.NewConn(conn)
+	ss.tls = true
+	ss.user, ss.loggedIn = "", false
+	return true
+}
+
+func (ss *session) prot(arg string) {
+	switch strings.ToUpper(arg) {
+	case "C":
+		ss.protect = false
+		ss.reply(ftp.StatusCommandOK, "Protection level set to C")
+	case "P":
+		if !ss.tls {
+			ss.reply(ftp.StatusBadSequence, "PROT P requires TLS")
+			return
+		}
+		ss.protect = true
+		ss.reply(ftp.StatusCommandOK, "Protection level set to P")
+	default:
+		ss.reply(ftp.StatusNotImplementedParameter, "Unsupported protection level %s", arg)
+	}
+}
+
+func (ss *session) feat() {
+	features := []string{"EPRT", "EPSV", "PASV", "SIZE", "MDTM", "MLST type*;size*;modify*;unix.mode*;", "REST STREAM", "UTF8"}
+	if _, ok := ss.s.FS.(ChtimesFS); ok {
+		features = append(features, "MFMT")
+	}
+	if ss.s.TLSConfig != nil {
+		features = append(features, "AUTH TLS", "PBSZ", "PROT")
+	}
+
+	var b strings.Builder
+	b.WriteString("211-Features:\r\n")
+	for _, feature := range features {
+		b.WriteString(" " + feature + "\r\n")
+	}
+	b.WriteString("211 End")
+	ss.text.PrintfLine("%s", b.String())
+}
+
+func (ss *session) pass(password string) {
+	switch {
+	case ss.user == "":
+		ss.reply(ftp.StatusBadSequence, "Login with USER first")
+	case ss.s.RequireTLS && !ss.tls:
+		ss.reply(ftp.StatusNotLoggedIn, "TLS required")
+	case ss.s.Auth == nil && !isAnonymous(ss.user):
+		ss.reply(ftp.StatusNotLoggedIn, "Only anonymous logins are allowed")
+	case ss.s.Auth != nil && !ss.s.Auth(ss.user, password):
+		ss.reply(ftp.StatusNotLoggedIn, "Login incorrect")
+	default:
+		ss.loggedIn = true
+		ss.reply(ftp.StatusLoggedIn, "User %s logged in", ss.user)
+	}
+}
+
+// isAnonymous reports whether user is one of the names of the anonymous users.
+func isAnonymous(user string) bool {
+	return strings.EqualFold(user, "anonymous") || strings.EqualFold(user, "ftp")
+}
+
+// writeFS returns the file system, if the user may modify it.
+func (ss *session) writeFS() (WriteFS, bool) {
+	if ss.s.Auth == nil {
+		return nil, false
+	}
+	wfs, ok := ss.s.FS.(WriteFS)
+	return wfs, ok
+}
+
+// handleFiles executes the commands of logged in users.
+func (ss *session) handleFiles(verb, arg string) {
+	switch verb {
+	case "TYPE", "MODE", "STRU":
+		ss.reply(ftp.StatusCommandOK, "%s set to %s", verb, arg)
+	case "PWD", "XPWD":
+		ss.reply(ftp.StatusPathCreated, "%q is the current directory", ss.cwd)
+	case "CWD":
+		ss.cd(ss.abs(arg))
+	case "CDUP":
+		ss.cd(path.Dir(ss.cwd))
+	case "EPSV":
+		if port, err := ss.listenPassive(); err != nil {
+			ss.reply(ftp.StatusCanNotOpenDataConnection, "Can not open data connection")
+		} else {
+			ss.reply(ftp.StatusExtendedPassiveMode, "Entering Extended Passive Mode (|||%d|)", port)
+		}
+	case "PASV":
+		ss.pasv()
+	case "PORT", "EPRT":
+		ss.port(verb, arg)
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			ss.reply(ftp.StatusBadArguments, "Invalid offset")
+			return
+		}
+		ss.rest = offset
+		ss.reply(ftp.StatusRequestFilePending, "Restarting at %d", offset)
+	case "LIST", "NLST", "MLSD":
+		ss.list(verb, ss.abs(listPath(arg)))
+	case "MLST":
+		ss.mlst(ss.abs(arg))
+	case "RETR":
+		ss.retr(ss.abs(arg))
+	case "STOR", "APPE":
+		ss.stor(ss.abs(arg), verb == "APPE")
+	case "SIZE", "MDTM":
+		ss.stat(verb, ss.abs(arg))
+	case "DELE", "MKD", "XMKD", "RMD", "XRMD", "RNFR", "RNTO":
+		ss.modify(verb, ss.abs(arg))
+	case "MFMT":
+		ss.mfmt(arg)
+	default:
+		ss.reply(ftp.StatusNotImplemented, "Command %s not implemented", verb)
+	}
+}
+
+// abs returns the absolute path of the argument p.
+func (ss *session) abs(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = ss.cwd + "/" + p
+	}
+	return path.Clean(p)
+}
+
+// fsName returns the name in FS of the absolute path p.
+func fsName(p string) string {
+	if p == "/" {
+		return "."
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// listPath strips the options, such as "-a", from the argument of LIST.
+func listPath(arg string) string {
+	var fields []string
+	for _, field := range strings.Fields(arg) {
+		if !strings.HasPrefix(field, "-") {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// fileError replies with the error of an operation on the file p.
+func (ss *session) fileError(p string, err error) {
+	switch {
+	case errors.Is(err, errReadOnly) || errors.Is(err, fs.ErrPermission):
+		ss.reply(ftp.StatusFileUnavailable, "%s: permission denied", p)
+	case errors.Is(err, fs.ErrNotExist):
+		ss.reply(ftp.StatusFileUnavailable, "%s: no such file or directory", p)
+	default:
+		ss.reply(ftp.StatusFileUnavailable, "%v", err)
+	}
+}
+
+func (ss *session) cd(p string) {
+	fi, err := fs.Stat(ss.s.FS, fsName(p))
+	if err == nil && !fi.IsDir() {
+		err = fmt.Errorf("%s: %w", p, errNotDir)
+	}
+	if err != nil {
+		ss.fileError(p, err)
+		return
+	}
+	ss.cwd = p
+	ss.reply(ftp.StatusRequestedFileActionOK, "CWD command successful")
+}
+
+var errNotDir = errors.New("not a directory")
+
+func (ss *session) stat(verb, p string) {
+	fi, err := fs.Stat(ss.s.FS, fsName(p))
+	if err == nil && fi.IsDir() {
+		err = fmt.Errorf("%s: %w", p, errors.New("not a regular file"))
+	}
+	if err != nil {
+		ss.fileError(p, err)
+		return
+	}
+
+	if verb == "SIZE" {
+		ss.reply(ftp.StatusFile, "%d", fi.Size())
+	} else {
+		ss.reply(ftp.StatusFile, "%s", fi.ModTime().UTC().Format("20060102150405"))
+	}
+}
+
+func (ss *session) mlst(p string) {
+	fi, err := fs.Stat(ss.s.FS, fsName(p))
+	if err != nil {
+		ss.fileError(p, err)
+		return
+	}
+	ss.text.PrintfLine("%d-Listing %s\r\n %s %s\r\n%d End", ftp.StatusRequestedFileActionOK, p, mlsxFacts(fi), p, ftp.StatusRequestedFileActionOK)
+}
+
+// modify executes the commands modifying the file system.
+func (ss *session) modify(verb, p string) {
+	wfs, ok := ss.writeFS()
+	if !ok || p == "/" {
+		ss.renaming = ""
+		ss.fileError(p, errReadOnly)
+		return
+	}
+
+	if verb == "RNTO" {
+		from := ss.renaming
+		ss.renaming = ""
+		if from == "" {
+			ss.reply(ftp.StatusBadSequence, "Use RNFR first")
+			return
+		}
+		if err := wfs.Rename(fsName(from), fsName(p)); err != nil {
+			ss.fileError(p, err)
+			return
+		}
+		ss.reply(ftp.StatusRequestedFileActionOK, "Rename successful")
+		return
+	}
+	name := fsName(p)
+
+	switch verb {
+	case "DELE", "RMD", "XRMD":
+		fi, err := fs.Stat(wfs, name)
+		if err == nil && fi.IsDir() != (verb != "DELE") {
+			err = fmt.Errorf("%s: %w", p, errors.New("wrong file type"))
+		}
+		if err == nil {
+			err = wfs.Remove(name)
+		}
+		if err != nil {
+			ss.fileError(p, err)
+			return
+		}
+		ss.reply(ftp.StatusRequestedFileActionOK, "%s command successful", verb)
+	case "MKD", "XMKD":
+		if err := wfs.Mkdir(name, 0755); err != nil {
+			ss.fileError(p, err)
+			return
+		}
+		ss.reply(ftp.StatusPathCreated, "%q directory created", p)
+	case "RNFR":
+		if _, err := fs.Stat(wfs, name); err != nil {
+			ss.fileError(p, err)
+			return
+		}
+		ss.renaming = p
+		ss.reply(ftp.StatusRequestFilePending, "File exists, ready for destination name")
+	}
+}
+
+// mfmt sets the modification time of a file, as "MFMT time path".
+func (ss *session) mfmt(arg string) {
+	value, name, _ := strings.Cut(arg, " ")
+	p := ss.abs(name)
+	t, err := time.ParseInLocation("20060102150405", value, time.UTC)
+	if err != nil {
+		ss.reply(ftp.StatusBadArguments, "Invalid time %s", value)
+		return
+	}
+
+	wfs, _ := ss.writeFS()
+	cfs, ok := wfs.(ChtimesFS)
+	if !ok || p == "/" {
+		ss.fileError(p, errReadOnly)
+		return
+	}
+	if err := cfs.Chtimes(fsName(p), t); err != nil {
+		ss.fileError(p, err)
+		return
+	}
+	ss.reply(ftp.StatusFile, "Modify=%s; %s", value, name)
+}
+
+// listenPassive opens the listener of the next data connection, on the
+// address of the control connection.
+func (ss *session) listenPassive() (int, error) {
+	ss.resetData()
+
+	host, _, err := net.SplitHostPort(ss.conn.LocalAddr().String())
+	if err != nil {
+		return 0, err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	ss.passive = l
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (ss *session) pasv() {
+	ip := ss.conn.LocalAddr().(*net.TCPAddr).IP.To4()
+	if ip == nil {
+		ss.reply(ftp.StatusCanNotOpenDataConnection, "PASV requires IPv4, use EPSV")
+		return
+	}
+
+	port, err := ss.listenPassive()
+	if err != nil {
+		ss.reply(ftp.StatusCanNotOpenDataConnection, "Can not open data connection")
+		return
+	}
+	ss.reply(ftp.StatusPassiveMode, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)",
+		ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+}
+
+// resetData closes the passive listener, and forgets the active address.
+func (ss *session) resetData() {
+	if ss.passive != nil {
+		ss.passive.Close()
+		ss.passive = nil
+	}
+	ss.active = nil
+}
+
+// port sets the address of the active data connection, which must be on the
+// host of the client.
+func (ss *session) port(verb, arg string) {
+	ss.resetData()
+
+	var addr *net.TCPAddr
+	var err error
+	if verb == "PORT" {
+		addr, err = parsePORT(arg)
+	} else {
+		addr, err = parseEPRT(arg)
+	}
+	if errors.Is(err, errBadProtocol) {
+		ss.reply(statusBadProtocol, "Network protocol not supported, use (1,2)")
+		return
+	}
+	if err != nil || !sameHost(addr, ss.conn.RemoteAddr()) {
+		ss.reply(ftp.StatusBadArguments, "Illegal %s command", verb)
+		return
+	}
+	ss.active = addr
+	ss.reply(ftp.StatusCommandOK, "%s command successful", verb)
+}
+
+// errBadProtocol reports an EPRT address of an unsupported network protocol.
+var errBadProtocol = errors.New("unsupported network protocol")
+
+// parsePORT parses the argument of PORT, h1,h2,h3,h4,p1,p2.
+func parsePORT(arg string) (*net.TCPAddr, error) {
+	fields := strings.Split(arg, ",")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid PORT argument %q", arg)
+	}
+	var b [6]byte
+	for i, field := range fields {
+		n, err := strconv.ParseUint(field, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PORT argument %q", arg)
+		}
+		b[i] = byte(n)
+	}
+	return &net.TCPAddr{
+		IP:   net.IPv4(b[0], b[1], b[2], b[3]),
+		Port: int(b[4])<<8 | int(b[5]),
+	}, nil
+}
+
+// parseEPRT parses the argument of EPRT, such as |2|::1|2121|, of RFC 2428.
+func parseEPRT(arg string) (*net.TCPAddr, error) {
+	if arg == "" {
+		return nil, errors.New("empty EPRT argument")
+	}
+	fields := strings.Split(arg, arg[:1])
+	if len(fields) != 5 || fields[0] != "" || fields[4] != "" {
+		return nil, fmt.Errorf("invalid EPRT argument %q", arg)
+	}
+	if fields[1] != "1" && fields[1] != "2" {
+		return nil, errBadProtocol
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[3], 10, 16)
+	if ip == nil || err != nil || port == 0 || (ip.To4() != nil) != (fields[1] == "1") {
+		return nil, fmt.Errorf("invalid EPRT argument %q", arg)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// openData opens the data connection and sends the 150 reply, or replies
+// with an error and returns nil.
+// The passive connections from another host than the client are refused, to
+// prevent the theft of the data of the transfer.
+func (ss *session) openData() net.Conn {
+	var conn net.Conn
+	switch {
+	case ss.active != nil:
+		conn = ss.dialActive()
+	case ss.passive != nil:
+		conn = ss.acceptPassive()
+	default:
+		ss.reply(ftp.StatusCanNotOpenDataConnection, "Use PORT, EPRT, PASV or EPSV first")
+		return nil
+	}
+	if conn == nil {
+		ss.reply(ftp.StatusCanNotOpenDataConnection, "Can not open data connection")
+		return nil
+	}
+	ss.reply(ftp.StatusAboutToSend, "Opening data connection")
+
+	if ss.protect {
+		return tls.Server(conn, ss.s.TLSConfig)
+	}
+	return conn
+}
+
+// dialActive connects to the address set by PORT or EPRT, or returns nil.
+func (ss *session) dialActive() net.Conn {
+	addr := ss.active
+	ss.active = nil
+	conn, err := net.DialTimeout("tcp", addr.String(), dataTimeout)
+	if err != nil {
+		return nil
+	}
+	return conn
+}
+
+// acceptPassive accepts the connection of the client to the passive
+// listener, or returns nil.
+func (ss *session) acceptPassive() net.Conn {
+	l := ss.passive
+	ss.passive = nil
+	defer l.Close()
+
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(dataTimeout))
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return nil
+		}
+		if sameHost(conn.RemoteAddr(), ss.conn.RemoteAddr()) {
+			return conn
+		}
+		conn.Close()
+	}
+}
+
+// sameHost reports whether the TCP addresses a and b have the same IP.
+func sameHost(a, b net.Addr) bool {
+	ta, ok := a.(*net.TCPAddr)
+	tb, ok2 := b.(*net.TCPAddr)
+	return ok && ok2 && ta.IP.Equal(tb.IP)
+}
+
+// endData closes the data connection, and sends the final reply.
+func (ss *session) endData(conn net.Conn, err error) {
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		ss.reply(ftp.StatusTransfertAborted, "Transfer aborted: %v", err)
+		return
+	}
+	ss.reply(ftp.StatusClosingDataConnection, "Transfer complete")
+}
+
+func (ss *session) list(verb, p string) {
+	if listing := ss.action.Listing; listing != nil {
+		conn := ss.openData()
+		if conn == nil {
+			return
+		}
+		ss.endData(conn, ss.sendData(conn, bytes.NewReader(listing)))
+		return
+	}
+
+	name := fsName(p)
+	fi, err := fs.Stat(ss.s.FS, name)
+	var entries []fs.FileInfo
+	if err == nil && fi.IsDir() {
+		var dirEntries []fs.DirEntry
+		dirEntries, err = fs.ReadDir(ss.s.FS, name)
+		for _, entry := range dirEntries {
+			info, ierr := entry.Info()
+			if ierr == nil {
+				entries = append(entries, info)
+			}
+		}
+	} else if err == nil {
+		entries = []fs.FileInfo{fi}
+	}
+	if err != nil {
+		ss.resetData()
+		ss.fileError(p, err)
+		return
+	}
+
+	var b strings.Builder
+	for _, fi := range entries {
+		writeEntry(&b, verb, fi)
+	}
+
+	conn := ss.openData()
+	if conn == nil {
+		return
+	}
+	ss.endData(conn, ss.sendData(conn, strings.NewReader(b.String())))
+}
+
+// writeEntry writes the line listing a file in the format of verb.
+func writeEntry(b *strings.Builder, verb string, fi fs.FileInfo) {
+	switch verb {
+	case "NLST":
+		fmt.Fprintf(b, "%s\r\n", fi.Name())
+	case "MLSD":
+		fmt.Fprintf(b, "%s %s\r\n", mlsxFacts(fi), fi.Name())
+	default:
+		date := fi.ModTime().Format("Jan _2 15:04")
+		if time.Since(fi.ModTime()) > 180*24*time.Hour {
+			date = fi.ModTime().Format("Jan _2  2006")
+		}
+		fmt.Fprintf(b, "%s 1 ftp ftp %12d %s %s\r\n", fi.Mode().String(), fi.Size(), date, fi.Name())
+	}
+}
+
+// mlsxFacts returns the facts of a file listed by MLST and MLSD.
+func mlsxFacts(fi fs.FileInfo) string {
+	modify := fi.ModTime().UTC().Format("20060102150405")
+	mode := fmt.Sprintf("%04o", fi.Mode().Perm())
+	if fi.IsDir() {
+		return "type=dir;modify=" + modify + ";unix.mode=" + mode + ";"
+	}
+	return fmt.Sprintf("type=file;size=%d;modify=%s;unix.mode=%s;", fi.Size(), modify, mode)
+}
+
+func (ss *session) retr(p string) {
+	offset := ss.rest
+	ss.rest = 0
+
+	f, err := ss.s.FS.Open(fsName(p))
+	if err == nil {
+		defer f.Close()
+		var fi fs.FileInfo
+		if fi, err = f.Stat(); err == nil && fi.IsDir() {
+			err = fmt.Errorf("%s: %w", p, errors.New("not a regular file"))
+		}
+	}
+	if err == nil && offset > 0 {
+		if seeker, ok := f.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, f, offset)
+		}
+	}
+	if err != nil {
+		ss.resetData()
+		ss.fileError(p, err)
+		return
+	}
+
+	conn := ss.openData()
+	if conn == nil {
+		return
+	}
+	ss.endData(conn, ss.sendData(conn, f))
+}
+
+func (ss *session) stor(p string, appending bool) {
+	offset := ss.rest
+	ss.rest = 0
+
+	wfs, ok := ss.writeFS()
+	if !ok {
+		ss.resetData()
+		ss.fileError(p, errReadOnly)
+		return
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE
+	switch {
+	case appending:
+		flag |= os.O_APPEND
+	case offset == 0:
+		flag |= os.O_TRUNC
+	}
+
+	f, err := wfs.OpenFile(fsName(p), flag, 0644)
+	if err == nil && offset > 0 && !appending {
+		if seeker, ok := f.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else {
+			err = fmt.Errorf("%s: %w", p, errors.New("restart not supported"))
+		}
+		if err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		ss.resetData()
+		ss.fileError(p, err)
+		return
+	}
+
+	conn := ss.openData()
+	if conn == nil {
+		f.Close()
+		return
+	}
+	err = ss.receiveData(f, conn)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	ss.endData(conn, err)
+}
@@ -0,0 +1,606 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/snus8bit/ftp"
+)
+
+// startServer serves s on a local address, and returns the address.
+func startServer(t *testing.T, s *Server, implicitTLS bool) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if implicitTLS {
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+	return addr
+}
+
+func login(t *testing.T, addr string, options ...ftp.DialOption) *ftp.ServerConn {
+	c, err := ftp.Dial(addr, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func retr(t *testing.T, c *ftp.ServerConn, path string) string {
+	r, err := c.Retr(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestServerReadOnly(t *testing.T) {
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := &Server{FS: fstest.MapFS{
+		"pub/readme.txt": {Data: []byte("Just some text"), ModTime: modTime},
+		"pub/sub/other":  {Data: []byte("other")},
+	}}
+	c := login(t, startServer(t, s, false))
+	defer c.Quit()
+
+	if err := c.ChangeDir("pub"); err != nil {
+		t.Fatal(err)
+	}
+	if dir, err := c.CurrentDir(); err != nil || dir != "/pub" {
+		t.Errorf("current dir %q, %v", dir, err)
+	}
+	if data := retr(t, c, "readme.txt"); data != "Just some text" {
+		t.Errorf("read %q", data)
+	}
+
+	r, err := c.RetrFrom("readme.txt", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if err := r.Close(); err != nil || string(data) != "some text" {
+		t.Errorf("read %q from offset 5, %v", data, err)
+	}
+
+	if size, err := c.FileSize("readme.txt"); err != nil || size != 14 {
+		t.Errorf("size %d, %v", size, err)
+	}
+	if mtime, err := c.GetTime("readme.txt"); err != nil || !mtime.Equal(modTime) {
+		t.Errorf("modification time %v, %v", mtime, err)
+	}
+
+	entries, err := c.List(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("listed %d entries", len(entries))
+	}
+	if e := entries[0]; e.Name != "readme.txt" || e.Type != ftp.EntryTypeFile || e.Size != 14 || !e.Time.Equal(modTime) {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Name != "sub" || e.Type != ftp.EntryTypeFolder {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	names, err := c.NameList("/pub/sub")
+	if err != nil || !reflect.DeepEqual(names, []string{"other"}) {
+		t.Errorf("names %v, %v", names, err)
+	}
+
+	if _, err := c.Stor("new", bytes.NewBufferString("data")); ftp.ReplyCode(err) != ftp.StatusFileUnavailable {
+		t.Errorf("expected a 550 reply storing in a read-only FS, got %v", err)
+	}
+	if _, err := c.Delete("readme.txt"); ftp.ReplyCode(err) != ftp.StatusFileUnavailable {
+		t.Errorf("expected a 550 reply deleting in a read-only FS, got %v", err)
+	}
+	if err := c.ChangeDir("/missing"); !errors.Is(err, ftp.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestServerWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{
+		FS: DirFS(dir),
+		Auth: func(user, password string) bool {
+			return user == "anonymous"
+		},
+	}
+	c := login(t, startServer(t, s, false))
+	defer c.Quit()
+
+	if _, err := c.MakeDir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Stor("docs/file.txt", bytes.NewBufferString("Just some")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.StorFrom("docs/file.txt", bytes.NewBufferString(" text"), 9); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "docs", "file.txt")); err != nil || string(data) != "Just some text" {
+		t.Errorf("stored %q, %v", data, err)
+	}
+
+	if _, err := c.Rename("docs/file.txt", "docs/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if data := retr(t, c, "/docs/renamed.txt"); data != "Just some text" {
+		t.Errorf("read %q", data)
+	}
+
+	if _, err := c.RemoveDir("docs"); err == nil {
+		t.Error("removed a non-empty directory")
+	}
+	if _, err := c.Delete("docs/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RemoveDir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs")); !os.IsNotExist(err) {
+		t.Errorf("the directory was not removed: %v", err)
+	}
+}
+
+func TestServerAnonymous(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{FS: DirFS(dir)}
+	addr := startServer(t, s, false)
+
+	c, err := ftp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	// Without Auth, only the anonymous users are accepted, read-only
+	if err := c.Login("user", "password"); !errors.Is(err, ftp.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn, got %v", err)
+	}
+	if err := c.Login("ftp", "guest@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Stor("new", bytes.NewBufferString("data")); ftp.ReplyCode(err) != ftp.StatusFileUnavailable {
+		t.Errorf("expected a 550 reply storing without Auth, got %v", err)
+	}
+	if _, err := c.MakeDir("docs"); ftp.ReplyCode(err) != ftp.StatusFileUnavailable {
+		t.Errorf("expected a 550 reply creating a directory without Auth, got %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("the directory was modified: %v, %v", entries, err)
+	}
+}
+
+func TestServerDataConnHost(t *testing.T) {
+	s := &Server{FS: fstest.MapFS{"file": {Data: []byte("secret data")}}}
+	addr := startServer(t, s, false)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(ftp.StatusReady); err != nil {
+		t.Fatal(err)
+	}
+
+	var port string
+	for _, step := range []struct {
+		command string
+		code    int
+	}{
+		{"USER anonymous", ftp.StatusUserOK},
+		{"PASS anonymous", ftp.StatusLoggedIn},
+		{"EPSV", ftp.StatusExtendedPassiveMode},
+	} {
+		if err := text.PrintfLine("%s", step.command); err != nil {
+			t.Fatal(err)
+		}
+		_, msg, err := text.ReadResponse(step.code)
+		if err != nil {
+			t.Fatalf("%s: %v", step.command, err)
+		}
+		if step.command == "EPSV" {
+			port = strings.Trim(msg[strings.Index(msg, "(|||"):], "(|)")
+		}
+	}
+	if err := text.PrintfLine("RETR file"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A connection from another host is closed without data
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+	intruder, err := dialer.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Skip("can not dial from 127.0.0.2:", err)
+	}
+	if data, _ := ioutil.ReadAll(intruder); len(data) != 0 {
+		t.Errorf("sent %q to another host", data)
+	}
+	intruder.Close()
+
+	// The client can still connect
+	data, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := ioutil.ReadAll(data); err != nil || string(buf) != "secret data" {
+		t.Errorf("read %q, %v", buf, err)
+	}
+	data.Close()
+	if _, _, err := text.ReadResponse(ftp.StatusAboutToSend); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := text.ReadResponse(ftp.StatusClosingDataConnection); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerActive(t *testing.T) {
+	for _, test := range []struct {
+		options []ftp.DialOption
+		verb    string
+	}{
+		{nil, "EPRT"},
+		{[]ftp.DialOption{ftp.DialWithFeatureOverride("EPRT", false)}, "PORT"},
+	} {
+		var mu sync.Mutex
+		var verbs []string
+		s := &Server{
+			FS: DirFS(t.TempDir()),
+			Auth: func(user, password string) bool {
+				return true
+			},
+			Hook: func(cmd Command) Action {
+				mu.Lock()
+				defer mu.Unlock()
+				if cmd.Verb == "PORT" || cmd.Verb == "EPRT" || cmd.Verb == "STOR" || cmd.Verb == "RETR" {
+					verbs = append(verbs, cmd.Verb)
+				}
+				return Action{}
+			},
+		}
+		c := login(t, startServer(t, s, false), append(test.options, ftp.DialWithActiveMode(true))...)
+
+		if _, err := c.Stor("file.txt", bytes.NewBufferString("Just some text")); err != nil {
+			t.Fatal(err)
+		}
+		if data := retr(t, c, "file.txt"); data != "Just some text" {
+			t.Errorf("read %q", data)
+		}
+		if err := c.Quit(); err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		if expected := []string{test.verb, "STOR", test.verb, "RETR"}; !reflect.DeepEqual(verbs, expected) {
+			t.Errorf("expected %v, got %v", expected, verbs)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestServerActiveHost(t *testing.T) {
+	s := &Server{FS: fstest.MapFS{"file": {Data: []byte("secret data")}}}
+	addr := startServer(t, s, false)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(ftp.StatusReady); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connections to other hosts are refused, as the invalid addresses
+	for _, step := range []struct {
+		command string
+		code    int
+	}{
+		{"USER anonymous", ftp.StatusUserOK},
+		{"PASS anonymous", ftp.StatusLoggedIn},
+		{"PORT 192,0,2,1,4,1", ftp.StatusBadArguments},
+		{"EPRT |1|192.0.2.1|1025|", ftp.StatusBadArguments},
+		{"PORT 127,0,0,1,4", ftp.StatusBadArguments},
+		{"EPRT |1|::1|1025|", ftp.StatusBadArguments},
+		{"EPRT |3|127.0.0.1|1025|", statusBadProtocol},
+		{"RETR file", ftp.StatusCanNotOpenDataConnection},
+	} {
+		if err := text.PrintfLine("%s", step.command); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := text.ReadResponse(step.code); err != nil {
+			t.Errorf("%s: %v", step.command, err)
+		}
+	}
+}
+
+func TestServerHook(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("some data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu       sync.Mutex
+		commands []Command
+	)
+	s := &Server{
+		FS: DirFS(dir),
+		Auth: func(user, password string) bool {
+			return true
+		},
+		Hook: func(cmd Command) Action {
+			mu.Lock()
+			commands = append(commands, cmd)
+			mu.Unlock()
+			switch cmd.Verb {
+			case "SIZE":
+				return Action{Reply: "451 Local error"}
+			case "NLST":
+				return Action{Listing: []byte("canned\r\n")}
+			case "RETR":
+				return Action{DropData: true, DataBytes: 4}
+			}
+			return Action{}
+		},
+	}
+	c := login(t, startServer(t, s, false))
+	defer c.Quit()
+
+	if _, err := c.FileSize("file"); ftp.ReplyCode(err) != 451 {
+		t.Errorf("expected a 451 reply, got %v", err)
+	}
+	if names, err := c.NameList("/"); err != nil || !reflect.DeepEqual(names, []string{"canned"}) {
+		t.Errorf("listed %v, %v", names, err)
+	}
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "some" {
+		t.Errorf("read %q", data)
+	}
+	if err := r.Close(); ftp.ReplyCode(err) != ftp.StatusTransfertAborted {
+		t.Errorf("expected a 426 reply, got %v", err)
+	}
+
+	// The commands on files are hooked with their absolute path
+	var last Command
+	mu.Lock()
+	defer mu.Unlock()
+	for _, cmd := range commands {
+		if cmd.Verb == "RETR" {
+			last = cmd
+		}
+	}
+	if expected := (Command{Verb: "RETR", Arg: "file", Path: "/file"}); last != expected {
+		t.Errorf("hooked %+v, expected %+v", last, expected)
+	}
+}
+
+func TestServerMFMT(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "file"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		FS: DirFS(dir),
+		Auth: func(user, password string) bool {
+			return true
+		},
+	}
+	c := login(t, startServer(t, s, false))
+	defer c.Quit()
+
+	if !c.HasFeature("MFMT") {
+		t.Fatal("MFMT is not listed by FEAT")
+	}
+	if err := c.Touch(context.Background(), "file"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "file")); err != nil || fi.ModTime().Equal(old) {
+		t.Errorf("the modification time was not set: %v", err)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	s := &Server{
+		FS: fstest.MapFS{},
+		Auth: func(user, password string) bool {
+			return user == "user" && password == "secret"
+		},
+	}
+	addr := startServer(t, s, false)
+
+	c, err := ftp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	if err := c.Login("user", "wrong"); !errors.Is(err, ftp.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn, got %v", err)
+	}
+	if _, err := c.List("/"); ftp.ReplyCode(err) != ftp.StatusNotLoggedIn {
+		t.Errorf("expected a 530 reply before login, got %v", err)
+	}
+	if err := c.Login("user", "secret"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+func TestServerTLS(t *testing.T) {
+	cert, pool := newCertificate(t)
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	fsys := fstest.MapFS{"file": {Data: []byte("secret data")}}
+
+	t.Run("explicit", func(t *testing.T) {
+		s := &Server{FS: fsys, TLSConfig: serverConfig, RequireTLS: true}
+		addr := startServer(t, s, false)
+
+		// Plaintext logins are refused
+		c, err := ftp.Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Login("user", "password"); !errors.Is(err, ftp.ErrNotLoggedIn) {
+			t.Errorf("expected ErrNotLoggedIn without TLS, got %v", err)
+		}
+		c.Quit()
+
+		// The client only supports implicit TLS: upgrade the connection by hand
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		text := textproto.NewConn(conn)
+		if _, _, err := text.ReadResponse(ftp.StatusReady); err != nil {
+			t.Fatal(err)
+		}
+		if err := text.PrintfLine("AUTH TLS"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := text.ReadResponse(234); err != nil {
+			t.Fatal(err)
+		}
+
+		tlsConn := tls.Client(conn, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+		text = textproto.NewConn(tlsConn)
+		for _, step := range []struct {
+			command string
+			code    int
+		}{
+			{"USER anonymous", ftp.StatusUserOK},
+			{"PASS anonymous", ftp.StatusLoggedIn},
+			{"PBSZ 0", ftp.StatusCommandOK},
+			{"PROT P", ftp.StatusCommandOK},
+			{"SIZE file", ftp.StatusFile},
+			{"QUIT", ftp.StatusClosing},
+		} {
+			if err := text.PrintfLine("%s", step.command); err != nil {
+				t.Fatal(err)
+			}
+			if _, msg, err := text.ReadResponse(step.code); err != nil {
+				t.Fatalf("%s: %v", step.command, err)
+			} else if step.command == "SIZE file" && msg != "11" {
+				t.Errorf("size %s", msg)
+			}
+		}
+	})
+
+	t.Run("implicit", func(t *testing.T) {
+		s := &Server{FS: fsys, TLSConfig: serverConfig}
+		addr := startServer(t, s, true)
+
+		c := login(t, addr, ftp.DialWithTLS(&tls.Config{RootCAs: pool}))
+		defer c.Quit()
+		if data := retr(t, c, "file"); data != "secret data" {
+			t.Errorf("read %q", data)
+		}
+	})
+}
+
+func TestDirFSJoin(t *testing.T) {
+	dir := DirFS(t.TempDir()).(dirFS)
+	invalid := []string{".", "../file", "/file", "dir/../../file"}
+	if runtime.GOOS == "windows" {
+		// Valid for fs.ValidPath, but escaping the directory
+		invalid = append(invalid, `dir\..\..\file`)
+	}
+	for _, name := range invalid {
+		if _, err := dir.join("open", name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("%s: expected fs.ErrInvalid, got %v", name, err)
+		}
+	}
+	if p, err := dir.join("open", "dir/file"); err != nil || p != filepath.Join(string(dir), "dir", "file") {
+		t.Errorf("joined %q, %v", p, err)
+	}
+}
@@ -0,0 +1,97 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSessionExpired is returned by the commands of a ServerConn which exceeded
+// the limits set by DialWithMaxIdleTime or DialWithMaxSessionAge, unless the
+// connection is automatically reconnected.
+var ErrSessionExpired = errors.New("ftp: session expired")
+
+// DialWithMaxIdleTime returns a DialOption that configures the ServerConn to
+// QUIT, before the next command, a session which has been unused for longer
+// than d. The NOOPs sent by DialWithKeepalive do not count as a use.
+// This avoids using connections which the server silently expired.
+func DialWithMaxIdleTime(d time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.maxIdleTime = d
+	}}
+}
+
+// DialWithMaxSessionAge returns a DialOption that configures the ServerConn to
+// QUIT, before the next command, a session which has been connected for longer
+// than d.
+func DialWithMaxSessionAge(d time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.maxSessionAge = d
+	}}
+}
+
+// Expired reports whether the session exceeded the limits set by
+// DialWithMaxIdleTime or DialWithMaxSessionAge.
+// An expired connection is reconnected by the next command if
+// DialWithAutoReconnect is enabled, and replaced by a new one in a Pool.
+func (c *ServerConn) Expired() bool {
+	now := time.Now()
+	if d := c.options.maxIdleTime; d > 0 && now.Sub(c.lastUsed) > d {
+		return true
+	}
+	if d := c.options.maxSessionAge; d > 0 && now.Sub(c.connectedAt) > d {
+		return true
+	}
+	return false
+}
+
+// pingTimeout bounds the wait for the reply to the NOOP of Ping.
+const pingTimeout = 5 * time.Second
+
+// Ping issues a NOOP to check that the session is usable, waiting 5 seconds
+// at most for the reply, or until the deadline of ctx if earlier.
+// Unlike the other commands, Ping does not reconnect a broken connection.
+// If the wait for the reply is interrupted, the connection is marked broken
+// since the reply may still arrive later: the next commands fail with
+// ErrConnectionBroken.
+func (c *ServerConn) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	defer c.withContext(pingCtx)()
+
+	_, _, err := c.rawCmd(StatusCommandOK, "NOOP")
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		if c.closing == nil {
+			c.closing = ErrConnectionBroken
+		}
+		return fmt.Errorf("%w: %w", ErrConnectionBroken, err)
+	}
+	return err
+}
+
+// IsAlive reports whether the session is usable, as checked by Ping.
+func (c *ServerConn) IsAlive() bool {
+	return c.Ping(context.Background()) == nil
+}
+
+// checkExpired closes an expired session, after which the commands fail with
+// ErrSessionExpired.
+func (c *ServerConn) checkExpired() {
+	if c.closing != nil || !c.Expired() {
+		return
+	}
+
+	c.log(c.logLevels().Reconnect, "ftp session expired", "addr", c.addr)
+	c.closing = ErrSessionExpired
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.Cmd("QUIT")
+	c.conn.Close()
+}
@@ -0,0 +1,133 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxIdleTime(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	c, err := Dial(mock.Addr(), DialWithMaxIdleTime(20*time.Millisecond), DialWithAutoReconnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if !c.Expired() {
+		t.Fatal("expected the session to be expired")
+	}
+
+	if err = c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Expired() {
+		t.Fatal("expected the session to be renewed")
+	}
+
+	closeConn(t, mock, c, []string{
+		"QUIT",
+		"FEAT", "USER", "PASS", "TYPE", "NOOP",
+	})
+}
+
+func TestMaxSessionAge(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithMaxSessionAge(20*time.Millisecond))
+
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if err := c.NoOp(); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("expected ErrSessionExpired, got %v", err)
+	}
+
+	c.Quit()
+	mock.Wait()
+}
+
+func TestPoolReplacesExpired(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	dials := 0
+	pool := NewPool(1, func() (*ServerConn, error) {
+		dials++
+		c, err := Dial(mock.Addr(), DialWithMaxSessionAge(20*time.Millisecond))
+		if err != nil {
+			return nil, err
+		}
+		return c, c.Login("anonymous", "anonymous")
+	})
+	defer pool.Close()
+
+	c, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(c)
+
+	time.Sleep(40 * time.Millisecond)
+
+	c, err = pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(c)
+
+	if dials != 2 {
+		t.Errorf("expected 2 dials, got %d", dials)
+	}
+}
+
+func TestPing(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsAlive() {
+		t.Error("expected the session to be alive")
+	}
+
+	closeConn(t, mock, c, []string{"NOOP", "NOOP"})
+}
+
+func TestPingSilent(t *testing.T) {
+	c, err := Dial(newSilentServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Quit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := c.Ping(ctx); !errors.Is(err, ErrConnectionBroken) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrConnectionBroken, got %v", err)
+	}
+
+	// The late reply to the NOOP would be read by the next command
+	if err := c.NoOp(); !errors.Is(err, ErrConnectionBroken) {
+		t.Errorf("expected ErrConnectionBroken, got %v", err)
+	}
+	if c.IsAlive() {
+		t.Error("expected the session to be broken")
+	}
+}
@@ -0,0 +1,94 @@
+package ftp
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// SiteCommands returns the SITE subcommands supported by the server, in upper
+// case, as listed by its reply to SITE HELP, or to HELP SITE if SITE HELP is
+// refused. The list is discovered on the first call.
+// Once discovered, Copy only tries SITE CPFR if it is listed.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) SiteCommands(ctx context.Context) ([]string, error) {
+	if err := c.discoverSiteCommands(ctx); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.siteCommands))
+	for name := range c.siteCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// HasSiteCommand reports whether the server lists the SITE subcommand name,
+// regardless of case. See SiteCommands.
+func (c *ServerConn) HasSiteCommand(ctx context.Context, name string) (bool, error) {
+	if err := c.discoverSiteCommands(ctx); err != nil {
+		return false, err
+	}
+	return c.siteCommands[strings.ToUpper(name)], nil
+}
+
+// discoverSiteCommands issues SITE HELP, once.
+func (c *ServerConn) discoverSiteCommands(ctx context.Context) error {
+	if c.siteCommands != nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer c.withContext(ctx)()
+
+	_, msg, err := c.cmd(StatusHelp, "SITE HELP")
+	if ReplyCode(err) != 0 {
+		_, msg, err = c.cmd(StatusHelp, "HELP SITE")
+	}
+	if err != nil {
+		return err
+	}
+
+	c.siteCommands = parseSiteHelp(msg)
+	return nil
+}
+
+// parseSiteHelp parses the commands listed by a reply to SITE HELP.
+// A multi-line reply lists them between its first and last lines, such as:
+//
+//	214-The following SITE commands are recognized (* =>'s unimplemented)
+//	 CHMOD   CHGRP   CPFR*
+//	214 Direct comments to root
+//
+// The commands marked with a star are not implemented.
+func parseSiteHelp(message string) map[string]bool {
+	lines := strings.Split(message, "\n")
+	if len(lines) > 2 {
+		lines = lines[1 : len(lines)-1]
+	}
+
+	commands := make(map[string]bool)
+	for _, line := range lines {
+		for _, field := range strings.Fields(line) {
+			if isSiteCommandName(field) {
+				commands[field] = true
+			}
+		}
+	}
+	return commands
+}
+
+// isSiteCommandName reports whether s is the name of a command, in upper case.
+func isSiteCommandName(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if (s[i] < 'A' || s[i] > 'Z') && (i == 0 || s[i] < '0' || s[i] > '9') {
+			return false
+		}
+	}
+	return true
+}
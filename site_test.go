@@ -0,0 +1,45 @@
+package ftp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestSiteCommands(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"SITE": {"214-The following SITE commands are recognized (* =>'s unimplemented)\r\n CHMOD   CHGRP\r\n UTIME*  HELP\r\n214 Direct comments to root"},
+	})
+
+	names, err := c.SiteCommands(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"CHGRP", "CHMOD", "HELP"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("commands %v, expected %v", names, expected)
+	}
+
+	// The list is discovered once
+	if ok, err := c.HasSiteCommand(context.Background(), "chmod"); err != nil || !ok {
+		t.Errorf("expected chmod to be supported, got %v, %v", ok, err)
+	}
+
+	// CPFR is not listed
+	if err := c.Copy(context.Background(), "file", "copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"SITE", "SIZE", "EPSV", "RETR", "EPSV", "STOR"})
+}
+
+func TestSiteCommandsHelpSite(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"HELP": {"214 CHMOD UMASK HELP"},
+	})
+
+	if ok, err := c.HasSiteCommand(context.Background(), "UMASK"); err != nil || !ok {
+		t.Errorf("expected UMASK to be supported, got %v, %v", ok, err)
+	}
+
+	closeConn(t, mock, c, []string{"SITE", "HELP"})
+}
@@ -0,0 +1,217 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsDir reports whether path is a directory, from the type fact of MLST when
+// the server supports it, otherwise by changing to path and back to the
+// current directory.
+// Without MLST, missing paths are reported as false, as files are.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) IsDir(ctx context.Context, path string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	defer c.withContext(ctx)()
+
+	if c.HasFeature("MLST") {
+		_, msg, err := c.cmd(StatusRequestedFileActionOK, "MLST %s", path)
+		if err == nil {
+			switch parseMLSTFacts(msg)["type"] {
+			case "dir", "cdir", "pdir":
+				return true, nil
+			case "":
+				// Fall back to CWD
+			default:
+				return false, nil
+			}
+		} else if errors.Is(err, ErrNotFound) || c.isConnectionError(err) || ctx.Err() != nil {
+			return false, err
+		}
+	}
+
+	cwd, err := c.CurrentDir()
+	if err != nil {
+		return false, err
+	}
+
+	if _, _, err = c.cmd(StatusRequestedFileActionOK, "CWD %s", path); err != nil {
+		if ReplyCode(err) == StatusFileUnavailable {
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, _, err = c.cmd(StatusRequestedFileActionOK, "CWD %s", cwd)
+	return true, err
+}
+
+// Stat returns the Entry describing the file at p, analogous to os.Stat.
+// The entry is assembled from the facts of MLST when the server supports it,
+// otherwise from the listing of LIST.
+// An error matching ErrNotFound is returned if the file does not exist.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) Stat(ctx context.Context, p string) (*Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer c.withContext(ctx)()
+
+	name := path.Base(p)
+	if name == "/" || name == "." {
+		return &Entry{Name: name, Type: EntryTypeFolder}, nil
+	}
+
+	if c.HasFeature("MLST") {
+		_, msg, err := c.cmd(StatusRequestedFileActionOK, "MLST %s", p)
+		if err == nil {
+			if entry := parseMLSTEntry(msg); entry != nil {
+				entry.Name = name
+				return entry, nil
+			}
+		} else if errors.Is(err, ErrNotFound) || c.isConnectionError(err) || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	// The listing of a file is the file itself
+	entries, err := c.List(p)
+	if err == nil && len(entries) == 1 && entries[0].Name == name && entries[0].Type != EntryTypeFolder {
+		return entries[0], nil
+	}
+	if c.isConnectionError(err) {
+		return nil, err
+	}
+
+	entries, err = c.List(path.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, p)
+}
+
+// ListPath lists p as ls does: the entries of the directory p, or the entry
+// of the file p, and reports whether p is a directory.
+// The type of p is given by the type fact of MLST when the server supports
+// it. Otherwise, a listing made of a single entry named as p, which is not a
+// directory, is taken as the listing of the file p.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) ListPath(ctx context.Context, p string) (entries []*Entry, isDir bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	defer c.withContext(ctx)()
+
+	name := path.Base(p)
+	knownDir := false
+	if c.HasFeature("MLST") {
+		_, msg, err := c.cmd(StatusRequestedFileActionOK, "MLST %s", p)
+		if err == nil {
+			if entry := parseMLSTEntry(msg); entry != nil {
+				if entry.Type != EntryTypeFolder {
+					entry.Name = name
+					return []*Entry{entry}, false, nil
+				}
+				knownDir = true
+			}
+		} else if errors.Is(err, ErrNotFound) || c.isConnectionError(err) || ctx.Err() != nil {
+			return nil, false, err
+		}
+	}
+
+	if entries, err = c.List(p); err != nil {
+		return nil, false, err
+	}
+	if !knownDir && len(entries) == 1 && entries[0].Type != EntryTypeFolder &&
+		(entries[0].Name == name || entries[0].Name == p) {
+		entries[0].Name = name
+		return entries, false, nil
+	}
+	return entries, true, nil
+}
+
+// maxDirSizeDepth stops the recursion of DirSize on cyclic trees.
+const maxDirSizeDepth = 64
+
+// DirSize returns the total size in bytes of the files below dir, and their
+// number.
+// The size is computed by the server with DSIZ when supported, in which case
+// the number of files is -1. Otherwise, the tree is listed recursively, down to
+// 64 levels, without following the links, which are not counted.
+// The deadline and cancellation of ctx bound the commands.
+func (c *ServerConn) DirSize(ctx context.Context, dir string) (size int64, files int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	defer c.withContext(ctx)()
+
+	if c.HasFeature("DSIZ") {
+		_, msg, err := c.cmd(StatusFile, "DSIZ %s", dir)
+		if err == nil {
+			if size, err = strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil {
+				return size, -1, nil
+			}
+		}
+		if c.isConnectionError(err) || ctx.Err() != nil {
+			return 0, 0, err
+		}
+	}
+
+	err = c.dirSize(dir, 0, &size, &files)
+	return size, files, err
+}
+
+// dirSize adds the sizes of the files below dir, at the given depth.
+func (c *ServerConn) dirSize(dir string, depth int, size *int64, files *int) error {
+	if depth > maxDirSizeDepth {
+		return fmt.Errorf("ftp: %s is deeper than %d levels", dir, maxDirSizeDepth)
+	}
+
+	entries, err := c.List(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		switch {
+		case entry.Name == "." || entry.Name == "..":
+		case entry.Type == EntryTypeFolder:
+			if err := c.dirSize(path.Join(dir, entry.Name), depth+1, size, files); err != nil {
+				return err
+			}
+		case entry.Type == EntryTypeFile:
+			*size += int64(entry.Size)
+			*files++
+		}
+	}
+	return nil
+}
+
+// parseMLSTEntry parses the facts of a reply to MLST, or returns nil.
+func parseMLSTEntry(message string) *Entry {
+	for _, line := range strings.Split(message, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		// The facts are in the format of MLSD, in UTC
+		entry, err := parseRFC3659ListLine(strings.TrimLeft(line, " "), time.Now(), time.UTC)
+		if err != nil {
+			return nil
+		}
+		return entry
+	}
+	return nil
+}
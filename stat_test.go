@@ -0,0 +1,183 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsDirMLST(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"MLST": {
+			"250-Listing dir\r\n type=dir;modify=20201112131415; /incoming/dir\r\n250 End",
+			"250-Listing file\r\n type=file;size=14; /incoming/file\r\n250 End",
+			"550 No such file",
+		},
+	}, DialWithFeatureOverride("MLST", true))
+
+	for _, test := range []struct {
+		path  string
+		isDir bool
+		err   error
+	}{
+		{"dir", true, nil},
+		{"file", false, nil},
+		{"missing", false, ErrNotFound},
+	} {
+		isDir, err := c.IsDir(context.Background(), test.path)
+		if isDir != test.isDir || !errors.Is(err, test.err) {
+			t.Errorf("IsDir(%q) = %v, %v, expected %v, %v", test.path, isDir, err, test.isDir, test.err)
+		}
+	}
+
+	closeConn(t, mock, c, []string{"MLST", "MLST", "MLST"})
+}
+
+func TestIsDirCWD(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	isDir, err := c.IsDir(context.Background(), "dir")
+	if err != nil || !isDir {
+		t.Errorf("IsDir(dir) = %v, %v, expected true", isDir, err)
+	}
+	if line := mock.lines[len(mock.lines)-1]; line != "CWD /incoming" {
+		t.Errorf("the current directory was not restored: %q", line)
+	}
+
+	isDir, err = c.IsDir(context.Background(), "missing-dir")
+	if err != nil || isDir {
+		t.Errorf("IsDir(missing-dir) = %v, %v, expected false", isDir, err)
+	}
+
+	closeConn(t, mock, c, []string{"PWD", "CWD", "CWD", "PWD", "CWD"})
+}
+
+func TestStatMLST(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"MLST": {"250-Listing file\r\n type=file;size=14;modify=20201112131415;unix.mode=0644; /incoming/file\r\n250 End"},
+	}, DialWithFeatureOverride("MLST", true))
+
+	entry, err := c.Stat(context.Background(), "/incoming/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Entry{
+		Name: "file",
+		Type: EntryTypeFile,
+		Size: 14,
+		Time: time.Date(2020, 11, 12, 13, 14, 15, 0, time.UTC),
+		Mode: 0644,
+	}
+	if *entry != expected {
+		t.Errorf("entry %+v, expected %+v", *entry, expected)
+	}
+
+	closeConn(t, mock, c, []string{"MLST"})
+}
+
+func TestStatLIST(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	mock.listData = "-rwxr-x---   1 ftp      wheel          14 Jan 29 10:29 lo\r\n"
+
+	entry, err := c.Stat(context.Background(), "lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != "lo" || entry.Type != EntryTypeFile || entry.Size != 14 || entry.Mode != 0750 {
+		t.Errorf("unexpected entry %+v", *entry)
+	}
+
+	// A missing file is searched in the listing of its directory
+	if _, err := c.Stat(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "EPSV", "LIST", "EPSV", "LIST"})
+}
+
+func TestListPathMLST(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"MLST": {
+			"250-Listing file\r\n type=file;size=14;modify=20201112131415; /incoming/file\r\n250 End",
+			"250-Listing dir\r\n type=dir;modify=20201112131415; /incoming/lo\r\n250 End",
+		},
+	}, DialWithFeatureOverride("MLST", true))
+	// The directory lo contains a single file named lo
+	mock.mlsdData = "type=file;size=0;modify=20201112121415; lo\r\n"
+
+	entries, isDir, err := c.ListPath(context.Background(), "file")
+	if err != nil || isDir || len(entries) != 1 || entries[0].Name != "file" || entries[0].Size != 14 {
+		t.Errorf("ListPath(file) = %v, %v, %v", entries, isDir, err)
+	}
+
+	entries, isDir, err = c.ListPath(context.Background(), "lo")
+	if err != nil || !isDir || len(entries) != 1 {
+		t.Errorf("ListPath(lo) = %v, %v, %v", entries, isDir, err)
+	}
+
+	closeConn(t, mock, c, []string{"MLST", "MLST", "EPSV", "MLSD"})
+}
+
+func TestListPathLIST(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	// The default listing is the single file lo
+	entries, isDir, err := c.ListPath(context.Background(), "/incoming/lo")
+	if err != nil || isDir || len(entries) != 1 || entries[0].Name != "lo" {
+		t.Errorf("ListPath(/incoming/lo) = %v, %v, %v", entries, isDir, err)
+	}
+
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 Jan 29 10:29 a\r\n" +
+		"drwxr-xr-x   1 ftp      wheel           0 Jan 29 10:29 b\r\n"
+	entries, isDir, err = c.ListPath(context.Background(), "/incoming")
+	if err != nil || !isDir || len(entries) != 2 {
+		t.Errorf("ListPath(/incoming) = %v, %v, %v", entries, isDir, err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "LIST", "EPSV", "LIST"})
+}
+
+func TestDirSizeDSIZ(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"FEAT": {"211-Features:\r\n EPSV\r\n DSIZ\r\n211 End"},
+		"DSIZ": {"213 123456"},
+	})
+
+	size, files, err := c.DirSize(context.Background(), "/incoming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 123456 || files != -1 {
+		t.Errorf("got %d bytes in %d files, expected 123456 bytes in -1 files", size, files)
+	}
+
+	closeConn(t, mock, c, []string{"DSIZ"})
+}
+
+func TestDirSizeList(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	mock.listData = "drwxr-xr-x   2 ftp      wheel        4096 Jan 29 10:29 .\r\n" +
+		"-rw-r--r--   1 ftp      wheel          14 Jan 29 10:29 a\r\n" +
+		"-rw-r--r--   1 ftp      wheel         100 Jan 29 10:29 b\r\n" +
+		"lrwxrwxrwx   1 ftp      wheel           1 Jan 29 10:29 c -> a\r\n"
+
+	size, files, err := c.DirSize(context.Background(), "/incoming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 114 || files != 2 {
+		t.Errorf("got %d bytes in %d files, expected 114 bytes in 2 files", size, files)
+	}
+
+	// The subdirectory is listed the same way, endlessly
+	mock.listData += "drwxr-xr-x   2 ftp      wheel        4096 Jan 29 10:29 sub\r\n"
+	if _, _, err := c.DirSize(context.Background(), "/incoming"); err == nil {
+		t.Error("expected an error on a cyclic tree")
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.Wait()
+}
@@ -0,0 +1,89 @@
+package ftp
+
+import (
+	"sync"
+	"time"
+)
+
+// TransferStats describes a completed transfer.
+type TransferStats struct {
+	// Bytes is the number of bytes moved over the data connection.
+	Bytes int64
+
+	// Offset is the position in the remote file the transfer started at.
+	Offset uint64
+
+	// Elapsed is the wall-clock duration of the transfer.
+	Elapsed time.Duration
+
+	// Resumed is true if the transfer resumed a previous one with REST,
+	// starting at Offset.
+	Resumed bool
+}
+
+// Throughput returns the average number of bytes moved per second.
+func (s *TransferStats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// newTransferStats returns the statistics of a transfer from offset which
+// moved n bytes since start.
+func newTransferStats(n int64, offset uint64, start time.Time) TransferStats {
+	return TransferStats{
+		Bytes:   n,
+		Offset:  offset,
+		Elapsed: time.Since(start),
+		Resumed: offset > 0,
+	}
+}
+
+// SessionStats are the cumulative statistics of the data transfers of a
+// ServerConn, see ServerConn.Stats. The listings count as downloads.
+type SessionStats struct {
+	// BytesUploaded and BytesDownloaded are the numbers of bytes moved over
+	// the data connections, including by the failed transfers.
+	BytesUploaded   int64
+	BytesDownloaded int64
+
+	// Uploads and Downloads are the numbers of transfers, including the
+	// failed ones.
+	Uploads   int
+	Downloads int
+
+	// Failures is the number of failed or aborted transfers.
+	Failures int
+}
+
+// sessionStats accumulates the SessionStats of a ServerConn.
+type sessionStats struct {
+	mu    sync.Mutex
+	stats SessionStats
+}
+
+func (s *sessionStats) add(direction TransferDirection, n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if direction == TransferUpload {
+		s.stats.BytesUploaded += n
+		s.stats.Uploads++
+	} else {
+		s.stats.BytesDownloaded += n
+		s.stats.Downloads++
+	}
+	if err != nil {
+		s.stats.Failures++
+	}
+}
+
+// Stats returns the statistics of the data transfers since the connection
+// was dialed, across automatic reconnections. Unlike the other methods, it
+// may be called concurrently, for instance to report the usage periodically.
+func (c *ServerConn) Stats() SessionStats {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return c.stats.stats
+}
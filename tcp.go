@@ -0,0 +1,86 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// TCPOptions tunes the TCP sockets of the data connections, whose system
+// defaults are suboptimal on long fat networks.
+// The zero value of each field keeps the default.
+type TCPOptions struct {
+	// NoDelay sets TCP_NODELAY, which Go enables by default, if not nil.
+	NoDelay *bool
+
+	// ReadBuffer sets SO_RCVBUF, if greater than 0.
+	ReadBuffer int
+
+	// WriteBuffer sets SO_SNDBUF, if greater than 0.
+	WriteBuffer int
+
+	// KeepAlive sets the period of the TCP keepalive probes if greater than
+	// 0, and disables them if negative.
+	KeepAlive time.Duration
+}
+
+// DialWithDataTCPOptions returns a DialOption that applies opts to the TCP
+// sockets of the data connections, including the ones established by
+// DialWithDialFunc if they are *net.TCPConn.
+func DialWithDataTCPOptions(opts TCPOptions) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.dataTCPOptions = &opts
+	}}
+}
+
+// DialWithTCPKeepAlive returns a DialOption that sets the period of the TCP
+// keepalive probes of the control connection, so that a connection silently
+// dropped by a firewall is detected even while the ServerConn is idle.
+// A negative period disables the probes. It also applies to the connections
+// established by DialWithDialFunc or given to DialWithNetConn, if they are
+// *net.TCPConn, possibly wrapped by a *tls.Conn.
+// Unlike DialWithKeepalive, no command is sent.
+func DialWithTCPKeepAlive(period time.Duration) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.controlTCPOptions = &TCPOptions{KeepAlive: period}
+	}}
+}
+
+// apply applies the options to conn, if it is a *net.TCPConn, or a *tls.Conn
+// over one.
+func (opts *TCPOptions) apply(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if opts == nil || !ok {
+		return nil
+	}
+
+	if opts.NoDelay != nil {
+		if err := tcpConn.SetNoDelay(*opts.NoDelay); err != nil {
+			return err
+		}
+	}
+	if opts.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(opts.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(opts.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if opts.KeepAlive != 0 {
+		if err := tcpConn.SetKeepAlive(opts.KeepAlive > 0); err != nil {
+			return err
+		}
+		if opts.KeepAlive > 0 {
+			if err := tcpConn.SetKeepAlivePeriod(opts.KeepAlive); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
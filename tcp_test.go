@@ -0,0 +1,53 @@
+package ftp
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDataTCPOptions(t *testing.T) {
+	noDelay := false
+	var tuned net.Conn
+	mock, c := openConn(t, "127.0.0.1",
+		DialWithDataTCPOptions(TCPOptions{
+			NoDelay:     &noDelay,
+			ReadBuffer:  256 * 1024,
+			WriteBuffer: 256 * 1024,
+			KeepAlive:   time.Minute,
+		}),
+		DialWithDialFunc(func(network, address string) (net.Conn, error) {
+			conn, err := net.Dial(network, address)
+			tuned = conn
+			return conn, err
+		}),
+	)
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, ok := tuned.(*net.TCPConn); !ok {
+		t.Errorf("expected a data connection, got %T", tuned)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR"})
+}
+
+func TestTCPKeepAlive(t *testing.T) {
+	for _, period := range []time.Duration{time.Minute, -1} {
+		mock, c := openConn(t, "127.0.0.1", DialWithTCPKeepAlive(period))
+		if err := c.NoOp(); err != nil {
+			t.Fatal(err)
+		}
+		closeConn(t, mock, c, []string{"NOOP"})
+	}
+}
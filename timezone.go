@@ -0,0 +1,137 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+)
+
+// ErrLocationUndetected is returned by DetectLocation when no listing allows
+// to compute the offset of the server.
+var ErrLocationUndetected = errors.New("ftp: server time zone not detected")
+
+// maxUTCOffset bounds the offsets considered as time zones.
+const maxUTCOffset = 14 * time.Hour
+
+// DetectLocation computes the UTC offset of the times listed by LIST, and
+// returns it as a location. The location only applies to the next listings
+// once given to SetLocation.
+//
+// If MLSD is used, the times of the files of dir listed by LIST are compared
+// with the UTC times listed by MLSD. Otherwise, an empty probe file is stored
+// in dir, and its LIST time is compared with the local clock before the probe
+// is deleted: the clocks of the client and of the server must then agree
+// within a few minutes.
+// The offset is rounded to a quarter of an hour.
+// The deadline and cancellation of ctx bound the detection.
+func (c *ServerConn) DetectLocation(ctx context.Context, dir string) (*time.Location, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer c.withContext(ctx)()
+
+	var offset time.Duration
+	var err error
+	if c.mlstSupported {
+		offset, err = c.mlsdOffset(dir)
+	} else {
+		offset, err = c.probeOffset(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return time.FixedZone(formatUTCOffset(offset), int(offset/time.Second)), nil
+}
+
+// SetLocation sets the location of the times listed by LIST, replacing the one
+// given to DialWithLocation, such as the location returned by DetectLocation.
+func (c *ServerConn) SetLocation(loc *time.Location) {
+	c.options.location = loc
+}
+
+// mlsdOffset compares the times of the files of dir listed by LIST and MLSD.
+func (c *ServerConn) mlsdOffset(dir string) (time.Duration, error) {
+	utcEntries, err := c.list("MLSD", parseRFC3659ListLine, dir, time.UTC)
+	if err != nil {
+		return 0, err
+	}
+	utcTimes := make(map[string]time.Time, len(utcEntries))
+	for _, entry := range utcEntries {
+		utcTimes[entry.Name] = entry.Time
+	}
+
+	entries, err := c.list("LIST", parseListLine, dir, time.UTC)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		utc, ok := utcTimes[entry.Name]
+		if !ok || utc.IsZero() {
+			continue
+		}
+		if offset, ok := listOffset(entry.Time, utc); ok {
+			return offset, nil
+		}
+	}
+	return 0, ErrLocationUndetected
+}
+
+// probeOffset compares the LIST time of a new file with the local clock.
+func (c *ServerConn) probeOffset(dir string) (time.Duration, error) {
+	probe := path.Join(dir, ".ftp-tz-probe-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	if _, err := c.Stor(probe, &bytes.Buffer{}); err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+
+	entries, err := c.list("LIST", parseListLine, probe, time.UTC)
+	if _, derr := c.Delete(probe); err == nil && derr != nil {
+		err = derr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		if entry.Name != path.Base(probe) && len(entries) > 1 {
+			continue
+		}
+		if offset, ok := listOffset(entry.Time, now); ok {
+			return offset, nil
+		}
+	}
+	return 0, ErrLocationUndetected
+}
+
+// listOffset returns the offset of a time listed by LIST, parsed in UTC, from
+// the actual UTC time, or false if listed reports no time of day or is too
+// far from actual.
+// The year of a recent file is not listed, so the one of actual is used.
+func listOffset(listed, actual time.Time) (time.Duration, bool) {
+	if listed.Hour() == 0 && listed.Minute() == 0 {
+		return 0, false
+	}
+
+	for _, year := range []int{actual.Year(), actual.Year() - 1, actual.Year() + 1} {
+		t := time.Date(year, listed.Month(), listed.Day(), listed.Hour(), listed.Minute(), 0, 0, time.UTC)
+		offset := t.Sub(actual.Truncate(time.Minute)).Round(15 * time.Minute)
+		if offset >= -maxUTCOffset && offset <= maxUTCOffset {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// formatUTCOffset returns the name of a fixed zone, such as "UTC+05:30".
+func formatUTCOffset(offset time.Duration) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+}
@@ -0,0 +1,99 @@
+package ftp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDetectLocationMLSD(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithFeatureOverride("MLST", true))
+	mock.mlsdData = "type=file;size=0;modify=20201112121415; lo\r\n"
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 Nov 12 14:14 lo\r\n"
+
+	loc, err := c.DetectLocation(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, offset := time.Date(2020, 1, 1, 0, 0, 0, 0, loc).Zone(); name != "UTC+02:00" || offset != 2*3600 {
+		t.Errorf("unexpected location %s (%d)", name, offset)
+	}
+
+	// The location applies once set
+	if c.options.location == loc {
+		t.Error("the location was applied")
+	}
+	c.SetLocation(loc)
+	if c.options.location != loc {
+		t.Error("the location was not applied")
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "MLSD", "EPSV", "LIST"})
+}
+
+func TestDetectLocationProbe(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	serverTime := time.Now().UTC().Add(-(5*time.Hour + 30*time.Minute))
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 " + serverTime.Format("Jan _2 15:04") + " .ftp-tz-probe\r\n"
+
+	loc, err := c.DetectLocation(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := time.Now().In(loc).Zone(); name != "UTC-05:30" {
+		t.Errorf("unexpected location %s", name)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "EPSV", "LIST", "DELE"})
+}
+
+func TestDetectLocationDisabledMLSD(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithFeatureOverride("MLST", true), DialWithDisabledMLSD(true))
+	serverTime := time.Now().UTC().Add(3 * time.Hour)
+	mock.listData = "-rw-r--r--   1 ftp      wheel           0 " + serverTime.Format("Jan _2 15:04") + " .ftp-tz-probe\r\n"
+
+	// The probe is used instead of MLSD
+	loc, err := c.DetectLocation(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := time.Now().In(loc).Zone(); name != "UTC+03:00" {
+		t.Errorf("unexpected location %s", name)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "EPSV", "LIST", "DELE"})
+}
+
+func TestDetectLocationCanceled(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.DetectLocation(ctx, "/"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	closeConn(t, mock, c, nil)
+}
+
+func TestListOffset(t *testing.T) {
+	actual := time.Date(2020, 12, 31, 23, 50, 30, 0, time.UTC)
+
+	// The next year on the server
+	listed := time.Date(2026, 1, 1, 1, 50, 0, 0, time.UTC)
+	if offset, ok := listOffset(listed, actual); !ok || offset != 2*time.Hour {
+		t.Errorf("offset %s, %v, expected 2h", offset, ok)
+	}
+
+	// No time of day
+	listed = time.Date(2020, 12, 31, 0, 0, 0, 0, time.UTC)
+	if _, ok := listOffset(listed, actual); ok {
+		t.Error("expected no offset")
+	}
+
+	// Too far
+	listed = time.Date(2020, 12, 20, 10, 0, 0, 0, time.UTC)
+	if _, ok := listOffset(listed, actual); ok {
+		t.Error("expected no offset")
+	}
+}
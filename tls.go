@@ -0,0 +1,190 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+// ErrTLSRequired is matched by the errors reporting that strict TLS is
+// enabled and the session would otherwise continue without encryption.
+var ErrTLSRequired = errors.New("ftp: TLS required")
+
+// TLSRequiredError describes why a session with strict TLS was refused.
+// errors.Is(err, ErrTLSRequired) reports true for a *TLSRequiredError.
+type TLSRequiredError struct {
+	Reason string
+	Err    error // the server reply refusing protection, if any
+}
+
+func (e *TLSRequiredError) Error() string {
+	if e.Err != nil {
+		return "ftp: TLS required: " + e.Reason + ": " + e.Err.Error()
+	}
+	return "ftp: TLS required: " + e.Reason
+}
+
+// Is makes errors.Is(err, ErrTLSRequired) report true.
+func (e *TLSRequiredError) Is(target error) bool {
+	return target == ErrTLSRequired
+}
+
+// Unwrap returns the server reply refusing protection, if any.
+func (e *TLSRequiredError) Unwrap() error {
+	return e.Err
+}
+
+// DialWithStrictTLS returns a DialOption that configures the ServerConn to
+// never fall back to plaintext.
+// Dial fails if no TLS config is given or if the control connection, such as
+// one given to DialWithNetConn or returned by DialWithDialFunc, is not a
+// *tls.Conn, so that credentials are never sent in clear. Login fails if the
+// server refuses to protect the data connections with PBSZ and PROT P.
+// These failures are reported with a *TLSRequiredError.
+func DialWithStrictTLS(strict bool) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.strictTLS = strict
+	}}
+}
+
+// ErrCertificateNotPinned is returned when no certificate presented by the
+// server matches the pins given to DialWithCertificatePins.
+var ErrCertificateNotPinned = errors.New("ftp: server certificate does not match any pin")
+
+// SPKIHash returns the SHA-256 hash of the SubjectPublicKeyInfo of cert, as
+// expected by DialWithCertificatePins.
+func SPKIHash(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// DialWithCertificatePins returns a DialOption that configures the ServerConn
+// to only accept servers presenting a certificate whose SPKIHash is one of
+// pins, on both the control and data connections.
+// The certificate chain is still verified against the CAs of the TLS config,
+// and the pin may match any certificate of the verified chain, unless
+// skipCAValidation is true, in which case the pin must match the server
+// certificate itself. The latter allows self-signed certificates.
+// It has no effect unless used with DialWithTLS.
+func DialWithCertificatePins(skipCAValidation bool, pins ...[]byte) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.certificatePins = pins
+		do.skipCAValidation = skipCAValidation
+	}}
+}
+
+// pinTLSConfig makes config check the pins of the dial options during the
+// handshakes.
+func pinTLSConfig(config *tls.Config, do *dialOptions, addr string) {
+	pins := do.certificatePins
+
+	// Resumed sessions skip VerifyPeerCertificate: never resume a session
+	// that was not established with this config
+	config.ClientSessionCache = &sessionCache{
+		cache: tls.NewLRUClientSessionCache(0),
+		key:   addr,
+	}
+
+	if do.skipCAValidation {
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return ErrCertificateNotPinned
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			if !isPinned(cert, pins) {
+				return ErrCertificateNotPinned
+			}
+			return nil
+		}
+		return
+	}
+
+	config.VerifyPeerCertificate = func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if isPinned(cert, pins) {
+					return nil
+				}
+			}
+		}
+		return ErrCertificateNotPinned
+	}
+}
+
+func isPinned(cert *x509.Certificate, pins [][]byte) bool {
+	hash := SPKIHash(cert)
+	for _, pin := range pins {
+		if bytes.Equal(hash, pin) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectDataConns requests TLS on the data connections.
+// Refusals are ignored unless strict TLS is enabled.
+func (c *ServerConn) protectDataConns() error {
+	_, _, err := c.cmd(StatusCommandOK, "PBSZ 0")
+	if err == nil {
+		_, _, err = c.cmd(StatusCommandOK, "PROT P")
+	}
+	if err != nil && c.options.strictTLS {
+		return &TLSRequiredError{Reason: "data connection protection refused", Err: err}
+	}
+	return nil
+}
+
+// hostname returns the host part of addr.
+func hostname(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// newSessionTLSConfig returns a copy of config whose TLS sessions are shared
+// by all the connections to the server at addr.
+//
+// Many servers (vsftpd with require_ssl_reuse, FileZilla Server, ProFTPD
+// without NoSessionReuseRequired) reject data connections which do not resume
+// the TLS session of the control connection. The session cache of the
+// crypto/tls package is keyed by server name or address, which differs
+// between the control and data connections, so the cache is wrapped to
+// always use the same key.
+func newSessionTLSConfig(config *tls.Config, addr string) *tls.Config {
+	config = config.Clone()
+
+	cache := config.ClientSessionCache
+	if cache == nil {
+		cache = tls.NewLRUClientSessionCache(0)
+	}
+	config.ClientSessionCache = &sessionCache{
+		cache: cache,
+		key:   addr,
+	}
+
+	return config
+}
+
+// sessionCache is a tls.ClientSessionCache storing all sessions under a
+// single key.
+type sessionCache struct {
+	cache tls.ClientSessionCache
+	key   string
+}
+
+func (s *sessionCache) Get(string) (*tls.ClientSessionState, bool) {
+	return s.cache.Get(s.key)
+}
+
+func (s *sessionCache) Put(_ string, cs *tls.ClientSessionState) {
+	s.cache.Put(s.key, cs)
+}
@@ -0,0 +1,268 @@
+package ftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate valid for localhost
+// and the loopback addresses, and a pool trusting it
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+// openTLSConn returns a client connected to a mock server with implicit TLS
+func openTLSConn(t *testing.T, clientConfig *tls.Config, options ...DialOption) (*ftpMock, *ServerConn) {
+	cert, pool := newTestCertificate(t)
+	if clientConfig.RootCAs == nil {
+		clientConfig.RootCAs = pool
+	}
+
+	mock, err := newFtpMockTLS(t, "127.0.0.1", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	c, err := Dial(mock.Addr(), append(options, DialWithTLS(clientConfig))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	return mock, c
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	// Without a ServerName, the control and data connections have different
+	// session cache keys by default
+	mock, c := openTLSConn(t, &tls.Config{InsecureSkipVerify: true})
+
+	for i := 0; i < 2; i++ {
+		r, err := c.Retr("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != testData {
+			t.Errorf("read %q, expected %q", buf, testData)
+		}
+	}
+
+	closeConn(t, mock, c, []string{"PBSZ", "PROT", "EPSV", "RETR", "EPSV", "RETR"})
+
+	if len(mock.dataResumed) != 2 || !mock.dataResumed[0] || !mock.dataResumed[1] {
+		t.Error("expected data connections to resume the TLS session:", mock.dataResumed)
+	}
+}
+
+func testTLSServerName(t *testing.T, disabled bool) {
+	cert, pool := newTestCertificate(t)
+
+	mock, err := newFtpMockTLS(t, "127.0.0.1", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	_, port, _ := net.SplitHostPort(mock.Addr())
+	config := &tls.Config{RootCAs: pool}
+	c, err := Dial(net.JoinHostPort("localhost", port), DialWithTLS(config), DialWithDisabledTLSServerName(disabled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	if config.ServerName != "" {
+		t.Error("the TLS config given to DialWithTLS must not be modified")
+	}
+
+	r, err := c.Retr("file")
+	if err == nil {
+		_, err = ioutil.ReadAll(r)
+		r.Close()
+	}
+	if disabled && err == nil {
+		t.Error("expected verification error, got nil")
+	}
+	if !disabled && err != nil {
+		t.Error(err)
+	}
+
+	c.Quit()
+	mock.Wait()
+}
+
+func TestTLSServerName(t *testing.T) {
+	testTLSServerName(t, false)
+}
+
+func TestTLSServerNameDisabled(t *testing.T) {
+	testTLSServerName(t, true)
+}
+
+func TestStrictTLS(t *testing.T) {
+	mock, c := openTLSConn(t, &tls.Config{}, DialWithStrictTLS(true))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	c.Quit()
+	mock.Wait()
+}
+
+func TestStrictTLSWithoutConfig(t *testing.T) {
+	_, err := Dial("127.0.0.1:21", DialWithStrictTLS(true))
+	if !errors.Is(err, ErrTLSRequired) {
+		t.Errorf("expected ErrTLSRequired, got %v", err)
+	}
+}
+
+func TestStrictTLSPlaintextConn(t *testing.T) {
+	// Nothing must be read from or written to the plaintext connection
+	conn, server := net.Pipe()
+	defer server.Close()
+
+	_, err := Dial("127.0.0.1:21", DialWithNetConn(conn), DialWithTLS(&tls.Config{}), DialWithStrictTLS(true))
+	if !errors.Is(err, ErrTLSRequired) {
+		t.Errorf("expected ErrTLSRequired, got %v", err)
+	}
+}
+
+func TestStrictTLSProtRefused(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	mock, err := newFtpMockTLS(t, "127.0.0.1", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.cannedReplies = map[string][]string{"PROT": {"534 Request denied for policy reasons"}}
+
+	c, err := Dial(mock.Addr(), DialWithTLS(&tls.Config{RootCAs: pool}), DialWithStrictTLS(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Login("anonymous", "anonymous")
+	var tlsErr *TLSRequiredError
+	if !errors.As(err, &tlsErr) {
+		t.Fatalf("expected a *TLSRequiredError, got %v", err)
+	}
+	if code := tlsErr.Err.(*ReplyError).Code; code != 534 {
+		t.Errorf("expected the 534 reply, got %d", code)
+	}
+
+	c.Quit()
+	mock.Wait()
+}
+
+// testCertificatePins transfers a file with the pin returned for the mock
+// server certificate
+func testCertificatePins(t *testing.T, skipCAValidation bool, pin func(*x509.Certificate) []byte) error {
+	cert, pool := newTestCertificate(t)
+
+	mock, err := newFtpMockTLS(t, "127.0.0.1", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	config := &tls.Config{}
+	if !skipCAValidation {
+		config.RootCAs = pool
+	}
+	c, err := Dial(mock.Addr(), DialWithTLS(config), DialWithCertificatePins(skipCAValidation, pin(cert.Leaf)))
+	if err != nil {
+		return err
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	c.Quit()
+	mock.Wait()
+	return nil
+}
+
+func TestCertificatePins(t *testing.T) {
+	other, _ := newTestCertificate(t)
+
+	for _, skipCAValidation := range []bool{false, true} {
+		if err := testCertificatePins(t, skipCAValidation, SPKIHash); err != nil {
+			t.Error(err)
+		}
+
+		err := testCertificatePins(t, skipCAValidation, func(*x509.Certificate) []byte {
+			return SPKIHash(other.Leaf)
+		})
+		if !errors.Is(err, ErrCertificateNotPinned) {
+			t.Errorf("expected ErrCertificateNotPinned, got %v", err)
+		}
+	}
+}
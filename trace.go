@@ -0,0 +1,90 @@
+package ftp
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Tracer creates the spans of the operations of a ServerConn.
+// It is implemented by adapters to tracing libraries such as OpenTelemetry.
+type Tracer interface {
+	// Start starts a span as a child of the span held by ctx, if any, and
+	// returns a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation traced by a Tracer.
+type Span interface {
+	// SetAttributes records attributes, such as the reply code, on the span.
+	SetAttributes(attrs ...slog.Attr)
+	// End completes the span, as failed if err is not nil.
+	End(err error)
+}
+
+// DialWithTracer returns a DialOption that configures the ServerConn to
+// trace Dial, the logins, each command and each data transfer with tracer.
+// The spans are children of the context given to DialWithContext, or to the
+// methods taking a context such as DownloadFile. Commands issued during Dial
+// or a login are children of its span.
+func DialWithTracer(tracer Tracer) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.tracer = tracer
+	}}
+}
+
+// startSpan starts a span as a child of the current trace context.
+// It returns a nil span if no tracer is configured.
+func (c *ServerConn) startSpan(name string, attrs ...slog.Attr) (context.Context, Span) {
+	tracer := c.options.tracer
+	if tracer == nil {
+		return nil, nil
+	}
+
+	ctx, span := tracer.Start(c.traceContext(), name)
+	if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+	return ctx, span
+}
+
+// endSpan ends span, if not nil, with the given final attributes.
+func endSpan(span Span, err error, attrs ...slog.Attr) {
+	if span == nil {
+		return
+	}
+	if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+	span.End(err)
+}
+
+// traceContext returns the parent of the next spans.
+func (c *ServerConn) traceContext() context.Context {
+	if c.traceCtx != nil {
+		return c.traceCtx
+	}
+	if c.options.context != nil {
+		return c.options.context
+	}
+	return context.Background()
+}
+
+// withTraceContext makes ctx the parent of the next spans, until restore is
+// called. A nil ctx keeps the current parent.
+func (c *ServerConn) withTraceContext(ctx context.Context) (restore func()) {
+	parent := c.traceCtx
+	if ctx != nil {
+		c.traceCtx = ctx
+	}
+	return func() { c.traceCtx = parent }
+}
+
+// traceCommand starts the span of a command.
+func (c *ServerConn) traceCommand(line string) Span {
+	if c.options.tracer == nil {
+		return nil
+	}
+
+	_, span := c.startSpan("ftp "+commandVerb(line), slog.String("ftp.command", redactCommand(line)))
+	return span
+}
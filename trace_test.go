@@ -0,0 +1,115 @@
+package ftp
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+type spanKey struct{}
+
+// testTracer records the spans with their parent
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name   string
+	parent string
+	attrs  map[string]slog.Value
+	ended  bool
+	err    error
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &testSpan{name: name, attrs: map[string]slog.Value{}}
+	if parent, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		span.parent = parent.name
+	}
+
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *testTracer) span(name string) *testSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, span := range t.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func (s *testSpan) SetAttributes(attrs ...slog.Attr) {
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &testTracer{}
+	ctx, _ := tracer.Start(context.Background(), "root")
+
+	mock, c := openConn(t, "127.0.0.1", DialWithTracer(tracer), DialWithContext(ctx))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR"})
+
+	for _, expected := range []struct {
+		name   string
+		parent string
+	}{
+		{"ftp dial", "root"},
+		{"ftp FEAT", "ftp dial"},
+		{"ftp login", "root"},
+		{"ftp USER", "ftp login"},
+		{"ftp PASS", "ftp login"},
+		{"ftp RETR", "root"},
+		{"ftp transfer", "root"},
+	} {
+		span := tracer.span(expected.name)
+		if span == nil {
+			t.Errorf("missing span %q", expected.name)
+			continue
+		}
+		if span.parent != expected.parent {
+			t.Errorf("span %q: expected parent %q, got %q", expected.name, expected.parent, span.parent)
+		}
+		if !span.ended || span.err != nil {
+			t.Errorf("span %q: expected a successful end, got ended=%v err=%v", expected.name, span.ended, span.err)
+		}
+	}
+
+	if command := tracer.span("ftp PASS").attrs["ftp.command"].String(); command != "PASS ****" {
+		t.Errorf("expected the password to be redacted, got %q", command)
+	}
+
+	transfer := tracer.span("ftp transfer")
+	if n := transfer.attrs["ftp.bytes"].Int64(); n != int64(len(testData)) {
+		t.Errorf("expected %d bytes, got %d", len(testData), n)
+	}
+	if code := transfer.attrs["ftp.reply_code"].Int64(); code != StatusClosingDataConnection {
+		t.Errorf("expected reply code %d, got %d", StatusClosingDataConnection, code)
+	}
+}
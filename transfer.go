@@ -0,0 +1,228 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransferOption represents an option for a single Retr or Stor transfer
+type TransferOption struct {
+	setup func(to *transferOptions)
+}
+
+// transferOptions contains all the options set by TransferOption.setup
+type transferOptions struct {
+	hashes       []hash.Hash
+	stats        *TransferStats
+	maxDuration  time.Duration
+	expectedSize int64
+	rateLimit    int64
+	rateLimiter  *RateLimiter    // of rateLimit, shared by the attempts
+	ctx          context.Context // bounds the data connection, see UploadFile
+
+	decompressors []Decompressor // see TransferWithDecompression
+}
+
+func newTransferOptions(options []TransferOption) *transferOptions {
+	to := &transferOptions{}
+	for _, option := range options {
+		option.setup(to)
+	}
+	return to
+}
+
+// TransferWithHash returns a TransferOption that feeds the transferred data to
+// the given hashes while streaming, so that the digest of the file is
+// available from h.Sum once the transfer is complete, without a second pass.
+// The hashes are reset before a Stor transfer is retried.
+func TransferWithHash(hashes ...hash.Hash) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.hashes = append(to.hashes, hashes...)
+	}}
+}
+
+// TransferWithStats returns a TransferOption that fills stats with the
+// statistics of the transfer: once Stor returns, or once the Response of Retr
+// is closed.
+func TransferWithStats(stats *TransferStats) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.stats = stats
+	}}
+}
+
+// TransferWithMaxDuration returns a TransferOption that caps the total duration
+// of a Retr or Stor transfer, unlike the idle timeout set by
+// DialWithDataConnectionTimeout.
+// Once d has elapsed since the transfer started, reading or writing the data
+// fails with a *TransferTimeoutError.
+func TransferWithMaxDuration(d time.Duration) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.maxDuration = d
+	}}
+}
+
+// TransferWithExpectedSize returns a TransferOption that makes the Response of
+// Retr fail with a *ShortTransferError, instead of io.EOF, if the server closes
+// the data connection before size bytes of the file, including the offset,
+// were received. size is usually the reply to SIZE.
+func TransferWithExpectedSize(size int64) TransferOption {
+	return TransferOption{func(to *transferOptions) {
+		to.expectedSize = size
+	}}
+}
+
+// ErrShortTransfer is matched by the errors reporting that a server closed a
+// data connection before the end of the file.
+var ErrShortTransfer = errors.New("ftp: short transfer")
+
+// ShortTransferError reports a transfer which ended before the expected size
+// of the file.
+// errors.Is(err, ErrShortTransfer) reports true for a *ShortTransferError.
+type ShortTransferError struct {
+	Path     string
+	Expected int64
+	Received int64 // including the offset of the transfer
+}
+
+func (e *ShortTransferError) Error() string {
+	return fmt.Sprintf("ftp: short transfer of %s: received %d of %d bytes", e.Path, e.Received, e.Expected)
+}
+
+// Is makes errors.Is(err, ErrShortTransfer) report true.
+func (e *ShortTransferError) Is(target error) bool {
+	return target == ErrShortTransfer
+}
+
+// parseTransferSize returns the size announced by a 150 reply such as
+// "Opening BINARY mode data connection for file (12345 bytes).", or -1.
+func parseTransferSize(message string) int64 {
+	end := strings.LastIndex(message, " bytes)")
+	if end < 0 {
+		return -1
+	}
+	start := strings.LastIndexByte(message[:end], '(')
+	if start < 0 {
+		return -1
+	}
+
+	size, err := strconv.ParseInt(message[start+1:end], 10, 64)
+	if err != nil || size < 0 {
+		return -1
+	}
+	return size
+}
+
+// TransferTimeoutError reports a transfer exceeding the duration given to
+// TransferWithMaxDuration.
+// errors.Is(err, os.ErrDeadlineExceeded) reports true for a
+// *TransferTimeoutError.
+type TransferTimeoutError struct {
+	Path        string
+	MaxDuration time.Duration
+}
+
+func (e *TransferTimeoutError) Error() string {
+	return fmt.Sprintf("ftp: transfer of %s exceeded %s", e.Path, e.MaxDuration)
+}
+
+// Timeout reports true, as the timeout errors of the net package.
+func (e *TransferTimeoutError) Timeout() bool {
+	return true
+}
+
+// Is makes errors.Is(err, os.ErrDeadlineExceeded) report true.
+func (e *TransferTimeoutError) Is(target error) bool {
+	return target == os.ErrDeadlineExceeded
+}
+
+// deadline returns the deadline of a transfer started at start, or the zero
+// time if its duration is not capped.
+func (to *transferOptions) deadline(start time.Time) time.Time {
+	if to.maxDuration <= 0 {
+		return time.Time{}
+	}
+	return start.Add(to.maxDuration)
+}
+
+// timeoutError returns a *TransferTimeoutError if err is caused by the
+// deadline of the transfer, and err otherwise.
+func (to *transferOptions) timeoutError(path string, deadline time.Time, err error) error {
+	if err == nil || deadline.IsZero() || time.Now().Before(deadline) || !os.IsTimeout(err) {
+		return err
+	}
+	return &TransferTimeoutError{Path: path, MaxDuration: to.maxDuration}
+}
+
+// DialWithCopyBufferSize returns a DialOption that sets the size of the buffer
+// copying the data of Stor, DownloadFile and Response.WriteTo, instead of the
+// 32KB of io.Copy. Larger buffers improve the throughput on links with a high
+// bandwidth-delay product.
+func DialWithCopyBufferSize(size int) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.copyBufferSize = size
+	}}
+}
+
+// copyData copies src to dst with the buffer size set by
+// DialWithCopyBufferSize.
+// A file is sent to a plain TCP connection with the zero-copy facility of the
+// operating system (sendfile) instead.
+func (c *ServerConn) copyData(dst io.Writer, src io.Reader) (int64, error) {
+	if tcpConn, ok := dst.(*net.TCPConn); ok {
+		if f, ok := src.(*os.File); ok {
+			return tcpConn.ReadFrom(f)
+		}
+	}
+
+	size := c.options.copyBufferSize
+	if size <= 0 {
+		buf := getBuffer(defaultCopyBufferSize)
+		defer putBuffer(buf)
+		return io.CopyBuffer(dst, src, *buf)
+	}
+
+	buf := getBuffer(size)
+	defer putBuffer(buf)
+
+	// The ReadFrom and WriteTo methods are hidden, as they ignore the buffer
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// hashWriter returns a writer feeding all the hashes, or nil if there is none.
+func (to *transferOptions) hashWriter() io.Writer {
+	if len(to.hashes) == 0 {
+		return nil
+	}
+
+	writers := make([]io.Writer, len(to.hashes))
+	for i, h := range to.hashes {
+		writers[i] = h
+	}
+	return io.MultiWriter(writers...)
+}
+
+// rateLimiters returns the limiter of the transfer, if any.
+func (to *transferOptions) rateLimiters() []*RateLimiter {
+	if to.rateLimit <= 0 {
+		return nil
+	}
+	if to.rateLimiter == nil {
+		to.rateLimiter = NewRateLimiter(to.rateLimit)
+	}
+	return []*RateLimiter{to.rateLimiter}
+}
+
+// resetHashes resets all the hashes before a new attempt of a transfer.
+func (to *transferOptions) resetHashes() {
+	for _, h := range to.hashes {
+		h.Reset()
+	}
+}
@@ -0,0 +1,406 @@
+package ftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetrWithHash(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	r, err := c.Retr("file", TransferWithHash(md5Hash, sha256Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if expected := md5.Sum([]byte(testData)); !bytes.Equal(md5Hash.Sum(nil), expected[:]) {
+		t.Errorf("md5 %x, expected %x", md5Hash.Sum(nil), expected)
+	}
+	if expected := sha256.Sum256([]byte(testData)); !bytes.Equal(sha256Hash.Sum(nil), expected[:]) {
+		t.Errorf("sha256 %x, expected %x", sha256Hash.Sum(nil), expected)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR"})
+}
+
+func TestStorWithHashRetried(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+	mock.cannedReplies = map[string][]string{
+		"STOR": {"452 Insufficient storage"},
+	}
+
+	h := sha256.New()
+	_, err := c.Stor("file", bytes.NewReader([]byte(testData)), TransferWithHash(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := sha256.Sum256([]byte(testData)); !bytes.Equal(h.Sum(nil), expected[:]) {
+		t.Errorf("sha256 %x, expected %x", h.Sum(nil), expected)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "EPSV", "STOR"})
+}
+
+func TestTransferWithStats(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	var retrStats TransferStats
+	r, err := c.Retr("file", TransferWithStats(&retrStats))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	if retrStats.Bytes != int64(len(testData)) || retrStats.Resumed {
+		t.Errorf("unexpected Retr stats %+v", retrStats)
+	}
+	if retrStats.Elapsed <= 0 || retrStats.Throughput() <= 0 {
+		t.Errorf("expected a positive duration and throughput, got %+v", retrStats)
+	}
+
+	var storStats TransferStats
+	if _, err := c.StorFrom("file", bytes.NewBufferString(testData), 5, TransferWithStats(&storStats)); err != nil {
+		t.Fatal(err)
+	}
+
+	if storStats.Bytes != int64(len(testData)) || storStats.Offset != 5 || !storStats.Resumed {
+		t.Errorf("unexpected StorFrom stats %+v", storStats)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "REST", "EPSV", "REST", "STOR"})
+}
+
+var errReader = errors.New("read failure")
+
+func TestSessionStats(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+	r.Close()
+
+	if _, err := c.Stor("file", bytes.NewBufferString(testData)); err != nil {
+		t.Fatal(err)
+	}
+	var stats TransferStats
+	if _, err := c.Stor("file", &failingReader{}, TransferWithStats(&stats)); !errors.Is(err, errReader) {
+		t.Errorf("expected errReader, got %v", err)
+	}
+	if stats.Bytes != int64(len(testData)) {
+		t.Errorf("sent %d bytes, expected %d", stats.Bytes, len(testData))
+	}
+
+	expected := SessionStats{
+		BytesUploaded:   2 * int64(len(testData)),
+		BytesDownloaded: int64(len(testData)),
+		Uploads:         2,
+		Downloads:       1,
+		Failures:        1,
+	}
+	if stats := c.Stats(); stats != expected {
+		t.Errorf("stats %+v, expected %+v", stats, expected)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "EPSV", "STOR", "EPSV", "STOR", "ABOR", "NOOP"})
+}
+
+// failingReader returns some data, then an error
+type failingReader struct {
+	sent bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.sent {
+		return 0, errReader
+	}
+	r.sent = true
+	return copy(p, testData), nil
+}
+
+func TestStorAborted(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	if _, err := c.Stor("file", &failingReader{}); !errors.Is(err, errReader) {
+		t.Errorf("expected errReader, got %v", err)
+	}
+
+	// The replies to the aborted transfer must have been read
+	dir, err := c.CurrentDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/incoming" {
+		t.Errorf("unexpected directory %q", dir)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "ABOR", "NOOP", "PWD"})
+}
+
+func TestRetrAbort(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.(*Response).Abort(); err != nil {
+		t.Error(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := c.CurrentDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "ABOR", "NOOP", "PWD"})
+}
+
+// slowReader returns some data, then more data after a delay
+type slowReader struct {
+	reads int
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	r.reads++
+	switch r.reads {
+	case 1:
+	case 2:
+		time.Sleep(r.delay)
+	default:
+		return 0, io.EOF
+	}
+	return copy(p, testData), nil
+}
+
+func TestStorMaxDuration(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	_, err := c.Stor("file", &slowReader{delay: 100 * time.Millisecond}, TransferWithMaxDuration(20*time.Millisecond))
+	var timeoutErr *TransferTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a *TransferTimeoutError, got %v", err)
+	}
+	if timeoutErr.Path != "file" || timeoutErr.MaxDuration != 20*time.Millisecond {
+		t.Errorf("unexpected error %+v", timeoutErr)
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("expected the error to match os.ErrDeadlineExceeded")
+	}
+
+	// A transfer within the limit succeeds
+	_, err = c.Stor("file", &slowReader{}, TransferWithMaxDuration(time.Minute))
+	if err != nil {
+		t.Error(err)
+	}
+
+	r, err := c.Retr("file", TransferWithMaxDuration(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "ABOR", "NOOP", "EPSV", "STOR", "EPSV", "RETR"})
+}
+
+func TestCloseTimeout(t *testing.T) {
+	// The server never acknowledges the end of the transfer
+	mock, c := openConnReplies(t, map[string][]string{
+		"RETR": {"150 Opening data connection"},
+	}, DialWithCloseTimeout(50*time.Millisecond))
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = r.Close()
+	if !errors.Is(err, ErrConnectionBroken) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected ErrConnectionBroken, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close was not interrupted, took %s", elapsed)
+	}
+
+	if err := c.NoOp(); !errors.Is(err, ErrConnectionBroken) {
+		t.Errorf("expected ErrConnectionBroken, got %v", err)
+	}
+
+	c.Quit()
+	mock.Wait()
+}
+
+func TestCopyBufferSize(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithCopyBufferSize(7))
+
+	var stats TransferStats
+	if _, err := c.Stor("file", bytes.NewBufferString(testData), TransferWithStats(&stats)); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes != int64(len(testData)) {
+		t.Errorf("stored %d bytes, expected %d", stats.Bytes, len(testData))
+	}
+	if string(mock.stored) != testData {
+		t.Errorf("stored %q, expected %q", mock.stored, testData)
+	}
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != testData {
+		t.Errorf("read %q, expected %q", buf.String(), testData)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "EPSV", "RETR"})
+}
+
+func TestParseTransferSize(t *testing.T) {
+	for message, expected := range map[string]int64{
+		"Opening BINARY mode data connection for file (12345 bytes).": 12345,
+		"Opening data connection for a (b) (0 bytes)":                 0,
+		"Opening BINARY mode data connection for file":                -1,
+		"Opening data connection (many bytes)":                        -1,
+	} {
+		if size := parseTransferSize(message); size != expected {
+			t.Errorf("parseTransferSize(%q) = %d, expected %d", message, size, expected)
+		}
+	}
+}
+
+func TestRetrExpectedSize(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	r, err := c.Retr("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := r.(*Response).ExpectedSize(); size != -1 {
+		t.Errorf("expected size %d, expected -1", size)
+	}
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Error(err)
+	}
+	r.Close()
+
+	r, err = c.Retr("file", TransferWithExpectedSize(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := r.(*Response).ExpectedSize(); size != 42 {
+		t.Errorf("expected size %d, expected 42", size)
+	}
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrShortTransfer) {
+		t.Errorf("expected ErrShortTransfer, got %v", err)
+	}
+	r.Close()
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "EPSV", "RETR"})
+}
+
+func TestStorFromAppend(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1", DialWithAppendResume(true))
+
+	// SIZE reports 42 bytes for magic-file
+	if _, err := c.StorFrom("magic-file", bytes.NewBufferString(testData), 42); err != nil {
+		t.Fatal(err)
+	}
+	if string(mock.stored) != testData {
+		t.Errorf("stored %q, expected %q", mock.stored, testData)
+	}
+
+	if _, err := c.StorFrom("magic-file", bytes.NewBufferString(testData), 5); err == nil {
+		t.Error("expected an error for an offset different from the size")
+	}
+
+	closeConn(t, mock, c, []string{"SIZE", "EPSV", "APPE", "SIZE"})
+}
+
+func TestExecData(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	r, err := c.ExecData(context.Background(), "RETR file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testData {
+		t.Errorf("read %q, expected %q", buf, testData)
+	}
+
+	// The command must open the transfer
+	if _, err := c.ExecData(context.Background(), "SITE DMLSD"); ReplyCode(err) != StatusBadCommand {
+		t.Errorf("expected a 500 reply, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "RETR", "EPSV", "SITE"})
+}
+
+func TestResumeNotSupported(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"REST": {"502 REST not implemented"},
+	})
+
+	if _, err := c.RetrFrom("file", 10); !errors.Is(err, ErrResumeNotSupported) {
+		t.Errorf("expected ErrResumeNotSupported, got %v", err)
+	}
+	// The result of the probe is kept
+	if _, err := c.StorFrom("file", bytes.NewBufferString("data"), 10); !errors.Is(err, ErrResumeNotSupported) {
+		t.Errorf("expected ErrResumeNotSupported, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"REST"})
+}
+
+func TestResumeAdvertised(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"REST": {"500 Unknown command"},
+	}, DialWithFeatureOverride("REST", true))
+
+	_, err := c.RetrFrom("file", 10)
+	if !errors.Is(err, ErrResumeNotSupported) || ReplyCode(err) != StatusBadCommand {
+		t.Errorf("expected ErrResumeNotSupported with the reply, got %v", err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "REST"})
+}
@@ -0,0 +1,89 @@
+package ftp
+
+import (
+	"net/textproto"
+	"time"
+)
+
+// unsolicitedIdle is the idle time of the control connection after which
+// the server is checked for unsolicited replies, such as idle warnings,
+// before sending a command.
+const unsolicitedIdle = time.Second
+
+// unsolicitedWait bounds the wait for an unsolicited reply.
+const unsolicitedWait = time.Millisecond
+
+// DialWithUnsolicitedReplyHandler returns a DialOption that configures the
+// ServerConn to call handler with the replies which do not answer a command,
+// such as idle warnings, or stale replies left by an interrupted command.
+// These replies are read and skipped before sending a command, so that they
+// are not taken for its reply, and the handler must not use the ServerConn.
+// A 421 reply still makes the command fail, see DialWithServiceClosingHandler.
+func DialWithUnsolicitedReplyHandler(handler func(code int, message string)) DialOption {
+	return DialOption{func(do *dialOptions) {
+		do.unsolicitedHandler = handler
+	}}
+}
+
+// skipUnsolicited reads the replies received before a command is sent: the
+// ones already buffered, or arriving shortly if the control connection was
+// idle. c.mu must be held.
+func (c *ServerConn) skipUnsolicited() error {
+	if c.transferring {
+		// The reply ending the transfer is pending
+		return nil
+	}
+
+	for c.conn.R.Buffered() > 0 || c.pollUnsolicited() {
+		code, message, err := c.readReply(-1)
+		if _, ok := err.(textproto.ProtocolError); ok {
+			// Not a reply, such as a banner without code
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		message = c.decode(message)
+		c.lastActivity = time.Now()
+		c.log(c.logLevels().Command, "ftp unsolicited reply", "code", code)
+		if handler := c.options.unsolicitedHandler; handler != nil {
+			handler(code, message)
+		}
+		if code == StatusNotAvailable {
+			return newReplyError(code, message)
+		}
+	}
+	return nil
+}
+
+// pollUnsolicited reports whether a reply arrives shortly on a control
+// connection which has been idle.
+func (c *ServerConn) pollUnsolicited() bool {
+	if c.netConn == nil || time.Since(c.lastActivity) < unsolicitedIdle {
+		return false
+	}
+
+	c.netConn.SetReadDeadline(time.Now().Add(unsolicitedWait))
+	_, err := c.conn.R.Peek(1)
+
+	// Restore the deadline set by watchCommand, if any
+	deadline := c.controlDeadline()
+	if c.ctx != nil {
+		if c.ctx.Err() != nil {
+			return false
+		}
+		if t, ok := c.ctx.Deadline(); ok && (deadline.IsZero() || t.Before(deadline)) {
+			deadline = t
+		}
+	}
+	c.netConn.SetReadDeadline(deadline)
+	if c.ctx != nil && c.ctx.Err() != nil {
+		// Canceled since the check: the past deadline set by watchCommand
+		// was overwritten
+		c.netConn.SetReadDeadline(time.Unix(1, 0))
+		return false
+	}
+	c.lastActivity = time.Now()
+	return err == nil
+}
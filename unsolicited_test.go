@@ -0,0 +1,108 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUnsolicitedReply(t *testing.T) {
+	var replies []string
+	handler := func(code int, message string) {
+		replies = append(replies, message)
+	}
+	mock, c := openConnReplies(t, map[string][]string{
+		"NOOP": {"200 NOOP ok.\r\nNot a reply\r\n220-Idle warning:\r\n220 you will be disconnected soon"},
+	}, DialWithUnsolicitedReplyHandler(handler))
+
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The warning is not taken for the reply to PWD
+	dir, err := c.CurrentDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/incoming" {
+		t.Errorf("current dir %q", dir)
+	}
+	if len(replies) != 1 || replies[0] != "Idle warning:\nyou will be disconnected soon" {
+		t.Errorf("unsolicited replies %q", replies)
+	}
+
+	closeConn(t, mock, c, []string{"NOOP", "PWD"})
+}
+
+func TestUnsolicitedServiceClosing(t *testing.T) {
+	mock, c := openConnReplies(t, map[string][]string{
+		"NOOP": {"200 NOOP ok.\r\n421 Idle timeout."},
+	})
+
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CurrentDir(); !errors.Is(err, ErrServiceClosing) {
+		t.Errorf("expected ErrServiceClosing, got %v", err)
+	}
+
+	// PWD was not sent
+	closeConn(t, mock, c, []string{"NOOP"})
+}
+
+// pollCancelConn cancels a context when the read deadline is restored after
+// the poll of the unsolicited replies, and leaves time to the cancellation
+// to set its past deadline before the restored one.
+type pollCancelConn struct {
+	net.Conn
+	cancel context.CancelFunc
+	calls  int
+}
+
+func (c *pollCancelConn) SetReadDeadline(t time.Time) error {
+	if c.calls++; c.cancel != nil && c.calls == 2 {
+		c.cancel()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func TestUnsolicitedPollCanceled(t *testing.T) {
+	mock, err := newFtpMock(t, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	mock.ignoreCommand = "NOOP"
+
+	netConn, err := net.Dial("tcp", mock.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &pollCancelConn{Conn: netConn}
+	c, err := Dial(mock.Addr(), DialWithNetConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is polled before NOOP, and the cancellation is not lost
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn.cancel, conn.calls = cancel, 0
+	c.lastActivity = time.Now().Add(-unsolicitedIdle)
+
+	start := time.Now()
+	if err := c.Ping(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled after %v", elapsed)
+	}
+
+	c.Quit()
+}
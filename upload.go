@@ -0,0 +1,41 @@
+package ftp
+
+import (
+	"context"
+	"os"
+)
+
+// UploadFile stores the content of localPath into remotePath, as Stor does
+// with the options.
+//
+// When the data connection is a plain TCP connection and the data is neither
+// compressed, hashed nor verified, the file is sent with the zero-copy
+// facility of the operating system (sendfile), otherwise it is copied through
+// a buffer.
+// The deadline and cancellation of ctx bound the transfer. A canceled
+// transfer is aborted, keeping the connection usable.
+func (c *ServerConn) UploadFile(ctx context.Context, localPath, remotePath string, options ...TransferOption) (*TransferStats, error) {
+	defer c.withContext(ctx)()
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	to := newTransferOptions(options)
+	to.ctx = ctx
+	if to.stats == nil {
+		to.stats = &TransferStats{}
+	}
+	_, err = c.storFrom(remotePath, f, 0, to)
+	return to.stats, err
+}
@@ -0,0 +1,107 @@
+package ftp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUploadFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(testData); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	mock, c := openConn(t, "127.0.0.1")
+
+	stats, err := c.UploadFile(context.Background(), f.Name(), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bytes != int64(len(testData)) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR"})
+
+	if string(mock.stored) != testData {
+		t.Errorf("stored %q, expected %q", mock.stored, testData)
+	}
+}
+
+func TestUploadFileCanceled(t *testing.T) {
+	f, err := ioutil.TempFile("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	// Larger than the socket buffers, so that the upload blocks
+	if err := f.Truncate(64 << 20); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	mock, c := openConn(t, "127.0.0.1")
+	mock.stallStor = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := c.UploadFile(ctx, f.Name(), "file"); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// The transfer was aborted, the next command succeeds
+	if err := c.NoOp(); err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR", "ABOR", "NOOP", "NOOP"})
+}
+
+func TestUploadFileMissing(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	_, err := c.UploadFile(context.Background(), "/does/not/exist", "file")
+	if !os.IsNotExist(err) {
+		t.Error("expected not exist error, got:", err)
+	}
+
+	closeConn(t, mock, c, nil)
+}
+
+func TestStorFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "ftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.WriteString(testData); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	mock, c := openConn(t, "127.0.0.1")
+
+	if _, err := c.Stor("file", f); err != nil {
+		t.Fatal(err)
+	}
+	if string(mock.stored) != testData {
+		t.Errorf("stored %q, expected %q", mock.stored, testData)
+	}
+
+	closeConn(t, mock, c, []string{"EPSV", "STOR"})
+}
@@ -0,0 +1,292 @@
+package ftp
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileOp is the kind of change reported by a FileEvent.
+type FileOp int
+
+// The changes of local files mirrored by WatchUpload
+const (
+	FileWritten FileOp = iota + 1 // created or modified
+	FileRemoved                   // deleted or renamed away
+)
+
+// FileEvent is a change of a local file, as reported by fsnotify-like
+// watchers.
+type FileEvent struct {
+	Path string
+	Op   FileOp
+}
+
+// ConflictPolicy tells WatchUpload what to do with a remote file modified
+// after the local one.
+type ConflictPolicy int
+
+// The conflict policies of WatchUpload
+const (
+	// ConflictOverwrite uploads the local file anyway.
+	ConflictOverwrite ConflictPolicy = iota
+	// ConflictSkip keeps the remote file.
+	ConflictSkip
+	// ConflictRename renames the remote file with a ".conflict" suffix and
+	// the current time, then uploads the local file.
+	ConflictRename
+)
+
+// CompareMode tells WatchUpload how to compare the local and remote files.
+type CompareMode int
+
+// The compare modes of WatchUpload
+const (
+	// CompareModTime applies the ConflictPolicy to the remote files modified
+	// after the local ones.
+	CompareModTime CompareMode = iota
+	// CompareChecksum skips the upload of the files whose content is already
+	// on the server, according to the checksums computed by HASH, XCRC or
+	// XMD5, for servers whose timestamps are unreliable. The ConflictPolicy
+	// is not applied. Without support for these commands, CompareModTime is
+	// used instead.
+	CompareChecksum
+)
+
+// WatchOptions configures WatchUpload.
+type WatchOptions struct {
+	// LocalRoot is the local directory mirrored into RemoteRoot. The events
+	// outside of it are ignored.
+	LocalRoot  string
+	RemoteRoot string
+
+	// Debounce is how long a file must be left unchanged before it is
+	// mirrored, so that a file written in several steps is uploaded once.
+	// Defaults to 500ms.
+	Debounce time.Duration
+
+	// Conflict applies to the remote files modified after the local ones,
+	// according to GetModTime. The default overwrites them without checking.
+	Conflict ConflictPolicy
+
+	// Compare selects how the local and remote files are compared.
+	Compare CompareMode
+
+	// OnError, if not nil, is called with the events which could not be
+	// mirrored. The errors of the connection stop WatchUpload.
+	OnError func(event FileEvent, err error)
+}
+
+// defaultDebounce is the default of WatchOptions.Debounce.
+const defaultDebounce = 500 * time.Millisecond
+
+// WatchUpload mirrors the local changes received from events to the server,
+// until events is closed or ctx is done: written files are uploaded with
+// UploadFile, creating the missing remote directories, and removed files are
+// deleted.
+// The pending changes are mirrored when events is closed.
+// It returns the error of the connection if it failed, or of ctx.
+func (c *ServerConn) WatchUpload(ctx context.Context, events <-chan FileEvent, opts *WatchOptions) error {
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+
+	pending := make(map[string]watchedChange)
+	timer := time.NewTimer(debounce)
+	defer timer.Stop()
+
+	// flush mirrors the changes older than before
+	flush := func(before time.Time) error {
+		for name, change := range pending {
+			if change.at.After(before) {
+				continue
+			}
+			delete(pending, name)
+
+			event := FileEvent{Path: name, Op: change.op}
+			if err := c.mirrorEvent(ctx, event, opts); err != nil {
+				if c.isConnectionError(err) || ctx.Err() != nil {
+					return err
+				}
+				if opts.OnError != nil {
+					opts.OnError(event, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case event, ok := <-events:
+			if !ok {
+				return flush(time.Now())
+			}
+			pending[event.Path] = watchedChange{op: event.Op, at: time.Now()}
+
+		case <-timer.C:
+			if err := flush(time.Now().Add(-debounce)); err != nil {
+				return err
+			}
+			timer.Reset(debounce / 2)
+		}
+	}
+}
+
+// watchedChange is the last change of a file pending in WatchUpload.
+type watchedChange struct {
+	op FileOp
+	at time.Time
+}
+
+// mirrorEvent applies a local change to the server.
+// The events of LocalRoot itself are ignored: its removal must not remove
+// RemoteRoot.
+func (c *ServerConn) mirrorEvent(ctx context.Context, event FileEvent, opts *WatchOptions) error {
+	rel, err := filepath.Rel(opts.LocalRoot, event.Path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	remotePath := path.Join(opts.RemoteRoot, filepath.ToSlash(rel))
+	defer c.withContext(ctx)()
+
+	if event.Op == FileRemoved {
+		_, err := c.Delete(remotePath)
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		// Either missing already, or a directory
+		isDir, err := c.IsDir(ctx, remotePath)
+		if isDir {
+			_, err = c.RemoveDirRecur(remotePath)
+		}
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		return err
+	}
+
+	fi, err := os.Stat(event.Path)
+	if os.IsNotExist(err) {
+		// Removed since, a later event follows
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return c.makeDirAll(remotePath)
+	}
+
+	var skip bool
+	if command, h := c.compareMethod(); opts.Compare == CompareChecksum && h != nil {
+		skip, err = c.sameChecksum(event.Path, remotePath, command, h)
+	} else {
+		skip, err = c.resolveConflict(remotePath, fi.ModTime(), opts.Conflict)
+	}
+	if skip || err != nil {
+		return err
+	}
+
+	_, err = c.UploadFile(ctx, event.Path, remotePath)
+	if ReplyCode(err) == StatusFileUnavailable || ReplyCode(err) == StatusBadFileName {
+		// The remote directory may be missing
+		if err := c.makeDirAll(path.Dir(remotePath)); err != nil {
+			return err
+		}
+		_, err = c.UploadFile(ctx, event.Path, remotePath)
+	}
+	return err
+}
+
+// resolveConflict applies policy if the remote file was modified after
+// localTime, and reports whether the upload must be skipped.
+func (c *ServerConn) resolveConflict(remotePath string, localTime time.Time, policy ConflictPolicy) (skip bool, err error) {
+	if policy == ConflictOverwrite {
+		return false, nil
+	}
+
+	mt, err := c.GetModTime(remotePath)
+	if c.isConnectionError(err) {
+		return false, err
+	}
+	if err != nil || !mt.Time.After(localTime) {
+		// Missing remote files are not conflicts
+		return false, nil
+	}
+
+	if policy == ConflictSkip {
+		return true, nil
+	}
+	_, err = c.Rename(remotePath, remotePath+".conflict-"+time.Now().UTC().Format("20060102150405"))
+	return false, err
+}
+
+// compareMethod returns the command computing the checksums compared by
+// CompareChecksum, and the hash computing them locally: the ones of the
+// integrity check, or else XMD5. The hash is nil if the server supports none.
+func (c *ServerConn) compareMethod() (string, hash.Hash) {
+	if command, _, h := c.checksumMethod(); h != nil {
+		return command, h
+	}
+	if _, ok := c.features["XMD5"]; ok {
+		return "XMD5", md5.New()
+	}
+	return "", nil
+}
+
+// sameChecksum reports whether the local file has the checksum of the remote
+// one, computed with command and the local hash h.
+func (c *ServerConn) sameChecksum(localPath, remotePath, command string, h hash.Hash) (bool, error) {
+	remote, err := c.remoteChecksum(command, remotePath)
+	if c.isConnectionError(err) {
+		return false, err
+	}
+	if err != nil {
+		// Missing remote files are uploaded
+		return false, nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	local := hex.EncodeToString(h.Sum(nil))
+	return strings.EqualFold(local, remote), nil
+}
+
+// makeDirAll creates the directory dir and its missing parents.
+func (c *ServerConn) makeDirAll(dir string) error {
+	if dir == "/" || dir == "." || dir == "" {
+		return nil
+	}
+	if _, err := c.MakeDir(dir); err == nil || ReplyCode(err) == 0 {
+		return err
+	}
+
+	// The parent may be missing, or dir may exist already
+	if err := c.makeDirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	if _, err := c.MakeDir(dir); err != nil && ReplyCode(err) == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package ftp
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchUpload(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte(testData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan FileEvent, 4)
+	// The writes of the same file are mirrored once
+	events <- FileEvent{Path: file, Op: FileWritten}
+	events <- FileEvent{Path: file, Op: FileWritten}
+	events <- FileEvent{Path: filepath.Join(dir, "old"), Op: FileRemoved}
+	// Outside of the mirrored directory
+	events <- FileEvent{Path: filepath.Join(filepath.Dir(dir), "other"), Op: FileWritten}
+	close(events)
+
+	err := c.WatchUpload(context.Background(), events, &WatchOptions{
+		LocalRoot:  dir,
+		RemoteRoot: "/incoming",
+		Debounce:   10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mock.stored) != testData {
+		t.Errorf("stored %q, expected %q", mock.stored, testData)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	mock.Wait()
+	commands := map[string]int{}
+	for _, cmd := range mock.commands {
+		commands[cmd]++
+	}
+	if commands["STOR"] != 1 || commands["DELE"] != 1 {
+		t.Errorf("unexpected sequence of commands: %v", mock.commands)
+	}
+}
+
+func TestWatchUploadRemoved(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+	mock.cannedReplies = map[string][]string{
+		"DELE": {"550 No such file"},
+	}
+
+	dir := t.TempDir()
+	events := make(chan FileEvent, 2)
+	// The removal of the root must not remove the remote root
+	events <- FileEvent{Path: dir, Op: FileRemoved}
+	// A missing file is not removed as a directory
+	events <- FileEvent{Path: filepath.Join(dir, "missing-dir"), Op: FileRemoved}
+	close(events)
+
+	err := c.WatchUpload(context.Background(), events, &WatchOptions{
+		LocalRoot:  dir,
+		RemoteRoot: "/incoming",
+		Debounce:   10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"DELE", "PWD", "CWD"})
+}
+
+func TestWatchUploadConflictSkip(t *testing.T) {
+	mock, c := openConn(t, "127.0.0.1")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte(testData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The remote file is modified on 2020-11-12
+	old := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan FileEvent, 1)
+	events <- FileEvent{Path: file, Op: FileWritten}
+	close(events)
+
+	err := c.WatchUpload(context.Background(), events, &WatchOptions{
+		LocalRoot:  dir,
+		RemoteRoot: "/incoming",
+		Conflict:   ConflictSkip,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeConn(t, mock, c, []string{"MDTM"})
+}
+
+func TestWatchUploadChecksum(t *testing.T) {
+	sum := md5.Sum([]byte(testData))
+	mock, c := openConnReplies(t, map[string][]string{
+		"FEAT": {"211-Features:\r\n EPSV\r\n SIZE\r\n XMD5\r\n211 End"},
+		"XMD5": {"250 " + hex.EncodeToString(sum[:]), "250 d41d8cd98f00b204e9800998ecf8427e"},
+	})
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte(testData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		events := make(chan FileEvent, 1)
+		events <- FileEvent{Path: file, Op: FileWritten}
+		close(events)
+
+		// The file is uploaded once its content differs
+		err := c.WatchUpload(context.Background(), events, &WatchOptions{
+			LocalRoot:  dir,
+			RemoteRoot: "/incoming",
+			Compare:    CompareChecksum,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closeConn(t, mock, c, []string{"XMD5", "XMD5", "EPSV", "STOR"})
+}